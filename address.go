@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/mail"
+	"strings"
+)
+
+type Address struct {
+	Name    string
+	Address string
+}
+
+func (a Address) String() string {
+	if a.Name == "" {
+		return a.Address
+	}
+	if a.Address == "" {
+		return a.Name
+	}
+	return a.Name + " <" + a.Address + ">"
+}
+
+func (a Address) Display() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	return a.Address
+}
+
+var addressParser = &mail.AddressParser{WordDecoder: headerDecoder}
+
+// parseAddressList parses an address header, falling back to a lenient split
+// on commas when the header is not valid RFC 5322, which is common for mail
+// from misbehaving senders.
+func parseAddressList(value string) []Address {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	if list, err := addressParser.ParseList(value); err == nil {
+		addresses := make([]Address, 0, len(list))
+		for _, a := range list {
+			addresses = append(addresses, Address{Name: a.Name, Address: a.Address})
+		}
+		return addresses
+	}
+
+	decoded, _ := decodeHeaderValue("", value)
+	var addresses []Address
+	for _, part := range strings.Split(decoded, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		open, close := strings.LastIndex(part, "<"), strings.LastIndex(part, ">")
+		if open >= 0 && close > open {
+			addresses = append(addresses, Address{
+				Name:    strings.Trim(strings.TrimSpace(part[:open]), `"`),
+				Address: strings.TrimSpace(part[open+1 : close]),
+			})
+			continue
+		}
+		if strings.Contains(part, "@") {
+			addresses = append(addresses, Address{Address: part})
+		} else {
+			addresses = append(addresses, Address{Name: part})
+		}
+	}
+	return addresses
+}
+
+func parseAddressHeader(header map[string][]string, names ...string) []Address {
+	var addresses []Address
+	for _, name := range names {
+		for _, value := range header[name] {
+			addresses = append(addresses, parseAddressList(value)...)
+		}
+	}
+	return addresses
+}
+
+func applyAddressHeaders(e *Email, header map[string][]string) {
+	e.FromAddrs = parseAddressHeader(header, "From")
+	e.ToAddrs = parseAddressHeader(header, "To")
+	e.CcAddrs = parseAddressHeader(header, "Cc")
+	e.ReplyTo = parseAddressHeader(header, "Reply-To")
+	e.DeliveredTo = parseAddressHeader(header, "Delivered-To", "X-Original-To")
+}
+
+func formatAddresses(addresses []Address) string {
+	parts := make([]string, 0, len(addresses))
+	for _, a := range addresses {
+		parts = append(parts, a.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (e Email) fromDisplay() string {
+	if len(e.FromAddrs) == 0 {
+		return e.From
+	}
+	return e.FromAddrs[0].Display()
+}
+
+func (e Email) fromFull() string {
+	if len(e.FromAddrs) == 0 {
+		return e.From
+	}
+	return formatAddresses(e.FromAddrs)
+}
+
+func (e Email) toFull() string {
+	if len(e.ToAddrs) == 0 {
+		return e.To
+	}
+	return formatAddresses(e.ToAddrs)
+}
+
+func addressValues(addresses []Address) []string {
+	values := make([]string, 0, len(addresses))
+	for _, a := range addresses {
+		values = append(values, a.Address)
+	}
+	return values
+}
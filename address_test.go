@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAddressList_NamesAndMultipleRecipients(t *testing.T) {
+	got := parseAddressList(`"Acme Support" <noreply@acme.example>, bob@example.com, =?UTF-8?Q?Zo=C3=AB?= <zoe@example.com>`)
+
+	want := []Address{
+		{Name: "Acme Support", Address: "noreply@acme.example"},
+		{Address: "bob@example.com"},
+		{Name: "Zoë", Address: "zoe@example.com"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %#v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("address %d = %#v, want %#v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParseAddressList_LenientFallback(t *testing.T) {
+	got := parseAddressList(`Broken "Sender <broken@example.com>, undisclosed-recipients`)
+
+	if len(got) != 2 || got[0].Address != "broken@example.com" || got[1].Name != "undisclosed-recipients" {
+		t.Fatalf("got %#v", got)
+	}
+	if parseAddressList("  ") != nil {
+		t.Fatal("blank header should parse to nil")
+	}
+}
+
+func TestAddress_StringAndDisplay(t *testing.T) {
+	named := Address{Name: "Acme", Address: "a@acme.example"}
+	if named.String() != "Acme <a@acme.example>" || named.Display() != "Acme" {
+		t.Fatalf("got %q / %q", named.String(), named.Display())
+	}
+	bare := Address{Address: "a@acme.example"}
+	if bare.String() != "a@acme.example" || bare.Display() != "a@acme.example" {
+		t.Fatalf("got %q / %q", bare.String(), bare.Display())
+	}
+}
+
+func TestParseFullEmail_ParsesAddressHeaders(t *testing.T) {
+	raw := "From: \"Acme Support\" <noreply@acme.example>\r\n" +
+		"To: a@example.com, \"B\" <b@example.com>\r\n" +
+		"Cc: c@example.com\r\n" +
+		"Reply-To: help@acme.example\r\n" +
+		"Delivered-To: inbox@example.com\r\n" +
+		"X-Original-To: support@example.com\r\n" +
+		"Subject: Hi\r\n\r\nbody"
+
+	email, err := parseFullEmail([]byte(raw), "k")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if email.fromDisplay() != "Acme Support" || email.fromFull() != "Acme Support <noreply@acme.example>" {
+		t.Errorf("from = %q / %q", email.fromDisplay(), email.fromFull())
+	}
+	if len(email.ToAddrs) != 2 || len(email.CcAddrs) != 1 || len(email.ReplyTo) != 1 || len(email.DeliveredTo) != 2 {
+		t.Errorf("unexpected addresses %#v", email)
+	}
+}
+
+func TestUpdateTableRows_ShowsDisplayName(t *testing.T) {
+	m := newReadyTestModel()
+	m.emails = []Email{{
+		From:      `"Acme Support" <noreply@acme.example>`,
+		FromAddrs: []Address{{Name: "Acme Support", Address: "noreply@acme.example"}},
+		Date:      time.Now(),
+		Key:       "k",
+	}}
+
+	m.updateTableRows()
+
+	if got := m.table.Rows()[0][0]; got != "Acme Support" {
+		t.Fatalf("From column = %q", got)
+	}
+}
+
+func TestRenderEmailView_ShowsFullAddresses(t *testing.T) {
+	m := newReadyTestModel()
+	m.selectedEmail = &Email{
+		FromAddrs: []Address{{Name: "Acme Support", Address: "noreply@acme.example"}},
+		ToAddrs:   []Address{{Address: "a@example.com"}},
+		CcAddrs:   []Address{{Name: "C", Address: "c@example.com"}},
+		ReplyTo:   []Address{{Address: "help@acme.example"}},
+		Key:       "k",
+	}
+
+	got := m.renderEmailView()
+
+	for _, want := range []string{"Acme Support <noreply@acme.example>", "Cc:      C <c@example.com>", "Reply-To: help@acme.example"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in %q", want, got)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	defaultMaxMessageBytes    = 100 << 20
+	defaultMaxAttachmentBytes = 50 << 20
+)
+
+// magicTypes covers formats http.DetectContentType reports only as
+// application/octet-stream but which matter when judging attachments.
+var magicTypes = []struct {
+	prefix      []byte
+	contentType string
+}{
+	{[]byte("MZ"), "application/x-msdownload"},
+	{[]byte("\x7fELF"), "application/x-executable"},
+	{[]byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"), "application/x-ole-storage"},
+	{[]byte("7z\xbc\xaf\x27\x1c"), "application/x-7z-compressed"},
+	{[]byte("\xca\xfe\xba\xbe"), "application/java-vm"},
+	{[]byte("{\\rtf"), "application/rtf"},
+}
+
+// extensionTypes lists the sniffed types each known extension may carry.
+// Office Open XML documents are zip archives and legacy Office files are OLE
+// compound documents, so those containers are accepted for them.
+var extensionTypes = map[string][]string{
+	".pdf":  {"application/pdf"},
+	".png":  {"image/png"},
+	".jpg":  {"image/jpeg"},
+	".jpeg": {"image/jpeg"},
+	".gif":  {"image/gif"},
+	".webp": {"image/webp"},
+	".bmp":  {"image/bmp"},
+	".zip":  {"application/zip"},
+	".docx": {"application/zip"},
+	".xlsx": {"application/zip"},
+	".pptx": {"application/zip"},
+	".docm": {"application/zip"},
+	".xlsm": {"application/zip"},
+	".pptm": {"application/zip"},
+	".doc":  {"application/x-ole-storage"},
+	".xls":  {"application/x-ole-storage"},
+	".ppt":  {"application/x-ole-storage"},
+	".msg":  {"application/x-ole-storage"},
+	".gz":   {"application/x-gzip"},
+	".rar":  {"application/x-rar-compressed"},
+	".7z":   {"application/x-7z-compressed"},
+	".rtf":  {"application/rtf", "text/plain"},
+	".txt":  {"text/plain"},
+	".csv":  {"text/plain"},
+	".ics":  {"text/plain"},
+	".html": {"text/html"},
+	".htm":  {"text/html"},
+	".xml":  {"text/xml", "text/plain"},
+	".json": {"text/plain"},
+	".exe":  {"application/x-msdownload"},
+	".dll":  {"application/x-msdownload"},
+	".mp3":  {"audio/mpeg"},
+	".mp4":  {"video/mp4"},
+	".wav":  {"audio/wave"},
+}
+
+var executableTypes = map[string]bool{
+	"application/x-msdownload": true,
+	"application/x-executable": true,
+	"application/java-vm":      true,
+}
+
+type attachmentsZippedMsg struct {
+	path    string
+	count   int
+	skipped int
+	err     error
+}
+
+func sniffContentType(data []byte) string {
+	for _, magic := range magicTypes {
+		if bytes.HasPrefix(data, magic.prefix) {
+			return magic.contentType
+		}
+	}
+	contentType := http.DetectContentType(data)
+	if mediaType, _, ok := strings.Cut(contentType, ";"); ok {
+		contentType = mediaType
+	}
+	return contentType
+}
+
+// typeMismatchWarning compares the sniffed type with the filename's
+// extension. Executables are always reported unless named as such; other
+// mismatches only when both the extension and the sniffed type are known.
+func typeMismatchWarning(a Attachment) string {
+	if a.Sniffed == "" {
+		return ""
+	}
+	ext := strings.ToLower(filepath.Ext(a.Name))
+	expected, known := extensionTypes[ext]
+	if executableTypes[a.Sniffed] && !executableExtensions[ext] {
+		return fmt.Sprintf("Attachment %s is really an executable (%s)", a.Name, a.Sniffed)
+	}
+	if !known || a.Sniffed == "application/octet-stream" {
+		return ""
+	}
+	for _, t := range expected {
+		if t == a.Sniffed {
+			return ""
+		}
+	}
+	return fmt.Sprintf("Attachment %s contains %s, not what %s suggests", a.Name, a.Sniffed, ext)
+}
+
+func byteLimitFromEnv(name string, fallback int64) int64 {
+	if value := strings.TrimSpace(os.Getenv(name)); value != "" {
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return fallback
+}
+
+// applyAttachmentLimit keeps attachment data in memory up to limit bytes per
+// message. Later attachments keep their name and type but drop their data,
+// and a diagnostic records what was left out. A limit of 0 disables it.
+func applyAttachmentLimit(e *Email, limit int64) {
+	if limit <= 0 {
+		return
+	}
+	var used int64
+	for i := range e.Attachments {
+		a := &e.Attachments[i]
+		if used+int64(len(a.Data)) <= limit {
+			used += int64(len(a.Data))
+			continue
+		}
+		a.Data = nil
+		a.Omitted = true
+		e.Diagnostics = append(e.Diagnostics, fmt.Sprintf(
+			"Attachment %s (%s) exceeds the %s per-message memory limit and was not loaded",
+			a.Name, formatBytes(a.Size), formatBytes(limit)))
+	}
+}
+
+func (m model) zipSelectedAttachments() tea.Cmd {
+	if m.selectedEmail == nil {
+		return nil
+	}
+	return m.zipAttachments([]Email{*m.selectedEmail})
+}
+
+// zipAttachments writes the attachments of every given email into one zip
+// archive in the save folder, with a directory per message.
+func (m model) zipAttachments(emails []Email) tea.Cmd {
+	if len(emails) == 0 {
+		return nil
+	}
+	emails = append([]Email(nil), emails...)
+	releases := make([]func(), 0, len(emails))
+	for _, e := range emails {
+		releases = append(releases, holdSpool(e))
+	}
+	return func() tea.Msg {
+		ctx := context.Background()
+		var fetched []Email
+		defer func() {
+			for _, e := range fetched {
+				removeSpool(e)
+			}
+			for _, release := range releases {
+				release()
+			}
+		}()
+		for i, email := range emails {
+			if email.BodyLoaded {
+				continue
+			}
+			loaded, err := m.fetchAndParseEmail(ctx, email.Key)
+			if err != nil {
+				return attachmentsZippedMsg{err: err}
+			}
+			fetched = append(fetched, *loaded)
+			emails[i] = *loaded
+		}
+
+		name := strings.TrimSuffix(emailFilename(emails[0]), ".eml") + "-attachments.zip"
+		if len(emails) > 1 {
+			name = fmt.Sprintf("attachments-%s.zip", time.Now().Format("2006-01-02_150405"))
+		}
+		path, count, skipped, err := writeAttachmentsZip(ctx, m.saveDir, name, emails, m.scanner)
+		return attachmentsZippedMsg{path: path, count: count, skipped: skipped, err: err}
+	}
+}
+
+// writeAttachmentsZip scans and stores attachments in a new zip archive.
+// Infected, unscannable and omitted attachments are left out and counted.
+// The archive is removed again if writing fails or nothing was stored.
+func writeAttachmentsZip(ctx context.Context, dir, name string, emails []Email, scanner attachmentScanner) (path string, count, skipped int, err error) {
+	for _, email := range emails {
+		count += len(email.Attachments)
+	}
+	if count == 0 {
+		return "", 0, 0, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", 0, 0, err
+	}
+	path, err = uniquePath(filepath.Join(dir, name))
+	if err != nil {
+		return "", 0, 0, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	defer func() {
+		f.Close()
+		if err != nil || count == 0 {
+			os.Remove(f.Name())
+			path = ""
+		}
+	}()
+
+	zw := zip.NewWriter(f)
+	count = 0
+	used := make(map[string]bool)
+	for _, email := range emails {
+		folder := strings.TrimSuffix(emailFilename(email), ".eml")
+		for i, attachment := range email.Attachments {
+			if attachment.Omitted {
+				skipped++
+				continue
+			}
+			name := attachmentFilename(attachment, i)
+			if scanner != nil {
+				status, _, err := scanAttachment(ctx, scanner, name, attachment)
+				if err != nil || status != scanClean {
+					skipped++
+					continue
+				}
+			}
+			w, err := zw.CreateHeader(&zip.FileHeader{Name: uniqueEntry(used, folder+"/"+name), Method: zip.Deflate, Modified: email.Date})
+			if err != nil {
+				return "", count, skipped, err
+			}
+			content, err := attachment.open()
+			if err != nil {
+				return "", count, skipped, err
+			}
+			_, err = io.Copy(w, content)
+			content.Close()
+			if err != nil {
+				return "", count, skipped, err
+			}
+			count++
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return "", count, skipped, err
+	}
+	return path, count, skipped, f.Close()
+}
+
+func uniqueEntry(used map[string]bool, name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 2; used[candidate]; i++ {
+		candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	used[candidate] = true
+	return candidate
+}
+
+func zipStatus(msg attachmentsZippedMsg) string {
+	if msg.path == "" {
+		if msg.skipped > 0 {
+			return fmt.Sprintf("No attachments saved (%d skipped)", msg.skipped)
+		}
+		return "No attachments to save"
+	}
+	status := fmt.Sprintf("Zipped %d attachment(s) to %s", msg.count, msg.path)
+	if msg.skipped > 0 {
+		status += fmt.Sprintf(" (%d skipped)", msg.skipped)
+	}
+	return status
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestSniffContentType(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"executable", []byte("MZ\x90\x00\x03\x00\x00\x00"), "application/x-msdownload"},
+		{"pdf", []byte("%PDF-1.7\n"), "application/pdf"},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00"), "image/png"},
+		{"text", []byte("hello world"), "text/plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sniffContentType(tt.data); got != tt.want {
+				t.Errorf("sniffContentType() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTypeMismatchWarning(t *testing.T) {
+	tests := []struct {
+		name       string
+		attachment Attachment
+		want       string
+	}{
+		{"executable posing as pdf", Attachment{Name: "invoice.pdf", Sniffed: "application/x-msdownload"}, "really an executable"},
+		{"pdf posing as png", Attachment{Name: "photo.png", Sniffed: "application/pdf"}, "contains application/pdf, not what .png suggests"},
+		{"docx is a zip", Attachment{Name: "report.docx", Sniffed: "application/zip"}, ""},
+		{"unknown extension", Attachment{Name: "data.bin", Sniffed: "application/pdf"}, ""},
+		{"named executable", Attachment{Name: "setup.exe", Sniffed: "application/x-msdownload"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := typeMismatchWarning(tt.attachment)
+			if tt.want == "" && got != "" {
+				t.Errorf("typeMismatchWarning() = %q, want none", got)
+			}
+			if tt.want != "" && !strings.Contains(got, tt.want) {
+				t.Errorf("typeMismatchWarning() = %q, want it to contain %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFullEmail_SniffsAttachments(t *testing.T) {
+	raw := "From: a@example.com\r\nTo: b@example.com\r\nSubject: Invoice\r\n" +
+		"MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=XX\r\n\r\n" +
+		"--XX\r\nContent-Type: text/plain\r\n\r\nSee attached.\r\n" +
+		"--XX\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=invoice.pdf\r\n\r\nMZ\x90\x00payload\r\n" +
+		"--XX--\r\n"
+
+	m := newMockTestModel(&mockS3{})
+	email, err := m.parseEmailBytes([]byte(raw), "inbox/invoice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(email.Attachments) != 1 || email.Attachments[0].Sniffed != "application/x-msdownload" {
+		t.Fatalf("attachments = %#v", email.Attachments)
+	}
+	found := false
+	for _, w := range email.Warnings {
+		found = found || strings.Contains(w, "invoice.pdf is really an executable")
+	}
+	if !found {
+		t.Errorf("warnings = %#v, want executable mismatch", email.Warnings)
+	}
+}
+
+func TestApplyAttachmentLimit(t *testing.T) {
+	email := &Email{Attachments: []Attachment{
+		{Name: "small.txt", Data: []byte("12345"), Size: 5},
+		{Name: "large.bin", Data: bytes.Repeat([]byte("x"), 20), Size: 20},
+		{Name: "tiny.txt", Data: []byte("1"), Size: 1},
+	}}
+
+	applyAttachmentLimit(email, 10)
+
+	if email.Attachments[0].Omitted || email.Attachments[2].Omitted {
+		t.Errorf("attachments within the limit should be kept: %#v", email.Attachments)
+	}
+	if !email.Attachments[1].Omitted || email.Attachments[1].Data != nil {
+		t.Errorf("large attachment should be omitted: %#v", email.Attachments[1])
+	}
+	if len(email.Diagnostics) != 1 || !strings.Contains(email.Diagnostics[0], "large.bin") {
+		t.Errorf("diagnostics = %#v", email.Diagnostics)
+	}
+}
+
+func TestApplyAttachmentLimit_ZeroDisables(t *testing.T) {
+	email := &Email{Attachments: []Attachment{{Name: "a", Data: []byte("12345")}}}
+	applyAttachmentLimit(email, 0)
+	if email.Attachments[0].Omitted {
+		t.Error("a zero limit should keep every attachment")
+	}
+}
+
+func TestFetchObjectBytes_EnforcesLimit(t *testing.T) {
+	mock := &mockS3{
+		getObjectFunc: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(strings.Repeat("x", 64)))}, nil
+		},
+	}
+
+	m := model{s3Client: mock, bucket: "bucket", maxMessageBytes: 32}
+	if _, err := m.fetchObjectBytes(context.Background(), "big"); err == nil || !strings.Contains(err.Error(), "SMAILER_MAX_MESSAGE_BYTES") {
+		t.Fatalf("expected limit error, got %v", err)
+	}
+
+	m.maxMessageBytes = 64
+	data, err := m.fetchObjectBytes(context.Background(), "big")
+	if err != nil || len(data) != 64 {
+		t.Fatalf("fetchObjectBytes() = %d bytes, %v", len(data), err)
+	}
+}
+
+func TestWriteAttachmentsZip(t *testing.T) {
+	dir := t.TempDir()
+	date := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	emails := []Email{
+		{Key: "inbox/one", Subject: "First", Date: date, Attachments: []Attachment{
+			{Name: "notes.txt", Data: []byte("one")},
+			{Name: "notes.txt", Data: []byte("two")},
+			{Name: "huge.iso", Omitted: true},
+		}},
+		{Key: "inbox/two", Subject: "Second", Date: date, Attachments: []Attachment{
+			{Name: "eicar.com", Data: []byte("X5O!")},
+			{Name: "photo.png", Data: []byte("png")},
+		}},
+	}
+	scanner := fakeScanner{"eicar.com": "Eicar-Test-Signature"}
+
+	path, count, skipped, err := writeAttachmentsZip(context.Background(), dir, "out.zip", emails, scanner)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 3 || skipped != 2 {
+		t.Errorf("count, skipped = %d, %d; want 3, 2", count, skipped)
+	}
+
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	defer r.Close()
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	if len(names) != 3 {
+		t.Fatalf("entries = %#v", names)
+	}
+	if !strings.HasSuffix(names[0], "/notes.txt") || !strings.HasSuffix(names[1], "/notes-2.txt") || !strings.HasSuffix(names[2], "/photo.png") {
+		t.Errorf("entries = %#v", names)
+	}
+	if strings.Split(names[0], "/")[0] == strings.Split(names[2], "/")[0] {
+		t.Errorf("each message should get its own folder: %#v", names)
+	}
+}
+
+func TestWriteAttachmentsZip_NoAttachments(t *testing.T) {
+	dir := t.TempDir()
+	path, count, _, err := writeAttachmentsZip(context.Background(), dir, "out.zip", []Email{{Key: "a"}}, nil)
+	if err != nil || path != "" || count != 0 {
+		t.Fatalf("writeAttachmentsZip() = %q, %d, %v", path, count, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("no archive should be written, found %d entries", len(entries))
+	}
+}
+
+func TestWriteAttachmentsZip_RemovesArchiveWhenAllSkipped(t *testing.T) {
+	dir := t.TempDir()
+	emails := []Email{{Key: "a", Attachments: []Attachment{{Name: "eicar.com", Data: []byte("X5O!")}}}}
+	scanner := fakeScanner{"eicar.com": "Eicar-Test-Signature"}
+
+	path, count, skipped, err := writeAttachmentsZip(context.Background(), dir, "out.zip", emails, scanner)
+	if err != nil || path != "" || count != 0 || skipped != 1 {
+		t.Fatalf("writeAttachmentsZip() = %q, %d, %d, %v", path, count, skipped, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("empty archive left behind: %d entries", len(entries))
+	}
+}
+
+func TestWriteAttachmentsZip_RemovesArchiveOnError(t *testing.T) {
+	dir := t.TempDir()
+	missing := &partSource{path: filepath.Join(dir, "gone.eml"), length: 10}
+	emails := []Email{{Key: "a", Attachments: []Attachment{
+		{Name: "one.txt", Data: []byte("one")},
+		{Name: "two.txt", Source: missing},
+	}}}
+
+	path, _, _, err := writeAttachmentsZip(context.Background(), dir, "out.zip", emails, nil)
+	if err == nil || path != "" {
+		t.Fatalf("writeAttachmentsZip() = %q, %v", path, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("half-written archive left behind: %d entries", len(entries))
+	}
+}
+
+func TestUpdate_ListZipWithoutMarksUsesCursorRow(t *testing.T) {
+	m := bulkTestModel(&mockS3{})
+	m.saveDir = t.TempDir()
+	m.emails[1].BodyLoaded = true
+	m.emails[1].Attachments = []Attachment{{Name: "b.txt", Data: []byte("b")}}
+	m.emails[2].BodyLoaded = true
+	m.emails[2].Attachments = []Attachment{{Name: "c.txt", Data: []byte("c")}}
+	m.updateTableRows()
+	m.table.SetCursor(1)
+
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("A")})
+	msg := cmd().(attachmentsZippedMsg)
+	if msg.err != nil || msg.count != 1 {
+		t.Fatalf("zipped %d attachment(s), err %v", msg.count, msg.err)
+	}
+}
+
+func TestZipStatus(t *testing.T) {
+	if got := zipStatus(attachmentsZippedMsg{}); got != "No attachments to save" {
+		t.Errorf("zipStatus() = %q", got)
+	}
+	if got := zipStatus(attachmentsZippedMsg{skipped: 2}); got != "No attachments saved (2 skipped)" {
+		t.Errorf("zipStatus() = %q", got)
+	}
+	got := zipStatus(attachmentsZippedMsg{path: "/tmp/a.zip", count: 2, skipped: 1})
+	if got != "Zipped 2 attachment(s) to /tmp/a.zip (1 skipped)" {
+		t.Errorf("zipStatus() = %q", got)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	bulkDelete = "delete"
+	bulkSave   = "save"
+	bulkMove   = "move"
+	// bulkZip is confirmed like the others but writes a single archive, so it
+	// runs as one command rather than through startBulk.
+	bulkZip = "zip"
+
+	bulkConcurrency = 8
+)
+
+var bulkVerbs = map[string][2]string{
+	bulkDelete: {"Deleting", "Deleted"},
+	bulkSave:   {"Saving", "Saved"},
+	bulkMove:   {"Moving", "Moved"},
+}
+
+// bulkJob tracks an operation running over the marked emails.
+type bulkJob struct {
+	op       string
+	target   string
+	total    int
+	done     int
+	failed   int
+	firstErr string
+}
+
+type bulkItemMsg struct {
+	op  string
+	key string
+	// bucket and dest are where a moved or trashed email now lives.
+	bucket string
+	dest   string
+	err    error
+}
+
+// toggleMark marks or unmarks the email under the cursor and moves down.
+func (m *model) toggleMark() {
+	if len(m.visibleEmails) == 0 {
+		return
+	}
+	cursor := m.table.Cursor()
+	key := m.visibleEmails[cursor].Key
+	if m.marked[key] {
+		delete(m.marked, key)
+	} else {
+		if m.marked == nil {
+			m.marked = make(map[string]bool)
+		}
+		m.marked[key] = true
+	}
+	m.updateTableRows()
+	if cursor+1 < len(m.visibleEmails) {
+		m.table.SetCursor(cursor + 1)
+	}
+}
+
+// markAllVisible marks every email matching the filter, or clears the marks
+// when they are all marked already.
+func (m *model) markAllVisible() {
+	allMarked := len(m.visibleEmails) > 0
+	for _, e := range m.visibleEmails {
+		allMarked = allMarked && m.marked[e.Key]
+	}
+	if allMarked {
+		m.marked = nil
+		m.updateTableRows()
+		m.setStatus("Cleared marks")
+		return
+	}
+	if m.marked == nil {
+		m.marked = make(map[string]bool)
+	}
+	for _, e := range m.visibleEmails {
+		m.marked[e.Key] = true
+	}
+	m.updateTableRows()
+	m.setStatus(fmt.Sprintf("Marked %d email(s)", len(m.marked)))
+}
+
+// markedEmails returns the marked emails in list order.
+func (m model) markedEmails() []Email {
+	var emails []Email
+	for _, e := range m.emails {
+		if m.marked[e.Key] {
+			emails = append(emails, e)
+		}
+	}
+	return emails
+}
+
+// bulkTargets is what a bulk action applies to: the marked emails, or the
+// email under the cursor when nothing is marked.
+func (m model) bulkTargets() []Email {
+	if len(m.marked) > 0 {
+		return m.markedEmails()
+	}
+	if cursor := m.table.Cursor(); cursor >= 0 && cursor < len(m.visibleEmails) {
+		return []Email{m.visibleEmails[cursor]}
+	}
+	return nil
+}
+
+func (m model) bulkConfirmText() string {
+	targets := m.bulkTargets()
+	count := len(targets)
+	switch m.pendingBulk.op {
+	case bulkMove:
+		subject := fmt.Sprintf("%d marked email(s)", count)
+		if len(m.marked) == 0 {
+			subject = "this email"
+		}
+		dest := m.pendingBulk.target
+		if strings.Contains(dest, "{") && count > 0 {
+			bucket, key := m.moveDestination(targets[0], dest)
+			example := key
+			if bucket != m.bucket {
+				example = "s3://" + bucket + "/" + key
+			}
+			dest += " (e.g. " + example + ")"
+		}
+		return fmt.Sprintf("Move %s to %s?\n\nPress y to confirm, n or esc to cancel.", subject, dest)
+	case bulkSave:
+		return fmt.Sprintf("Save %d marked email(s) as .eml to %s?\n\nPress y to confirm, n or esc to cancel.", count, m.saveDir)
+	case bulkZip:
+		return fmt.Sprintf("Zip the attachments of %d marked email(s) into %s?\n\nPress y to confirm, n or esc to cancel.", count, m.saveDir)
+	default:
+		return fmt.Sprintf("Delete %d marked email(s)?\n\nPress y to confirm, n or esc to cancel.", count)
+	}
+}
+
+// startBulk runs op over the bulk targets. Each email is its own command
+// so that Bubble Tea runs them concurrently and reports each result as it
+// lands; bulkSlots caps how many talk to S3 at once.
+func (m *model) startBulk(op, target string) tea.Cmd {
+	emails := m.bulkTargets()
+	if len(emails) == 0 {
+		return nil
+	}
+	m.bulk = bulkJob{op: op, target: target, total: len(emails)}
+	m.setStatus(bulkStatus(m.bulk))
+	cmds := make([]tea.Cmd, 0, len(emails))
+	for _, e := range emails {
+		cmds = append(cmds, m.bulkItem(op, e, target))
+	}
+	return tea.Batch(cmds...)
+}
+
+func (m model) bulkItem(op string, e Email, target string) tea.Cmd {
+	return func() tea.Msg {
+		if m.bulkSlots != nil {
+			m.bulkSlots <- struct{}{}
+			defer func() { <-m.bulkSlots }()
+		}
+		ctx := context.Background()
+		msg := bulkItemMsg{op: op, key: e.Key}
+		switch op {
+		case bulkDelete:
+			msg.bucket = m.bucket
+			msg.dest, _, msg.err = m.removeEmail(ctx, e.Key)
+		case bulkSave:
+			_, msg.err = m.saveEmail(ctx, e)
+		case bulkMove:
+			msg.bucket, msg.dest = m.moveDestination(e, target)
+			msg.err = m.moveObjectTo(ctx, e.Key, msg.bucket, msg.dest)
+		}
+		return msg
+	}
+}
+
+// recordBulkItem folds one result into the running job. Deleted and moved
+// emails leave the list and the marked set as they complete.
+func (m *model) recordBulkItem(msg bulkItemMsg) {
+	if msg.op != m.bulk.op || m.bulk.done >= m.bulk.total {
+		return
+	}
+	m.bulk.done++
+	var labelErr error
+	if msg.err != nil {
+		m.bulk.failed++
+		if m.bulk.firstErr == "" {
+			m.bulk.firstErr = fmt.Sprintf("%s: %v", shortKey(msg.key), msg.err)
+		}
+	} else if msg.op == bulkDelete || msg.op == bulkMove {
+		if e := m.findEmailByKey(msg.key); e != nil {
+			removeSpool(*e)
+		}
+		filtered := m.emails[:0]
+		for _, e := range m.emails {
+			if e.Key != msg.key {
+				filtered = append(filtered, e)
+			}
+		}
+		m.emails = filtered
+		delete(m.marked, msg.key)
+		if msg.dest != "" {
+			labelErr = m.labels.move(m.bucket, msg.key, msg.bucket, msg.dest)
+		}
+		m.updateTableRows()
+	}
+	m.setStatus(bulkStatus(m.bulk))
+	if labelErr != nil {
+		m.setStatus("Labels not moved: " + labelErr.Error())
+	}
+}
+
+func bulkStatus(job bulkJob) string {
+	verbs := bulkVerbs[job.op]
+	if job.done < job.total {
+		return fmt.Sprintf("%s %d/%d email(s)...", verbs[0], job.done, job.total)
+	}
+	status := fmt.Sprintf("%s %d email(s)", verbs[1], job.done-job.failed)
+	if job.op == bulkMove {
+		status += " to " + job.target
+	}
+	if job.failed > 0 {
+		status += fmt.Sprintf(", %d failed (%s)", job.failed, job.firstErr)
+	}
+	return status
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// runBatch executes cmd and any commands it batches, returning the leaf
+// messages in order.
+func runBatch(cmd tea.Cmd) []tea.Msg {
+	if cmd == nil {
+		return nil
+	}
+	msg := cmd()
+	batch, ok := msg.(tea.BatchMsg)
+	if !ok {
+		return []tea.Msg{msg}
+	}
+	var msgs []tea.Msg
+	for _, c := range batch {
+		msgs = append(msgs, runBatch(c)...)
+	}
+	return msgs
+}
+
+func bulkTestModel(mock s3API) model {
+	m := newMockTestModel(mock)
+	m.initComponents()
+	m.emails = []Email{
+		{Key: "inbound/a", Subject: "A", Date: time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC), RawLoaded: true, Raw: []byte("raw a")},
+		{Key: "inbound/b", Subject: "B", Date: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), RawLoaded: true, Raw: []byte("raw b")},
+		{Key: "inbound/c", Subject: "C", Date: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), RawLoaded: true, Raw: []byte("raw c")},
+	}
+	m.updateTableRows()
+	return m
+}
+
+func TestToggleMark_MarksAndMovesDown(t *testing.T) {
+	m := bulkTestModel(&mockS3{})
+
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeySpace})
+	m = result.(model)
+	if !m.marked["inbound/a"] || m.table.Cursor() != 1 {
+		t.Fatalf("marked = %v, cursor = %d", m.marked, m.table.Cursor())
+	}
+	if !strings.HasPrefix(m.table.Rows()[0][0], "● ") {
+		t.Errorf("marked row should show a marker: %q", m.table.Rows()[0][0])
+	}
+
+	m.table.SetCursor(0)
+	m.toggleMark()
+	if len(m.marked) != 0 {
+		t.Errorf("second toggle should unmark: %v", m.marked)
+	}
+}
+
+func TestMarkAllVisible_MarksFilterMatchesThenClears(t *testing.T) {
+	m := bulkTestModel(&mockS3{})
+	m.filterQuery = "subject:B"
+	m.updateTableRows()
+
+	m.markAllVisible()
+	if len(m.marked) != 1 || !m.marked["inbound/b"] {
+		t.Fatalf("marked = %v", m.marked)
+	}
+	m.markAllVisible()
+	if len(m.marked) != 0 {
+		t.Fatalf("marking all again should clear: %v", m.marked)
+	}
+}
+
+func TestUpdate_BulkDeleteConfirmsAndRemovesMarked(t *testing.T) {
+	var mu sync.Mutex
+	var deleted []string
+	mock := &mockS3{
+		deleteObjectFunc: func(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if aws.ToString(params.Key) == "inbound/c" {
+				return nil, errors.New("access denied")
+			}
+			deleted = append(deleted, aws.ToString(params.Key))
+			return &s3.DeleteObjectOutput{}, nil
+		},
+	}
+	m := bulkTestModel(mock)
+	m.marked = map[string]bool{"inbound/a": true, "inbound/c": true}
+
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'d'}})
+	m = result.(model)
+	if m.state != confirmBulkState || !strings.Contains(m.View(), "Delete 2 marked email(s)?") {
+		t.Fatalf("state = %v", m.state)
+	}
+
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'y'}})
+	m = result.(model)
+	if m.state != listState || m.statusMessage != "Deleting 0/2 email(s)..." {
+		t.Fatalf("state = %v, status = %q", m.state, m.statusMessage)
+	}
+	for _, msg := range runBatch(cmd) {
+		result, _ = m.Update(msg)
+		m = result.(model)
+	}
+
+	if len(deleted) != 1 || deleted[0] != "inbound/a" {
+		t.Errorf("deleted = %v", deleted)
+	}
+	if len(m.emails) != 2 || m.findEmailByKey("inbound/a") != nil {
+		t.Errorf("emails = %d", len(m.emails))
+	}
+	if !m.marked["inbound/c"] || m.marked["inbound/a"] {
+		t.Errorf("failed keys should stay marked: %v", m.marked)
+	}
+	if !strings.HasPrefix(m.statusMessage, "Deleted 1 email(s), 1 failed (") || !strings.Contains(m.statusMessage, "access denied") {
+		t.Errorf("status = %q", m.statusMessage)
+	}
+}
+
+func TestUpdate_BulkMovePromptsForDestination(t *testing.T) {
+	var copies []string
+	mock := &mockS3{
+		copyObjectFunc: func(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+			copies = append(copies, aws.ToString(params.CopySource)+" -> "+aws.ToString(params.Key))
+			return copiedETag(`"e1"`), nil
+		},
+		headObjectFunc: headETag(`"e1"`),
+		deleteObjectFunc: func(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+			return &s3.DeleteObjectOutput{}, nil
+		},
+	}
+	m := bulkTestModel(mock)
+	m.marked = map[string]bool{"inbound/b": true}
+
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'m'}})
+	m = result.(model)
+	if !m.moveActive || m.moveInput.Value() != "inbound/" {
+		t.Fatalf("move prompt = %v %q", m.moveActive, m.moveInput.Value())
+	}
+	m.moveInput.SetValue("archive/2025")
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = result.(model)
+	if m.state != confirmBulkState || !strings.Contains(m.bulkConfirmText(), "Move 1 marked email(s) to archive/2025?") {
+		t.Fatalf("state = %v, text = %q", m.state, m.bulkConfirmText())
+	}
+
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'y'}})
+	m = result.(model)
+	for _, msg := range runBatch(cmd) {
+		result, _ = m.Update(msg)
+		m = result.(model)
+	}
+	if len(copies) != 1 || copies[0] != "test-bucket/inbound/b -> archive/2025/b" {
+		t.Errorf("copies = %v", copies)
+	}
+	if m.findEmailByKey("inbound/b") != nil || m.statusMessage != "Moved 1 email(s) to archive/2025" {
+		t.Errorf("status = %q", m.statusMessage)
+	}
+}
+
+func TestUpdate_BulkSaveWritesEachMarkedEmail(t *testing.T) {
+	m := bulkTestModel(&mockS3{})
+	m.saveDir = t.TempDir()
+	m.marked = map[string]bool{"inbound/a": true, "inbound/b": true}
+
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'s'}})
+	m = result.(model)
+	if m.state != confirmBulkState || !strings.Contains(m.View(), "Save 2 marked email(s) as .eml") {
+		t.Fatalf("state = %v, text = %q", m.state, m.bulkConfirmText())
+	}
+	if entries, _ := os.ReadDir(m.saveDir); len(entries) != 0 {
+		t.Fatal("nothing should be saved before confirming")
+	}
+
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'y'}})
+	m = result.(model)
+	for _, msg := range runBatch(cmd) {
+		result, _ = m.Update(msg)
+		m = result.(model)
+	}
+
+	entries, _ := os.ReadDir(m.saveDir)
+	if len(entries) != 2 {
+		t.Errorf("saved %d file(s), want 2", len(entries))
+	}
+	if m.statusMessage != "Saved 2 email(s)" || len(m.marked) != 2 {
+		t.Errorf("status = %q, marked = %v", m.statusMessage, m.marked)
+	}
+}
+
+func TestUpdate_BulkZipConfirmsFirst(t *testing.T) {
+	m := bulkTestModel(&mockS3{})
+	m.saveDir = t.TempDir()
+	for i := range m.emails {
+		m.emails[i].BodyLoaded = true
+		m.emails[i].Attachments = []Attachment{{Name: "file.txt", Data: []byte(m.emails[i].Key)}}
+	}
+	m.updateTableRows()
+	m.marked = map[string]bool{"inbound/a": true, "inbound/c": true}
+
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'A'}})
+	m = result.(model)
+	if cmd != nil || m.state != confirmBulkState || !strings.Contains(m.bulkConfirmText(), "Zip the attachments of 2 marked email(s)") {
+		t.Fatalf("state = %v, text = %q", m.state, m.bulkConfirmText())
+	}
+
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m = result.(model)
+	if m.state != listState {
+		t.Fatalf("esc should cancel: state = %v", m.state)
+	}
+
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'A'}})
+	m = result.(model)
+	result, cmd = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'y'}})
+	m = result.(model)
+	msg := cmd().(attachmentsZippedMsg)
+	if msg.err != nil || msg.count != 2 {
+		t.Errorf("zipped %d attachment(s), err %v", msg.count, msg.err)
+	}
+}
+
+func TestCopySource_EncodesKey(t *testing.T) {
+	if got := copySource("bucket", "inbound/a b+c"); got != "bucket/inbound/a%20b+c" {
+		t.Errorf("copySource() = %q", got)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+const defaultBodyCacheBytes = 256 << 20
+
+// emailMemory estimates the bytes a loaded email holds beyond its summary.
+func emailMemory(e Email) int64 {
+	n := int64(len(e.Raw) + len(e.Body))
+	for _, a := range e.Attachments {
+		n += int64(len(a.Data))
+	}
+	for _, img := range e.Images {
+		n += int64(len(img.Data))
+	}
+	if e.Calendar != nil {
+		n += int64(len(e.Calendar.Data))
+	}
+	return n
+}
+
+// touchBody marks key as the most recently viewed body and then evicts the
+// least recently viewed ones until loaded bodies fit within bodyBudget.
+func (m *model) touchBody(key string) {
+	recent := make([]string, 0, len(m.recentBodies)+1)
+	for _, k := range m.recentBodies {
+		if k != key {
+			recent = append(recent, k)
+		}
+	}
+	m.recentBodies = append(recent, key)
+	m.evictBodies()
+}
+
+// evictBodies drops the body, raw bytes and attachments of the least
+// recently viewed emails while the total exceeds the budget. Loaded emails
+// that were never viewed, such as those loaded for a zip export, go first.
+// The open email is never evicted; evicted emails are re-fetched when next
+// opened because BodyLoaded and RawLoaded are reset.
+func (m *model) evictBodies() {
+	if m.bodyBudget <= 0 {
+		return
+	}
+	rank := make(map[string]int, len(m.recentBodies))
+	for i, key := range m.recentBodies {
+		rank[key] = i + 1
+	}
+	var used int64
+	var candidates []int
+	for i, e := range m.emails {
+		if !e.BodyLoaded && !e.RawLoaded {
+			continue
+		}
+		used += emailMemory(e)
+		if m.selectedEmail == nil || e.Key != m.selectedEmail.Key {
+			candidates = append(candidates, i)
+		}
+	}
+	if used <= m.bodyBudget {
+		return
+	}
+
+	for len(candidates) > 0 && used > m.bodyBudget {
+		oldest := 0
+		for j, idx := range candidates {
+			if rank[m.emails[idx].Key] < rank[m.emails[candidates[oldest]].Key] {
+				oldest = j
+			}
+		}
+		idx := candidates[oldest]
+		candidates = append(candidates[:oldest], candidates[oldest+1:]...)
+		used -= emailMemory(m.emails[idx])
+		evictBody(&m.emails[idx])
+	}
+
+	recent := m.recentBodies[:0]
+	for _, key := range m.recentBodies {
+		if e := m.findEmailByKey(key); e != nil && e.BodyLoaded {
+			recent = append(recent, key)
+		}
+	}
+	m.recentBodies = recent
+	m.updateTableRows()
+}
+
+func evictBody(e *Email) {
+	removeSpool(*e)
+	e.Spool = ""
+	e.Raw = nil
+	e.RawLoaded = false
+	e.Body = ""
+	e.BodyLoaded = false
+	e.Attachments = nil
+	e.Images = nil
+	e.Calendar = nil
+}
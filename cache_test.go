@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func loadedEmail(key string, size int) Email {
+	return Email{
+		Key:        key,
+		Subject:    key,
+		Body:       "body",
+		BodyLoaded: true,
+		Raw:        bytes.Repeat([]byte("x"), size),
+		RawLoaded:  true,
+	}
+}
+
+func TestEmailMemory_CountsBodyRawAndAttachments(t *testing.T) {
+	e := loadedEmail("a", 100)
+	e.Attachments = []Attachment{{Data: make([]byte, 50)}, {Source: &partSource{length: 1 << 20}}}
+	e.Images = []InlineImage{{Data: make([]byte, 25)}}
+	if got := emailMemory(e); got != 179 {
+		t.Errorf("emailMemory() = %d, want 179", got)
+	}
+}
+
+func TestTouchBody_EvictsLeastRecentlyViewed(t *testing.T) {
+	m := newReadyTestModel()
+	m.emails = []Email{loadedEmail("one", 100), loadedEmail("two", 100), loadedEmail("three", 100)}
+
+	m.touchBody("two")
+	m.touchBody("one")
+	m.bodyBudget = 250
+	m.selectedEmail = m.findEmailByKey("three")
+	m.touchBody("three")
+
+	two := m.findEmailByKey("two")
+	if two.BodyLoaded || two.RawLoaded || two.Raw != nil || two.Body != "" {
+		t.Errorf("least recently viewed email should be evicted: %#v", two)
+	}
+	if !m.findEmailByKey("one").BodyLoaded || !m.findEmailByKey("three").BodyLoaded {
+		t.Error("recently viewed emails should stay loaded")
+	}
+	if len(m.recentBodies) != 2 || m.recentBodies[0] != "one" || m.recentBodies[1] != "three" {
+		t.Errorf("recentBodies = %v", m.recentBodies)
+	}
+	for _, e := range m.visibleEmails {
+		if e.Key == "two" && e.Raw != nil {
+			t.Error("table rows should drop references to evicted bodies")
+		}
+	}
+}
+
+func TestTouchBody_NeverEvictsOpenEmail(t *testing.T) {
+	m := newReadyTestModel()
+	m.bodyBudget = 10
+	m.emails = []Email{loadedEmail("big", 100)}
+	m.selectedEmail = m.findEmailByKey("big")
+
+	m.touchBody("big")
+
+	if !m.findEmailByKey("big").BodyLoaded {
+		t.Error("the open email should stay loaded even when over budget")
+	}
+}
+
+func TestTouchBody_ZeroBudgetDisablesEviction(t *testing.T) {
+	m := newReadyTestModel()
+	m.emails = []Email{loadedEmail("one", 100), loadedEmail("two", 100)}
+
+	m.touchBody("one")
+	m.touchBody("two")
+
+	if !m.findEmailByKey("one").BodyLoaded {
+		t.Error("nothing should be evicted without a budget")
+	}
+}
+
+func TestEvictBody_RemovesSpoolFile(t *testing.T) {
+	spool := filepath.Join(t.TempDir(), "message.eml")
+	if err := os.WriteFile(spool, []byte("raw"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	e := Email{Key: "k", BodyLoaded: true, Spool: spool}
+
+	evictBody(&e)
+
+	if _, err := os.Stat(spool); !os.IsNotExist(err) {
+		t.Errorf("spool file should be removed, stat err = %v", err)
+	}
+	if e.Spool != "" || e.BodyLoaded {
+		t.Errorf("email = %#v", e)
+	}
+}
+
+func TestEvictBody_KeepsSpoolWhileHeld(t *testing.T) {
+	spool := filepath.Join(t.TempDir(), "message.eml")
+	if err := os.WriteFile(spool, []byte("raw"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	e := Email{Key: "k", BodyLoaded: true, Spool: spool}
+	first, second := holdSpool(e), holdSpool(e)
+
+	evictBody(&e)
+	if _, err := os.Stat(spool); err != nil {
+		t.Fatalf("spool removed while a save still reads it: %v", err)
+	}
+	first()
+	first()
+	if _, err := os.Stat(spool); err != nil {
+		t.Fatalf("spool removed before the last reader finished: %v", err)
+	}
+	second()
+	if _, err := os.Stat(spool); !os.IsNotExist(err) {
+		t.Errorf("spool file should be removed once released, stat err = %v", err)
+	}
+}
+
+func TestUpdate_EnterRefetchesEvictedEmail(t *testing.T) {
+	m := newReadyTestModel()
+	m.emails = []Email{loadedEmail("one", 100), loadedEmail("two", 100)}
+	m.touchBody("one")
+	m.bodyBudget = 150
+	m.touchBody("two")
+	m.table.SetCursor(0)
+
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	rm := result.(model)
+	if rm.selectedEmail == nil || rm.selectedEmail.Key != "one" {
+		t.Fatalf("selected = %#v", rm.selectedEmail)
+	}
+	if cmd == nil {
+		t.Fatal("expected the evicted email to be fetched again")
+	}
+	if rm.viewerContent != "Loading email..." {
+		t.Errorf("viewer = %q", rm.viewerContent)
+	}
+}
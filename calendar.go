@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/jhillyerd/enmime"
+)
+
+type Calendar struct {
+	Method string
+	Events []CalendarEvent
+	Data   []byte
+}
+
+type CalendarEvent struct {
+	UID       string
+	Summary   string
+	Organizer string
+	Location  string
+	Status    string
+	Start     time.Time
+	End       time.Time
+	AllDay    bool
+	Attendees []string
+	// UnknownZone names a TZID that could not be resolved; the times are
+	// then read as local time and shown as approximate.
+	UnknownZone string
+}
+
+type inviteSavedMsg struct {
+	path string
+	err  error
+}
+
+type icalProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+func isCalendarPart(part *enmime.Part) bool {
+	switch strings.ToLower(part.ContentType) {
+	case "text/calendar", "application/ics":
+		return true
+	}
+	return strings.EqualFold(filepath.Ext(part.FileName), ".ics")
+}
+
+func findCalendar(env *enmime.Envelope) *Calendar {
+	groups := [][]*enmime.Part{env.OtherParts, env.Inlines, env.Attachments}
+	for _, parts := range groups {
+		for _, part := range parts {
+			if !isCalendarPart(part) {
+				continue
+			}
+			calendar, err := parseICalendar(part.Content)
+			if err != nil || len(calendar.Events) == 0 {
+				continue
+			}
+			if calendar.Method == "" {
+				if _, params, err := mime.ParseMediaType(part.Header.Get("Content-Type")); err == nil {
+					calendar.Method = strings.ToUpper(params["method"])
+				}
+			}
+			return calendar
+		}
+	}
+	return nil
+}
+
+// unfoldICalendar joins continuation lines as described in RFC 5545 3.1.
+func unfoldICalendar(data string) []string {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	var lines []string
+	for _, line := range strings.Split(data, "\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func parseICalProperty(line string) (icalProperty, bool) {
+	colon := -1
+	quoted := false
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		}
+		if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return icalProperty{}, false
+	}
+	head := strings.Split(line[:colon], ";")
+	prop := icalProperty{
+		name:   strings.ToUpper(head[0]),
+		params: make(map[string]string, len(head)-1),
+		value:  line[colon+1:],
+	}
+	for _, param := range head[1:] {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok {
+			continue
+		}
+		prop.params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+	}
+	return prop, true
+}
+
+func parseICalendar(data []byte) (*Calendar, error) {
+	lines := unfoldICalendar(string(data))
+	if len(lines) == 0 || !strings.EqualFold(strings.TrimSpace(lines[0]), "BEGIN:VCALENDAR") {
+		return nil, fmt.Errorf("not an iCalendar object")
+	}
+
+	calendar := &Calendar{Data: append([]byte(nil), data...)}
+	// eventDepth is the depth of the open VEVENT, so that properties of
+	// components nested in it, such as an EMAIL VALARM's SUMMARY and
+	// ATTENDEE, are not taken for the event's own.
+	var current *CalendarEvent
+	depth, eventDepth := 0, 0
+	for _, line := range lines {
+		prop, ok := parseICalProperty(line)
+		if !ok {
+			continue
+		}
+		value := strings.TrimSpace(prop.value)
+		switch prop.name {
+		case "BEGIN":
+			depth++
+			if strings.EqualFold(value, "VEVENT") && current == nil {
+				current = &CalendarEvent{}
+				eventDepth = depth
+			}
+			continue
+		case "END":
+			if strings.EqualFold(value, "VEVENT") && current != nil && depth == eventDepth {
+				calendar.Events = append(calendar.Events, *current)
+				current = nil
+			}
+			depth--
+			continue
+		}
+
+		if current != nil && depth != eventDepth {
+			continue
+		}
+		if current == nil {
+			if prop.name == "METHOD" && depth == 1 {
+				calendar.Method = strings.ToUpper(value)
+			}
+			continue
+		}
+		switch prop.name {
+		case "UID":
+			current.UID = value
+		case "SUMMARY":
+			current.Summary = unescapeICalText(value)
+		case "LOCATION":
+			current.Location = unescapeICalText(value)
+		case "STATUS":
+			current.Status = strings.ToUpper(value)
+		case "ORGANIZER":
+			current.Organizer = icalAddress(prop)
+		case "ATTENDEE":
+			current.Attendees = append(current.Attendees, icalAddress(prop))
+		case "DTSTART", "DTEND":
+			t, allDay, unknown := parseICalTime(prop)
+			if prop.name == "DTSTART" {
+				current.Start, current.AllDay = t, allDay
+			} else {
+				current.End = t
+			}
+			if unknown != "" {
+				current.UnknownZone = unknown
+			}
+		}
+	}
+	return calendar, nil
+}
+
+func unescapeICalText(value string) string {
+	replacer := strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+	return replacer.Replace(value)
+}
+
+func icalAddress(prop icalProperty) string {
+	address := strings.TrimSpace(prop.value)
+	if len(address) >= 7 && strings.EqualFold(address[:7], "mailto:") {
+		address = address[7:]
+	}
+	name := prop.params["CN"]
+	if name == "" || name == address {
+		return address
+	}
+	return fmt.Sprintf("%s <%s>", name, address)
+}
+
+// parseICalTime reads a DATE or DATE-TIME value. It also returns the TZID
+// when that zone could not be resolved and local time was assumed instead.
+func parseICalTime(prop icalProperty) (t time.Time, allDay bool, unknownZone string) {
+	value := strings.TrimSpace(prop.value)
+	if strings.EqualFold(prop.params["VALUE"], "DATE") || len(value) == 8 {
+		t, err := time.ParseInLocation("20060102", value, time.Local)
+		if err != nil {
+			return time.Time{}, false, ""
+		}
+		return t, true, ""
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		if err != nil {
+			return time.Time{}, false, ""
+		}
+		return t, false, ""
+	}
+	loc := time.Local
+	if tzid := prop.params["TZID"]; tzid != "" {
+		if l, ok := icalLocation(tzid); ok {
+			loc = l
+		} else {
+			unknownZone = tzid
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	if err != nil {
+		return time.Time{}, false, ""
+	}
+	return t, false, unknownZone
+}
+
+// icalLocation resolves a TZID, which is an IANA name from most clients but
+// a Windows zone name such as "Pacific Standard Time" from Outlook.
+func icalLocation(tzid string) (*time.Location, bool) {
+	tzid = strings.TrimSpace(tzid)
+	if l, err := time.LoadLocation(tzid); err == nil {
+		return l, true
+	}
+	if name, ok := windowsZones[strings.ToLower(tzid)]; ok {
+		if l, err := time.LoadLocation(name); err == nil {
+			return l, true
+		}
+	}
+	return nil, false
+}
+
+// windowsZones maps Windows time zone names, lower-cased, to the IANA zone
+// CLDR gives for their main territory.
+var windowsZones = map[string]string{
+	"dateline standard time":          "Etc/GMT+12",
+	"hawaiian standard time":          "Pacific/Honolulu",
+	"alaskan standard time":           "America/Anchorage",
+	"pacific standard time":           "America/Los_Angeles",
+	"pacific standard time (mexico)":  "America/Tijuana",
+	"us mountain standard time":       "America/Phoenix",
+	"mountain standard time":          "America/Denver",
+	"mountain standard time (mexico)": "America/Mazatlan",
+	"central america standard time":   "America/Guatemala",
+	"central standard time":           "America/Chicago",
+	"central standard time (mexico)":  "America/Mexico_City",
+	"canada central standard time":    "America/Regina",
+	"sa pacific standard time":        "America/Bogota",
+	"eastern standard time":           "America/New_York",
+	"us eastern standard time":        "America/Indianapolis",
+	"venezuela standard time":         "America/Caracas",
+	"atlantic standard time":          "America/Halifax",
+	"sa western standard time":        "America/La_Paz",
+	"pacific sa standard time":        "America/Santiago",
+	"newfoundland standard time":      "America/St_Johns",
+	"e. south america standard time":  "America/Sao_Paulo",
+	"argentina standard time":         "America/Buenos_Aires",
+	"greenland standard time":         "America/Godthab",
+	"utc":                             "Etc/UTC",
+	"azores standard time":            "Atlantic/Azores",
+	"gmt standard time":               "Europe/London",
+	"greenwich standard time":         "Atlantic/Reykjavik",
+	"w. europe standard time":         "Europe/Berlin",
+	"central europe standard time":    "Europe/Budapest",
+	"romance standard time":           "Europe/Paris",
+	"central european standard time":  "Europe/Warsaw",
+	"w. central africa standard time": "Africa/Lagos",
+	"gtb standard time":               "Europe/Bucharest",
+	"e. europe standard time":         "Europe/Chisinau",
+	"egypt standard time":             "Africa/Cairo",
+	"south africa standard time":      "Africa/Johannesburg",
+	"fle standard time":               "Europe/Kiev",
+	"israel standard time":            "Asia/Jerusalem",
+	"turkey standard time":            "Europe/Istanbul",
+	"arabic standard time":            "Asia/Baghdad",
+	"arab standard time":              "Asia/Riyadh",
+	"russian standard time":           "Europe/Moscow",
+	"e. africa standard time":         "Africa/Nairobi",
+	"iran standard time":              "Asia/Tehran",
+	"arabian standard time":           "Asia/Dubai",
+	"afghanistan standard time":       "Asia/Kabul",
+	"pakistan standard time":          "Asia/Karachi",
+	"west asia standard time":         "Asia/Tashkent",
+	"india standard time":             "Asia/Calcutta",
+	"sri lanka standard time":         "Asia/Colombo",
+	"nepal standard time":             "Asia/Katmandu",
+	"bangladesh standard time":        "Asia/Dhaka",
+	"myanmar standard time":           "Asia/Rangoon",
+	"se asia standard time":           "Asia/Bangkok",
+	"china standard time":             "Asia/Shanghai",
+	"singapore standard time":         "Asia/Singapore",
+	"taipei standard time":            "Asia/Taipei",
+	"w. australia standard time":      "Australia/Perth",
+	"tokyo standard time":             "Asia/Tokyo",
+	"korea standard time":             "Asia/Seoul",
+	"cen. australia standard time":    "Australia/Adelaide",
+	"aus central standard time":       "Australia/Darwin",
+	"e. australia standard time":      "Australia/Brisbane",
+	"aus eastern standard time":       "Australia/Sydney",
+	"tasmania standard time":          "Australia/Hobart",
+	"west pacific standard time":      "Pacific/Port_Moresby",
+	"new zealand standard time":       "Pacific/Auckland",
+	"fiji standard time":              "Pacific/Fiji",
+	"tonga standard time":             "Pacific/Tongatapu",
+}
+
+func formatEventTime(event CalendarEvent) string {
+	if event.Start.IsZero() {
+		return "unknown"
+	}
+	if event.AllDay {
+		return event.Start.Format("Mon 2006-01-02") + " (all day)"
+	}
+	start := event.Start.In(time.Local)
+	out := start.Format("Mon 2006-01-02 15:04")
+	if !event.End.IsZero() {
+		end := event.End.In(time.Local)
+		if end.Format("2006-01-02") == start.Format("2006-01-02") {
+			out += " - " + end.Format("15:04")
+		} else {
+			out += " - " + end.Format("Mon 2006-01-02 15:04")
+		}
+	}
+	out += " " + start.Format("MST")
+	if event.UnknownZone != "" {
+		out += fmt.Sprintf(" (approximate: unknown time zone %q)", event.UnknownZone)
+	}
+	return out
+}
+
+func renderCalendarCard(calendar *Calendar) string {
+	if calendar == nil || len(calendar.Events) == 0 {
+		return ""
+	}
+	method := calendar.Method
+	if method == "" {
+		method = "PUBLISH"
+	}
+	var lines []string
+	for _, event := range calendar.Events {
+		status := method
+		if event.Status == "CANCELLED" && method != "CANCEL" {
+			status += " (cancelled)"
+		}
+		summary := event.Summary
+		if summary == "" {
+			summary = "(untitled event)"
+		}
+		lines = append(lines,
+			fmt.Sprintf("Invite:    [%s] %s", status, summary),
+			fmt.Sprintf("When:      %s", formatEventTime(event)),
+		)
+		if event.Location != "" {
+			lines = append(lines, fmt.Sprintf("Where:     %s", event.Location))
+		}
+		if event.Organizer != "" {
+			lines = append(lines, fmt.Sprintf("Organizer: %s", event.Organizer))
+		}
+		if len(event.Attendees) > 0 {
+			lines = append(lines, fmt.Sprintf("Attendees: %s", strings.Join(event.Attendees, ", ")))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (m model) saveSelectedInvite() tea.Cmd {
+	if m.selectedEmail == nil {
+		return nil
+	}
+	selected := *m.selectedEmail
+	return func() tea.Msg {
+		if !selected.BodyLoaded {
+			loaded, err := m.fetchAndParseEmail(context.Background(), selected.Key)
+			if err != nil {
+				return inviteSavedMsg{err: err}
+			}
+			defer removeSpool(*loaded)
+			selected = *loaded
+		}
+		if selected.Calendar == nil {
+			return inviteSavedMsg{}
+		}
+		path, err := saveInviteFile(m.saveDir, selected)
+		if err != nil {
+			return inviteSavedMsg{err: err}
+		}
+		return inviteSavedMsg{path: path}
+	}
+}
+
+func saveInviteFile(dir string, email Email) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	filename := strings.TrimSuffix(emailFilename(email), ".eml") + ".ics"
+	path, err := uniquePath(filepath.Join(dir, filename))
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, email.Calendar.Data, 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const testInvite = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"METHOD:REQUEST\r\n" +
+	"BEGIN:VTIMEZONE\r\n" +
+	"TZID:Europe/London\r\n" +
+	"END:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:abc-123\r\n" +
+	"SUMMARY:Quarterly review\\, Q1\r\n" +
+	"DTSTART;TZID=Europe/London:20250317T100000\r\n" +
+	"DTEND;TZID=Europe/London:20250317T110000\r\n" +
+	"LOCATION:Room 4\r\n" +
+	"ORGANIZER;CN=\"Alice Example\":mailto:alice@example.com\r\n" +
+	"ATTENDEE;CN=Bob;PARTSTAT=NEEDS-ACTION:mailto:bob@example.com\r\n" +
+	"ATTENDEE:mailto:carol@exam\r\n" +
+	" ple.com\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseICalendar_ParsesEvent(t *testing.T) {
+	calendar, err := parseICalendar([]byte(testInvite))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calendar.Method != "REQUEST" || len(calendar.Events) != 1 {
+		t.Fatalf("unexpected calendar %#v", calendar)
+	}
+
+	event := calendar.Events[0]
+	if event.Summary != "Quarterly review, Q1" {
+		t.Errorf("Summary = %q", event.Summary)
+	}
+	if event.Organizer != "Alice Example <alice@example.com>" {
+		t.Errorf("Organizer = %q", event.Organizer)
+	}
+	if len(event.Attendees) != 2 || event.Attendees[0] != "Bob <bob@example.com>" || event.Attendees[1] != "carol@example.com" {
+		t.Errorf("Attendees = %#v", event.Attendees)
+	}
+	want := time.Date(2025, 3, 17, 10, 0, 0, 0, time.UTC)
+	if !event.Start.Equal(want) {
+		t.Errorf("Start = %v, want %v", event.Start, want)
+	}
+	if event.End.Sub(event.Start) != time.Hour {
+		t.Errorf("End = %v", event.End)
+	}
+}
+
+func TestParseICalendar_AllDayAndUTC(t *testing.T) {
+	data := "BEGIN:VCALENDAR\nBEGIN:VEVENT\nDTSTART;VALUE=DATE:20250401\nEND:VEVENT\nBEGIN:VEVENT\nDTSTART:20250401T090000Z\nSTATUS:CANCELLED\nEND:VEVENT\nEND:VCALENDAR\n"
+
+	calendar, err := parseICalendar([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(calendar.Events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(calendar.Events))
+	}
+	if !calendar.Events[0].AllDay {
+		t.Error("first event should be all day")
+	}
+	if !calendar.Events[1].Start.Equal(time.Date(2025, 4, 1, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("Start = %v", calendar.Events[1].Start)
+	}
+}
+
+func TestParseICalendar_ResolvesWindowsZones(t *testing.T) {
+	data := "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\n" +
+		"DTSTART;TZID=Pacific Standard Time:20250317T090000\r\n" +
+		"DTEND;TZID=\"Pacific Standard Time\":20250317T100000\r\n" +
+		"END:VEVENT\r\nBEGIN:VEVENT\r\n" +
+		"DTSTART;TZID=Customized Time Zone:20250317T090000\r\n" +
+		"END:VEVENT\r\nEND:VCALENDAR\r\n"
+
+	calendar, err := parseICalendar([]byte(data))
+	if err != nil || len(calendar.Events) != 2 {
+		t.Fatalf("calendar = %#v, err = %v", calendar, err)
+	}
+	outlook := calendar.Events[0]
+	if want := time.Date(2025, 3, 17, 16, 0, 0, 0, time.UTC); !outlook.Start.Equal(want) || outlook.End.Sub(outlook.Start) != time.Hour {
+		t.Errorf("Start = %v, End = %v", outlook.Start, outlook.End)
+	}
+	if outlook.UnknownZone != "" || strings.Contains(formatEventTime(outlook), "approximate") {
+		t.Errorf("a Windows zone name should resolve: %q", outlook.UnknownZone)
+	}
+
+	custom := calendar.Events[1]
+	if custom.UnknownZone != "Customized Time Zone" {
+		t.Errorf("UnknownZone = %q", custom.UnknownZone)
+	}
+	if when := formatEventTime(custom); !strings.Contains(when, `approximate: unknown time zone "Customized Time Zone"`) {
+		t.Errorf("when = %q", when)
+	}
+}
+
+func TestParseICalendar_IgnoresAlarmProperties(t *testing.T) {
+	data := "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\n" +
+		"SUMMARY:Standup\r\n" +
+		"ATTENDEE:mailto:team@example.com\r\n" +
+		"BEGIN:VALARM\r\n" +
+		"ACTION:EMAIL\r\n" +
+		"SUMMARY:Alarm notification\r\n" +
+		"DESCRIPTION:This is an event reminder\r\n" +
+		"ATTENDEE:mailto:alice@example.com\r\n" +
+		"TRIGGER:-P0DT0H10M0S\r\n" +
+		"END:VALARM\r\n" +
+		"LOCATION:Room 1\r\n" +
+		"END:VEVENT\r\nEND:VCALENDAR\r\n"
+
+	calendar, err := parseICalendar([]byte(data))
+	if err != nil || len(calendar.Events) != 1 {
+		t.Fatalf("calendar = %#v, err = %v", calendar, err)
+	}
+	event := calendar.Events[0]
+	if event.Summary != "Standup" || event.Location != "Room 1" {
+		t.Errorf("Summary = %q, Location = %q", event.Summary, event.Location)
+	}
+	if len(event.Attendees) != 1 || event.Attendees[0] != "team@example.com" {
+		t.Errorf("Attendees = %#v", event.Attendees)
+	}
+}
+
+func TestParseICalendar_RejectsOtherData(t *testing.T) {
+	if _, err := parseICalendar([]byte("hello")); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestParseFullEmail_FindsAlternativeCalendarPart(t *testing.T) {
+	raw := "From: alice@example.com\r\nTo: bob@example.com\r\nSubject: Invitation\r\nMIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/alternative; boundary=XX\r\n\r\n" +
+		"--XX\r\nContent-Type: text/plain\r\n\r\nYou are invited\r\n" +
+		"--XX\r\nContent-Type: text/calendar; method=CANCEL; charset=UTF-8\r\n\r\n" +
+		strings.ReplaceAll(testInvite, "METHOD:REQUEST\r\n", "") +
+		"--XX--\r\n"
+
+	email, err := parseFullEmail([]byte(raw), "inbound/invite")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if email.Calendar == nil {
+		t.Fatal("expected calendar to be parsed")
+	}
+	if email.Calendar.Method != "CANCEL" {
+		t.Errorf("Method = %q, want CANCEL from content type", email.Calendar.Method)
+	}
+}
+
+func TestRenderCalendarCard_ShowsSummary(t *testing.T) {
+	calendar, _ := parseICalendar([]byte(testInvite))
+
+	card := renderCalendarCard(calendar)
+
+	for _, want := range []string{"[REQUEST] Quarterly review, Q1", "Room 4", "Alice Example", "carol@example.com"} {
+		if !strings.Contains(card, want) {
+			t.Errorf("card missing %q: %q", want, card)
+		}
+	}
+	if renderCalendarCard(nil) != "" {
+		t.Error("nil calendar should render nothing")
+	}
+}
+
+func TestSaveSelectedInvite_WritesICS(t *testing.T) {
+	calendar, _ := parseICalendar([]byte(testInvite))
+	m := newReadyTestModel()
+	m.saveDir = t.TempDir()
+	m.selectedEmail = &Email{Subject: "Invite", Date: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), BodyLoaded: true, Calendar: calendar}
+
+	msg := m.saveSelectedInvite()().(inviteSavedMsg)
+
+	if msg.err != nil || !strings.HasSuffix(msg.path, ".ics") {
+		t.Fatalf("unexpected result %#v", msg)
+	}
+	data, err := os.ReadFile(msg.path)
+	if err != nil || string(data) != testInvite {
+		t.Fatalf("unexpected file contents %q (%v)", data, err)
+	}
+}
+
+func TestUpdate_InviteSavedStatuses(t *testing.T) {
+	m := newReadyTestModel()
+	m.state = viewState
+	m.selectedEmail = &Email{BodyLoaded: true}
+
+	if _, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'i'}}); cmd == nil {
+		t.Fatal("i should trigger invite save")
+	}
+	result, _ := m.Update(inviteSavedMsg{})
+	if result.(model).statusMessage != "No calendar invite to save" {
+		t.Fatalf("status = %q", result.(model).statusMessage)
+	}
+	result, _ = m.Update(inviteSavedMsg{path: "/tmp/a.ics"})
+	if !strings.Contains(result.(model).statusMessage, "Saved .ics") {
+		t.Fatalf("status = %q", result.(model).statusMessage)
+	}
+}
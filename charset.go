@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jhillyerd/enmime"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+var headerDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// charsetReader converts legacy charsets such as ISO-2022-JP, windows-1252
+// and GB18030 to UTF-8 for mime.WordDecoder, which only knows UTF-8 and
+// ISO-8859-1 on its own.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(strings.TrimSpace(charset))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %q", charset)
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// decodeHeaderValue decodes RFC 2047 encoded-words. Headers that carry raw
+// 8-bit bytes are assumed to be windows-1252, which is what legacy mailers
+// almost always send, and reported as a diagnostic.
+func decodeHeaderValue(name, value string) (string, []string) {
+	var diagnostics []string
+	decoded, err := headerDecoder.DecodeHeader(value)
+	if err != nil {
+		diagnostics = append(diagnostics, fmt.Sprintf("%s header: %v", name, err))
+		decoded = value
+	}
+	if !utf8.ValidString(decoded) {
+		converted, convErr := charmap.Windows1252.NewDecoder().String(decoded)
+		if convErr == nil {
+			decoded = converted
+			diagnostics = append(diagnostics, fmt.Sprintf("%s header: unencoded 8-bit text decoded as windows-1252", name))
+		}
+	}
+	return decoded, diagnostics
+}
+
+func decodeSummaryHeaders(header mail.Header) (from, to, subject string, diagnostics []string) {
+	values := []*string{&from, &to, &subject}
+	for i, name := range []string{"From", "To", "Subject"} {
+		decoded, diags := decodeHeaderValue(name, header.Get(name))
+		*values[i] = decoded
+		diagnostics = append(diagnostics, diags...)
+	}
+	return from, to, subject, diagnostics
+}
+
+func envelopeDiagnostics(env *enmime.Envelope) []string {
+	var diagnostics []string
+	for _, e := range env.Errors {
+		severity := "warning"
+		if e.Severe {
+			severity = "error"
+		}
+		line := fmt.Sprintf("%s: %s", severity, e.Name)
+		if e.Detail != "" {
+			line += " (" + e.Detail + ")"
+		}
+		diagnostics = append(diagnostics, line)
+	}
+	if env.Root == nil {
+		return diagnostics
+	}
+	for _, part := range env.Root.DepthMatchAll(func(p *enmime.Part) bool { return true }) {
+		if part.OrigCharset != "" && !strings.EqualFold(part.OrigCharset, part.Charset) {
+			diagnostics = append(diagnostics, fmt.Sprintf("info: part %s declared charset %q, decoded as %q",
+				partLabel(part), part.OrigCharset, part.Charset))
+		}
+	}
+	return diagnostics
+}
+
+func partLabel(part *enmime.Part) string {
+	if part.PartID != "" {
+		return part.PartID + " (" + part.ContentType + ")"
+	}
+	return part.ContentType
+}
+
+func renderDiagnostics(diagnostics []string) string {
+	lines := make([]string, 0, len(diagnostics)+1)
+	lines = append(lines, "Diagnostics:")
+	for _, d := range diagnostics {
+		lines = append(lines, "  - "+d)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func encodeString(t *testing.T, enc encoding.Encoding, s string) string {
+	t.Helper()
+	out, err := enc.NewEncoder().String(s)
+	if err != nil {
+		t.Fatalf("encode %q: %v", s, err)
+	}
+	return out
+}
+
+func encodedWord(t *testing.T, charset string, enc encoding.Encoding, s string) string {
+	return "=?" + charset + "?B?" + base64.StdEncoding.EncodeToString([]byte(encodeString(t, enc, s))) + "?="
+}
+
+func TestDecodeHeaderValue_LegacyCharsets(t *testing.T) {
+	tests := []struct {
+		charset string
+		enc     encoding.Encoding
+		text    string
+	}{
+		{"UTF-8", encoding.Nop, "Café ☕"},
+		{"ISO-2022-JP", japanese.ISO2022JP, "こんにちは"},
+		{"windows-1252", charmap.Windows1252, "naïve €"},
+		{"GB18030", simplifiedchinese.GB18030, "你好"},
+	}
+	for _, tt := range tests {
+		got, diags := decodeHeaderValue("Subject", encodedWord(t, tt.charset, tt.enc, tt.text))
+		if got != tt.text || len(diags) != 0 {
+			t.Errorf("%s: got %q %v, want %q", tt.charset, got, diags, tt.text)
+		}
+	}
+}
+
+func TestDecodeHeaderValue_ReportsProblems(t *testing.T) {
+	got, diags := decodeHeaderValue("Subject", "=?x-unknown?B?aGk=?=")
+	if got != "=?x-unknown?B?aGk=?=" || len(diags) != 1 {
+		t.Fatalf("got %q %v", got, diags)
+	}
+
+	got, diags = decodeHeaderValue("From", "Ren\xe9e <renee@example.com>")
+	if got != "Renée <renee@example.com>" || len(diags) != 1 || !strings.Contains(diags[0], "windows-1252") {
+		t.Fatalf("got %q %v", got, diags)
+	}
+}
+
+func TestFetchEmailSummary_DecodesEncodedWords(t *testing.T) {
+	subject := encodedWord(t, "ISO-2022-JP", japanese.ISO2022JP, "請求書")
+	from := encodedWord(t, "UTF-8", encoding.Nop, "Zoë") + " <zoe@example.com>"
+	raw := "From: " + from + "\r\nTo: ops@example.com\r\nSubject: " + subject + "\r\n\r\nbody"
+	mock := &mockS3{
+		getObjectFunc: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(raw))}, nil
+		},
+	}
+	m := model{s3Client: mock, bucket: "b"}
+
+	email, err := m.fetchEmailSummary(context.Background(), types.Object{Key: aws.String("k")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if email.Subject != "請求書" || email.From != "Zoë <zoe@example.com>" {
+		t.Fatalf("From = %q, Subject = %q", email.From, email.Subject)
+	}
+}
+
+func TestParseFullEmail_DecodesLegacyBodyCharsets(t *testing.T) {
+	tests := []struct {
+		charset string
+		enc     encoding.Encoding
+		text    string
+	}{
+		{"ISO-2022-JP", japanese.ISO2022JP, "お問い合わせありがとうございます"},
+		{"windows-1252", charmap.Windows1252, "Smart “quotes” cost €5"},
+		{"GB18030", simplifiedchinese.GB18030, "感谢您的来信"},
+	}
+	for _, tt := range tests {
+		raw := "From: a@example.com\r\nSubject: test\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=" + tt.charset +
+			"\r\nContent-Transfer-Encoding: base64\r\n\r\n" + base64.StdEncoding.EncodeToString([]byte(encodeString(t, tt.enc, tt.text)))
+		email, err := parseFullEmail([]byte(raw), "k")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.charset, err)
+		}
+		if strings.TrimSpace(email.Body) != tt.text {
+			t.Errorf("%s: Body = %q, want %q", tt.charset, email.Body, tt.text)
+		}
+	}
+}
+
+func TestParseFullEmail_CollectsDiagnostics(t *testing.T) {
+	raw := "From: a@example.com\r\nSubject: test\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=x-made-up\r\n\r\nbody"
+
+	email, err := parseFullEmail([]byte(raw), "k")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(email.Diagnostics) == 0 {
+		t.Fatal("expected charset diagnostic")
+	}
+}
+
+func TestRenderEmailView_DiagnosticsToggle(t *testing.T) {
+	m := newReadyTestModel()
+	m.state = viewState
+	m.selectedEmail = &Email{Key: "k", BodyLoaded: true, Diagnostics: []string{"warning: Character Set Error (x-made-up)"}}
+
+	if got := m.renderEmailView(); !strings.Contains(got, "Diagnostics: 1 issue(s), w to show") {
+		t.Fatalf("expected diagnostics summary in %q", got)
+	}
+
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'w'}})
+	if got := result.(model).renderEmailView(); !strings.Contains(got, "Character Set Error") {
+		t.Fatalf("expected diagnostics panel in %q", got)
+	}
+}
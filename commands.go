@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/mail"
@@ -68,7 +69,7 @@ func (m model) loadEmails() tea.Cmd {
 		var newEmails []Email
 		skipped := 0
 		for _, obj := range page.Contents {
-			if obj.Key == nil {
+			if obj.Key == nil || m.inTrash(*obj.Key) {
 				continue
 			}
 			email, err := m.fetchEmailSummary(ctx, obj)
@@ -77,6 +78,7 @@ func (m model) loadEmails() tea.Cmd {
 			}
 			newEmails = append(newEmails, *email)
 		}
+		m.fetchAllTags(ctx, newEmails)
 
 		var nextContinuation *string
 		hasMore := false
@@ -96,7 +98,22 @@ func (m model) fetchEmailSummary(ctx context.Context, obj types.Object) (*Email,
 	}
 	defer body.Close()
 
-	msg, err := mail.ReadMessage(bufio.NewReader(body))
+	reader := bufio.NewReader(body)
+	var info notificationInfo
+	if prefix, _ := reader.Peek(64); looksLikeJSON(prefix) {
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+		raw, i, err := unwrapMessage(data)
+		if err != nil {
+			return nil, err
+		}
+		info = i
+		reader = bufio.NewReader(bytes.NewReader(raw))
+	}
+
+	msg, err := mail.ReadMessage(reader)
 	if err != nil {
 		return nil, err
 	}
@@ -109,15 +126,23 @@ func (m model) fetchEmailSummary(ctx context.Context, obj types.Object) (*Email,
 		date = fallbackTime("", obj.LastModified)
 	}
 
-	return &Email{
-		From:    msg.Header.Get("From"),
-		To:      msg.Header.Get("To"),
-		Subject: msg.Header.Get("Subject"),
-		Date:    date,
-		S3Date:  aws.ToTime(obj.LastModified),
-		Key:     *obj.Key,
-		Size:    aws.ToInt64(obj.Size),
-	}, nil
+	from, to, subject, diagnostics := decodeSummaryHeaders(msg.Header)
+
+	email := &Email{
+		From:        from,
+		To:          to,
+		Subject:     subject,
+		Date:        date,
+		S3Date:      aws.ToTime(obj.LastModified),
+		Key:         *obj.Key,
+		Size:        aws.ToInt64(obj.Size),
+		Diagnostics: diagnostics,
+	}
+	applyAddressHeaders(email, msg.Header)
+	email.Verdicts = parseVerdicts(msg.Header)
+	email.Kind = reportKind(msg.Header.Get("Content-Type"))
+	info.apply(email)
+	return email, nil
 }
 
 func fallbackEmailSummary(obj types.Object) *Email {
@@ -146,20 +171,134 @@ func (m model) fetchObject(ctx context.Context, key string) (io.ReadCloser, erro
 }
 
 func (m model) fetchAndParseEmail(ctx context.Context, key string) (*Email, error) {
-	raw, err := m.fetchRawEmail(ctx, key)
+	return m.downloadAndParseEmail(ctx, key, nil)
+}
+
+// downloadAndParseEmail spools the object to disk while reporting progress.
+// Small messages are then read back and parsed in memory; large ones are
+// parsed from the spool file, which the email keeps for later saves.
+func (m model) downloadAndParseEmail(ctx context.Context, key string, progress func(done, total int64)) (*Email, error) {
+	path, size, err := m.spoolObject(ctx, key, progress)
 	if err != nil {
 		return nil, err
 	}
-	return parseFullEmail(raw, key)
+	if size > spoolThresholdBytes {
+		email, err := parseSpooledEmail(path, key)
+		if err == nil {
+			email.Warnings = detectPhishing(email, m.internalDomains)
+			return email, nil
+		}
+		if !errors.Is(err, errNeedsMemory) {
+			os.Remove(path)
+			return nil, err
+		}
+	}
+	data, err := os.ReadFile(path)
+	os.Remove(path)
+	if err != nil {
+		return nil, err
+	}
+	return m.parseEmailBytes(data, key)
 }
 
-func (m model) fetchRawEmail(ctx context.Context, key string) ([]byte, error) {
+// parseEmailBytes parses a whole message held in memory: SES notifications
+// are unwrapped and signed or encrypted mail is opened first.
+func (m model) parseEmailBytes(data []byte, key string) (*Email, error) {
+	raw, info, err := unwrapMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	opened, security := openSecureMessage(raw, m.securityKeys)
+	email, err := parseFullEmail(opened, key)
+	if err != nil {
+		return nil, err
+	}
+	email.Raw = raw
+	email.Security = security
+	info.apply(email)
+	applyAttachmentLimit(email, m.maxAttachmentBytes)
+	email.Warnings = detectPhishing(email, m.internalDomains)
+	return email, nil
+}
+
+func (m model) fetchObjectBytes(ctx context.Context, key string) ([]byte, error) {
 	body, err := m.fetchObject(ctx, key)
 	if err != nil {
 		return nil, err
 	}
+	return m.readMessageBytes(body)
+}
+
+// readMessageBytes reads and closes body, refusing messages over
+// maxMessageBytes.
+func (m model) readMessageBytes(body io.ReadCloser) ([]byte, error) {
 	defer body.Close()
-	return io.ReadAll(body)
+	if m.maxMessageBytes <= 0 {
+		return io.ReadAll(body)
+	}
+	data, err := io.ReadAll(io.LimitReader(body, m.maxMessageBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > m.maxMessageBytes {
+		return nil, fmt.Errorf("message is larger than the %s limit (SMAILER_MAX_MESSAGE_BYTES)", formatBytes(m.maxMessageBytes))
+	}
+	return data, nil
+}
+
+func (m model) fetchRawEmail(ctx context.Context, key string) ([]byte, error) {
+	data, err := m.fetchObjectBytes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	raw, _, err := unwrapMessage(data)
+	return raw, err
+}
+
+// openRawEmail returns the raw message from memory, from its spool file or
+// straight from S3, so that saving a large message never buffers it whole.
+func (m model) openRawEmail(ctx context.Context, e Email) (io.ReadCloser, error) {
+	if e.RawLoaded {
+		return io.NopCloser(bytes.NewReader(e.Raw)), nil
+	}
+	if e.Spool != "" {
+		if f, err := os.Open(e.Spool); err == nil {
+			return f, nil
+		}
+	}
+	body, err := m.fetchObject(ctx, e.Key)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(body)
+	if prefix, _ := reader.Peek(64); looksLikeJSON(prefix) {
+		defer body.Close()
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+		raw, _, err := unwrapMessage(data)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(raw)), nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{reader, body}, nil
+}
+
+func (m model) readRawEmail(ctx context.Context, e Email) ([]byte, error) {
+	if e.RawLoaded {
+		return e.Raw, nil
+	}
+	if e.Spool != "" {
+		if raw, err := os.ReadFile(e.Spool); err == nil {
+			return raw, nil
+		}
+	}
+	return m.fetchRawEmail(ctx, e.Key)
 }
 
 func parseFullEmail(raw []byte, key string) (*Email, error) {
@@ -167,7 +306,15 @@ func parseFullEmail(raw []byte, key string) (*Email, error) {
 	if err != nil {
 		return nil, err
 	}
+	email := emailFromEnvelope(env, key, nil)
+	email.Raw = append([]byte(nil), raw...)
+	email.RawLoaded = true
+	return email, nil
+}
 
+// emailFromEnvelope builds an email from a parsed envelope. Attachments whose
+// content is a placeholder in sources are read from the spool file instead.
+func emailFromEnvelope(env *enmime.Envelope, key string, sources map[string]partSource) *Email {
 	date, err := env.Date()
 	if err != nil {
 		date = time.Time{}
@@ -190,43 +337,66 @@ func parseFullEmail(raw []byte, key string) (*Email, error) {
 		if name == "" {
 			name = "attachment"
 		}
-		attachments = append(attachments, Attachment{Name: name, Data: append([]byte(nil), part.Content...)})
+		attachment := Attachment{Name: name, ContentType: part.ContentType}
+		if source, ok := sources[strings.TrimSpace(string(part.Content))]; ok {
+			attachment.Source = &source
+			attachment.Sniffed, attachment.Size = source.inspect()
+		} else {
+			attachment.Data = append([]byte(nil), part.Content...)
+			attachment.Sniffed = sniffContentType(part.Content)
+			attachment.Size = int64(len(part.Content))
+		}
+		attachments = append(attachments, attachment)
 	}
 
-	return &Email{
+	images := collectImages(env)
+	if len(sources) > 0 {
+		kept := images[:0]
+		for _, img := range images {
+			if _, spooled := sources[strings.TrimSpace(string(img.Data))]; !spooled {
+				kept = append(kept, img)
+			}
+		}
+		images = kept
+	}
+
+	email := &Email{
 		From:        env.GetHeader("From"),
 		To:          env.GetHeader("To"),
 		Subject:     env.GetHeader("Subject"),
 		Date:        date,
 		Body:        emailBody,
 		Key:         key,
-		RawLoaded:   true,
 		BodyLoaded:  true,
-		Raw:         append([]byte(nil), raw...),
 		Attachments: attachments,
-	}, nil
+		Calendar:    findCalendar(env),
+		Images:      images,
+		Diagnostics: envelopeDiagnostics(env),
+		MessageID:   strings.TrimSpace(env.GetHeader("Message-ID")),
+		References:  env.GetHeader("References"),
+	}
+	if env.Root != nil {
+		applyAddressHeaders(email, env.Root.Header)
+		email.Verdicts = parseVerdicts(env.Root.Header)
+		if report := parseDeliveryReport(env); report != nil {
+			email.Report = report
+			email.Kind = report.Kind
+		}
+	}
+	email.links = extractLinks(env.HTML)
+	return email
 }
 
 func (m model) getEmailBody(e *Email) string {
-	if m.glamourRenderer == nil {
-		return e.Body
-	}
-	rendered, err := m.glamourRenderer.Render(e.Body)
-	if err != nil {
-		return e.Body
-	}
-	return rendered
+	content, _ := m.renderBodySegments(e)
+	return content
 }
 
 func (m model) deleteEmail() tea.Cmd {
 	key := m.selectedEmail.Key
 	return func() tea.Msg {
-		ctx := context.Background()
-		_, err := m.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
-			Bucket: aws.String(m.bucket),
-			Key:    aws.String(key),
-		})
-		return emailDeletedMsg{err: err}
+		trashKey, markerVersion, err := m.removeEmail(context.Background(), key)
+		return emailDeletedMsg{key: key, trashKey: trashKey, markerVersion: markerVersion, err: err}
 	}
 }
 
@@ -234,9 +404,9 @@ func (m model) loadSelectedEmail() tea.Cmd {
 	if m.selectedEmail == nil || m.selectedEmail.BodyLoaded {
 		return nil
 	}
-	key := m.selectedEmail.Key
+	key, size := m.selectedEmail.Key, m.selectedEmail.Size
 	return func() tea.Msg {
-		email, err := m.fetchAndParseEmail(context.Background(), key)
+		email, err := m.downloadAndParseEmail(context.Background(), key, m.reportDownload(key, size))
 		if err != nil {
 			return errorMsg{err}
 		}
@@ -250,16 +420,7 @@ func (m model) saveSelectedEmail() tea.Cmd {
 	}
 	selected := *m.selectedEmail
 	return func() tea.Msg {
-		raw := selected.Raw
-		if !selected.RawLoaded {
-			var err error
-			raw, err = m.fetchRawEmail(context.Background(), selected.Key)
-			if err != nil {
-				return emailSavedMsg{err: err}
-			}
-		}
-
-		path, err := saveEmailFile(m.saveDir, selected, raw)
+		path, err := m.saveEmail(context.Background(), selected)
 		if err != nil {
 			return emailSavedMsg{err: err}
 		}
@@ -267,22 +428,38 @@ func (m model) saveSelectedEmail() tea.Cmd {
 	}
 }
 
+func (m model) saveEmail(ctx context.Context, e Email) (string, error) {
+	raw, err := m.openRawEmail(ctx, e)
+	if err != nil {
+		return "", err
+	}
+	defer raw.Close()
+	return writeEmailFile(m.saveDir, e, raw)
+}
+
 func (m model) saveSelectedAttachments() tea.Cmd {
 	if m.selectedEmail == nil {
 		return nil
 	}
 	selected := *m.selectedEmail
+	release := holdSpool(selected)
 	return func() tea.Msg {
+		defer release()
 		if !selected.BodyLoaded {
 			loaded, err := m.fetchAndParseEmail(context.Background(), selected.Key)
 			if err != nil {
 				return attachmentsSavedMsg{err: err}
 			}
+			defer removeSpool(*loaded)
 			selected = *loaded
 		}
 		if len(selected.Attachments) == 0 {
 			return attachmentsSavedMsg{}
 		}
+		if m.scanner != nil {
+			paths, results, err := saveScannedAttachments(context.Background(), m.saveDir, selected, m.scanner, m.scanPolicy)
+			return attachmentsSavedMsg{key: selected.Key, paths: paths, results: results, err: err}
+		}
 		paths, err := saveAttachments(m.saveDir, selected)
 		if err != nil {
 			return attachmentsSavedMsg{err: err}
@@ -292,38 +469,24 @@ func (m model) saveSelectedAttachments() tea.Cmd {
 }
 
 func saveEmailFile(dir string, email Email, raw []byte) (string, error) {
-	if err := os.MkdirAll(dir, 0o755); err != nil {
-		return "", err
-	}
-	filename := emailFilename(email)
-	path, err := uniquePath(filepath.Join(dir, filename))
-	if err != nil {
-		return "", err
-	}
-	if err := os.WriteFile(path, raw, 0o644); err != nil {
-		return "", err
-	}
-	return path, nil
+	return writeEmailFile(dir, email, bytes.NewReader(raw))
+}
+
+func writeEmailFile(dir string, email Email, raw io.Reader) (string, error) {
+	return writeAttachment(dir, emailFilename(email), raw, 0o644)
 }
 
 func saveAttachments(dir string, email Email) ([]string, error) {
 	baseDir := filepath.Join(dir, strings.TrimSuffix(emailFilename(email), ".eml")+"-attachments")
-	if err := os.MkdirAll(baseDir, 0o755); err != nil {
-		return nil, err
-	}
 	paths := make([]string, 0, len(email.Attachments))
 	for i, attachment := range email.Attachments {
-		name := sanitizeFilename(attachment.Name)
-		if name == "" || name == "." {
-			name = fmt.Sprintf("attachment-%d", i+1)
+		if attachment.Omitted {
+			continue
 		}
-		path, err := uniquePath(filepath.Join(baseDir, name))
+		path, err := saveAttachment(baseDir, attachmentFilename(attachment, i), attachment, 0o644)
 		if err != nil {
 			return nil, err
 		}
-		if err := os.WriteFile(path, attachment.Data, 0o644); err != nil {
-			return nil, err
-		}
 		paths = append(paths, path)
 	}
 	return paths, nil
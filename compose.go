@@ -0,0 +1,582 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/jhillyerd/enmime"
+)
+
+const smtpTimeout = 30 * time.Second
+
+type composeKind int
+
+const (
+	composeReply composeKind = iota
+	composeReplyAll
+	composeForward
+)
+
+func (k composeKind) String() string {
+	switch k {
+	case composeReplyAll:
+		return "reply to all"
+	case composeForward:
+		return "forward"
+	}
+	return "reply"
+}
+
+// smtpConfig is the server replies and forwards are sent through. security
+// is "starttls" (the default, required before anything is sent), "tls" for
+// implicit TLS, or "none" for a local sink such as MailHog.
+type smtpConfig struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     Address
+	security string
+}
+
+// draft is a reply or forward being written in the editor.
+type draft struct {
+	kind       composeKind
+	original   Email
+	path       string
+	template   string
+	from       Address
+	to         []Address
+	cc         []Address
+	subject    string
+	body       string
+	inReplyTo  string
+	references string
+	attach     bool
+	problem    string
+	sending    bool
+	release    func()
+}
+
+type draftEditedMsg struct {
+	err error
+}
+
+type draftSentMsg struct {
+	recipients int
+	err        error
+}
+
+// smtpConfigFromEnv reads SMAILER_SMTP_HOST, SMAILER_SMTP_PORT,
+// SMAILER_SMTP_USER, SMAILER_SMTP_PASSWORD, SMAILER_SMTP_FROM and
+// SMAILER_SMTP_TLS. Sending is off while the host is unset.
+func smtpConfigFromEnv() smtpConfig {
+	cfg := smtpConfig{
+		host:     strings.TrimSpace(os.Getenv("SMAILER_SMTP_HOST")),
+		port:     strings.TrimSpace(os.Getenv("SMAILER_SMTP_PORT")),
+		username: os.Getenv("SMAILER_SMTP_USER"),
+		password: os.Getenv("SMAILER_SMTP_PASSWORD"),
+		security: strings.ToLower(strings.TrimSpace(os.Getenv("SMAILER_SMTP_TLS"))),
+	}
+	if from, err := mail.ParseAddress(os.Getenv("SMAILER_SMTP_FROM")); err == nil {
+		cfg.from = Address{Name: from.Name, Address: from.Address}
+	}
+	switch cfg.security {
+	case "tls", "none":
+	default:
+		cfg.security = "starttls"
+	}
+	if cfg.port == "" {
+		cfg.port = "587"
+		if cfg.security == "tls" {
+			cfg.port = "465"
+		}
+	}
+	return cfg
+}
+
+// newDraft prepares the headers, quoted body and threading headers of a
+// reply or forward of e sent from self.
+func newDraft(kind composeKind, e Email, self Address) *draft {
+	d := &draft{kind: kind, original: e, from: self}
+	messageID := strings.TrimSpace(e.MessageID)
+	references := strings.Fields(e.References)
+	if messageID != "" {
+		references = append(references, messageID)
+	}
+	d.references = strings.Join(references, " ")
+
+	body := strings.ReplaceAll(e.Body, "\r\n", "\n")
+	if kind == composeForward {
+		d.subject = prefixSubject("Fwd: ", e.Subject, "fwd:", "fw:")
+		d.attach = len(e.Attachments) > 0
+		header := "---------- Forwarded message ----------\n" +
+			"From: " + e.fromFull() + "\n" +
+			"Date: " + e.Date.Format(time.RFC1123Z) + "\n" +
+			"Subject: " + e.Subject + "\n" +
+			"To: " + e.toFull() + "\n"
+		if len(e.CcAddrs) > 0 {
+			header += "Cc: " + formatAddresses(e.CcAddrs) + "\n"
+		}
+		d.body = "\n\n" + header + "\n" + body
+		return d
+	}
+
+	d.subject = prefixSubject("Re: ", e.Subject, "re:")
+	d.inReplyTo = messageID
+	d.to, d.cc = replyRecipients(e, kind == composeReplyAll, self)
+	d.body = fmt.Sprintf("\n\nOn %s, %s wrote:\n%s", e.Date.Format("Mon, 2 Jan 2006 at 15:04"), e.fromFull(), quoteBody(body))
+	return d
+}
+
+// replyRecipients answers the Reply-To address, or else the sender. Replying
+// to all copies the other recipients, leaving out this mailbox's own
+// addresses.
+func replyRecipients(e Email, all bool, self Address) (to, cc []Address) {
+	to = e.ReplyTo
+	if len(to) == 0 {
+		to = e.FromAddrs
+	}
+	to = append([]Address(nil), to...)
+	if !all {
+		return to, nil
+	}
+	seen := map[string]bool{strings.ToLower(self.Address): true}
+	for _, a := range e.DeliveredTo {
+		seen[strings.ToLower(a.Address)] = true
+	}
+	for _, a := range to {
+		seen[strings.ToLower(a.Address)] = true
+	}
+	for _, a := range append(append([]Address(nil), e.ToAddrs...), e.CcAddrs...) {
+		key := strings.ToLower(a.Address)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		cc = append(cc, a)
+	}
+	return to, cc
+}
+
+func prefixSubject(prefix, subject string, existing ...string) string {
+	lower := strings.ToLower(strings.TrimSpace(subject))
+	for _, p := range existing {
+		if strings.HasPrefix(lower, p) {
+			return strings.TrimSpace(subject)
+		}
+	}
+	return prefix + strings.TrimSpace(subject)
+}
+
+func quoteBody(body string) string {
+	lines := strings.Split(strings.TrimRight(body, "\n"), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ">") {
+			lines[i] = ">" + line
+		} else {
+			lines[i] = "> " + line
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// render is the text handed to the editor: the editable headers, a blank
+// line and the body.
+func (d *draft) render() string {
+	return "To: " + formatAddresses(d.to) + "\n" +
+		"Cc: " + formatAddresses(d.cc) + "\n" +
+		"Subject: " + d.subject + "\n\n" +
+		d.body
+}
+
+// parse reads the edited draft back. Only To, Cc and Subject may be changed
+// in the header; the threading headers are kept from the original.
+func (d *draft) parse(text string) error {
+	msg, err := mail.ReadMessage(strings.NewReader(text))
+	if err != nil {
+		return fmt.Errorf("could not read the draft headers: %w", err)
+	}
+	var to, cc []Address
+	for _, field := range []struct {
+		name string
+		dest *[]Address
+	}{{"To", &to}, {"Cc", &cc}} {
+		value := strings.TrimSpace(msg.Header.Get(field.name))
+		if value == "" {
+			continue
+		}
+		list, err := mail.ParseAddressList(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field.name, err)
+		}
+		for _, a := range list {
+			*field.dest = append(*field.dest, Address{Name: a.Name, Address: a.Address})
+		}
+	}
+	if len(to)+len(cc) == 0 {
+		return errors.New("no recipients")
+	}
+	body, err := io.ReadAll(msg.Body)
+	if err != nil {
+		return err
+	}
+	d.to, d.cc = to, cc
+	d.subject = strings.TrimSpace(msg.Header.Get("Subject"))
+	d.body = strings.TrimRight(string(body), "\n") + "\n"
+	return nil
+}
+
+// replyFrom is SMAILER_SMTP_FROM, or else the address the message was
+// delivered to, so shared inboxes answer as themselves.
+func (m model) replyFrom(e Email) Address {
+	if m.smtp.from.Address != "" {
+		return m.smtp.from
+	}
+	for _, list := range [][]Address{e.DeliveredTo, e.ToAddrs} {
+		if len(list) > 0 {
+			return list[0]
+		}
+	}
+	return Address{}
+}
+
+// compose writes a draft of the open email to a temporary file and opens it
+// in the editor.
+func (m *model) compose(kind composeKind) tea.Cmd {
+	if m.selectedEmail == nil {
+		return nil
+	}
+	if !m.selectedEmail.BodyLoaded {
+		m.setStatus("Wait for the email to load")
+		return nil
+	}
+	if m.smtp.host == "" {
+		m.setStatus("Set SMAILER_SMTP_HOST to send mail")
+		return nil
+	}
+	from := m.replyFrom(*m.selectedEmail)
+	if from.Address == "" {
+		m.setStatus("Set SMAILER_SMTP_FROM to send mail")
+		return nil
+	}
+	if _, err := editorArgs(); err != nil {
+		m.setStatus("Cannot start editor: " + err.Error())
+		return nil
+	}
+	d := newDraft(kind, *m.selectedEmail, from)
+	f, err := os.CreateTemp("", "smailer-draft-*.eml")
+	if err != nil {
+		m.setStatus("Could not create draft: " + err.Error())
+		return nil
+	}
+	d.path = f.Name()
+	d.template = d.render()
+	_, err = f.WriteString(d.template)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(d.path)
+		m.setStatus("Could not create draft: " + err.Error())
+		return nil
+	}
+	d.release = holdSpool(d.original)
+	m.draft = d
+	return m.editDraft()
+}
+
+// editorArgs reads the editor from $VISUAL, else $EDITOR, else vi. The
+// setting is split like a shell would, so an editor path with spaces can be
+// quoted; one that is set but empty or cannot be split is an error.
+func editorArgs() ([]string, error) {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		raw, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		args, err := splitShellWords(raw)
+		if err != nil {
+			return nil, fmt.Errorf("$%s: %v", name, err)
+		}
+		if len(args) == 0 || args[0] == "" {
+			return nil, fmt.Errorf("$%s is empty", name)
+		}
+		return args, nil
+	}
+	return []string{"vi"}, nil
+}
+
+// editorCommand runs the editor on path.
+func editorCommand(path string) (*exec.Cmd, error) {
+	args, err := editorArgs()
+	if err != nil {
+		return nil, err
+	}
+	return exec.Command(args[0], append(args[1:], path)...), nil
+}
+
+// editDraft opens the draft in the editor, or reports why it cannot. The
+// draft is kept either way.
+func (m *model) editDraft() tea.Cmd {
+	cmd, err := editorCommand(m.draft.path)
+	if err != nil {
+		m.setStatus("Cannot start editor: " + err.Error())
+		return nil
+	}
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		return draftEditedMsg{err: err}
+	})
+}
+
+// recordDraft reads the draft back once the editor exits and asks for
+// confirmation before sending. An untouched draft is discarded.
+func (m *model) recordDraft(msg draftEditedMsg) {
+	if m.draft == nil {
+		return
+	}
+	if msg.err != nil {
+		m.discardDraft("Editor failed: " + msg.err.Error())
+		return
+	}
+	data, err := os.ReadFile(m.draft.path)
+	if err != nil {
+		m.discardDraft("Could not read draft: " + err.Error())
+		return
+	}
+	if string(data) == m.draft.template {
+		m.discardDraft("Draft unchanged; not sent")
+		return
+	}
+	m.draft.problem = ""
+	if err := m.draft.parse(string(data)); err != nil {
+		m.draft.problem = err.Error()
+	}
+	m.state = composeState
+}
+
+func (m *model) discardDraft(status string) {
+	if m.draft != nil {
+		os.Remove(m.draft.path)
+		if m.draft.release != nil {
+			m.draft.release()
+		}
+	}
+	m.draft = nil
+	if m.state == composeState {
+		m.state = viewState
+	}
+	m.setStatus(status)
+}
+
+// sendDraft builds the message and delivers it through the SMTP server.
+func (m *model) sendDraft() tea.Cmd {
+	d := m.draft
+	if d == nil || d.sending || d.problem != "" {
+		return nil
+	}
+	d.sending = true
+	m.setStatus("Sending...")
+	sender := smtpSender{cfg: m.smtp}
+	return func() tea.Msg {
+		builder, err := d.message(time.Now())
+		if err != nil {
+			return draftSentMsg{err: err}
+		}
+		if err := builder.Send(sender); err != nil {
+			return draftSentMsg{err: err}
+		}
+		return draftSentMsg{recipients: len(d.to) + len(d.cc)}
+	}
+}
+
+func (m *model) recordSent(msg draftSentMsg) {
+	if m.draft == nil {
+		return
+	}
+	m.draft.sending = false
+	if msg.err != nil {
+		m.setStatus("Send failed: " + msg.err.Error())
+		return
+	}
+	m.discardDraft(fmt.Sprintf("Sent to %d recipient(s)", msg.recipients))
+}
+
+// message builds the outgoing MIME message. Forwarded attachments are read
+// from memory or the spool file; ones too large to have been loaded are
+// left out.
+func (d *draft) message(date time.Time) (enmime.MailBuilder, error) {
+	builder := enmime.Builder().
+		From(d.from.Name, d.from.Address).
+		ToAddrs(mailAddresses(d.to)).
+		CCAddrs(mailAddresses(d.cc)).
+		Subject(d.subject).
+		Date(date).
+		Header("Message-ID", newMessageID(d.from.Address)).
+		Text([]byte(d.body))
+	if d.inReplyTo != "" {
+		builder = builder.Header("In-Reply-To", d.inReplyTo)
+	}
+	if d.references != "" {
+		builder = builder.Header("References", d.references)
+	}
+	if !d.attach {
+		return builder, nil
+	}
+	for _, a := range d.original.Attachments {
+		if a.Omitted {
+			continue
+		}
+		rc, err := a.open()
+		if err != nil {
+			return builder, fmt.Errorf("attachment %s: %w", a.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return builder, fmt.Errorf("attachment %s: %w", a.Name, err)
+		}
+		builder = builder.AddAttachment(data, a.ContentType, a.Name)
+	}
+	return builder, nil
+}
+
+func (d *draft) forwardable() (kept, omitted int) {
+	for _, a := range d.original.Attachments {
+		if a.Omitted {
+			omitted++
+		} else {
+			kept++
+		}
+	}
+	return kept, omitted
+}
+
+func mailAddresses(addresses []Address) []mail.Address {
+	list := make([]mail.Address, 0, len(addresses))
+	for _, a := range addresses {
+		list = append(list, mail.Address{Name: a.Name, Address: a.Address})
+	}
+	return list
+}
+
+func newMessageID(from string) string {
+	domain := "smailer.local"
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		domain = from[at+1:]
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return "<" + hex.EncodeToString(id) + "@" + domain + ">"
+}
+
+// composeText is the confirmation shown after the draft is edited.
+func (m model) composeText() string {
+	d := m.draft
+	if d.problem != "" {
+		return fmt.Sprintf("The draft cannot be sent: %s\n\ne: edit | n: discard", d.problem)
+	}
+	text := fmt.Sprintf("Send this %s?\n\nTo: %s", d.kind, formatAddresses(d.to))
+	if len(d.cc) > 0 {
+		text += "\nCc: " + formatAddresses(d.cc)
+	}
+	text += "\nSubject: " + d.subject
+	keys := "y: send | e: edit | n: discard"
+	if d.kind == composeForward && len(d.original.Attachments) > 0 {
+		kept, omitted := d.forwardable()
+		if d.attach {
+			text += fmt.Sprintf("\nAttachments: %d", kept)
+			if omitted > 0 {
+				text += fmt.Sprintf(" (%d too large to forward)", omitted)
+			}
+		} else {
+			text += "\nAttachments: left out"
+		}
+		keys += " | a: attachments"
+	}
+	if d.sending {
+		return text + "\n\nSending..."
+	}
+	return text + "\n\n" + keys
+}
+
+// smtpSender delivers through the configured server. Credentials are only
+// sent over TLS, or in the clear to localhost.
+type smtpSender struct {
+	cfg smtpConfig
+}
+
+func (s smtpSender) Send(from string, recipients []string, msg []byte) error {
+	addr := net.JoinHostPort(s.cfg.host, s.cfg.port)
+	tlsConfig := &tls.Config{ServerName: s.cfg.host}
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	var conn net.Conn
+	var err error
+	if s.cfg.security == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(4 * smtpTimeout))
+	c, err := smtp.NewClient(conn, s.cfg.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		if err := c.Hello(hostname); err != nil {
+			return err
+		}
+	}
+	if s.cfg.security == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("server does not offer STARTTLS; set SMAILER_SMTP_TLS=none for a local test server")
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if s.cfg.username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("server does not offer AUTH")
+		}
+		if err := c.Auth(smtp.PlainAuth("", s.cfg.username, s.cfg.password, s.cfg.host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range recipients {
+		if err := c.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("%s: %w", rcpt, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
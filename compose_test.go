@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/textproto"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/jhillyerd/enmime"
+)
+
+// smtpSink is a minimal SMTP server in the style of MailHog that records
+// what it is sent.
+type smtpSink struct {
+	host     string
+	port     string
+	auth     bool
+	mu       sync.Mutex
+	from     string
+	rcpts    []string
+	data     []byte
+	authLine string
+}
+
+func startSMTPSink(t *testing.T, auth bool) *smtpSink {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	s := &smtpSink{host: host, port: port, auth: auth}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 sink ready")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, _, _ := strings.Cut(line, " ")
+		s.mu.Lock()
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			if s.auth {
+				tp.PrintfLine("250-sink")
+				tp.PrintfLine("250 AUTH PLAIN")
+			} else {
+				tp.PrintfLine("250 sink")
+			}
+		case "AUTH":
+			s.authLine = line
+			tp.PrintfLine("235 ok")
+		case "MAIL":
+			s.from = line
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			s.rcpts = append(s.rcpts, line)
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			s.mu.Unlock()
+			data, err := tp.ReadDotBytes()
+			s.mu.Lock()
+			if err != nil {
+				s.mu.Unlock()
+				return
+			}
+			s.data = data
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			s.mu.Unlock()
+			return
+		default:
+			tp.PrintfLine("502 unknown")
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *smtpSink) config(security string) smtpConfig {
+	return smtpConfig{host: s.host, port: s.port, security: security}
+}
+
+func composeTestEmail() Email {
+	return Email{
+		Key:         "inbound/a",
+		Subject:     "Invoice 42",
+		Date:        time.Date(2025, 1, 3, 9, 30, 0, 0, time.UTC),
+		Body:        "Please pay.\n> earlier\n",
+		BodyLoaded:  true,
+		MessageID:   "<msg-2@example.com>",
+		References:  "<msg-0@example.com>\r\n <msg-1@example.com>",
+		FromAddrs:   []Address{{Name: "Ann", Address: "ann@example.com"}},
+		ToAddrs:     []Address{{Address: "support@acme.test"}, {Address: "bob@example.com"}},
+		CcAddrs:     []Address{{Address: "ANN@example.com"}, {Address: "cat@example.com"}},
+		DeliveredTo: []Address{{Address: "support@acme.test"}},
+		Attachments: []Attachment{
+			{Name: "invoice.pdf", ContentType: "application/pdf", Data: []byte("%PDF-1.4")},
+			{Name: "big.zip", ContentType: "application/zip", Omitted: true},
+		},
+	}
+}
+
+func TestNewDraft_Reply(t *testing.T) {
+	d := newDraft(composeReply, composeTestEmail(), Address{Address: "support@acme.test"})
+	if d.subject != "Re: Invoice 42" {
+		t.Errorf("subject = %q", d.subject)
+	}
+	if d.inReplyTo != "<msg-2@example.com>" {
+		t.Errorf("In-Reply-To = %q", d.inReplyTo)
+	}
+	if d.references != "<msg-0@example.com> <msg-1@example.com> <msg-2@example.com>" {
+		t.Errorf("References = %q", d.references)
+	}
+	if formatAddresses(d.to) != "Ann <ann@example.com>" || d.cc != nil {
+		t.Errorf("to = %v, cc = %v", d.to, d.cc)
+	}
+	if !strings.Contains(d.body, "Ann <ann@example.com> wrote:\n> Please pay.\n>> earlier\n") {
+		t.Errorf("body = %q", d.body)
+	}
+
+	again := composeTestEmail()
+	again.Subject = "RE: Invoice 42"
+	if got := newDraft(composeReply, again, Address{}).subject; got != "RE: Invoice 42" {
+		t.Errorf("subject = %q", got)
+	}
+}
+
+func TestReplyRecipients_ReplyAllSkipsOwnAddresses(t *testing.T) {
+	e := composeTestEmail()
+	e.ReplyTo = []Address{{Address: "billing@example.com"}}
+	to, cc := replyRecipients(e, true, Address{Address: "me@acme.test"})
+	if formatAddresses(to) != "billing@example.com" {
+		t.Errorf("to = %v", to)
+	}
+	want := []Address{{Address: "bob@example.com"}, {Address: "ANN@example.com"}, {Address: "cat@example.com"}}
+	if !reflect.DeepEqual(cc, want) {
+		t.Errorf("cc = %v, want %v", cc, want)
+	}
+}
+
+func TestNewDraft_Forward(t *testing.T) {
+	d := newDraft(composeForward, composeTestEmail(), Address{Address: "support@acme.test"})
+	if d.subject != "Fwd: Invoice 42" || len(d.to) != 0 || d.inReplyTo != "" {
+		t.Errorf("subject = %q, to = %v, In-Reply-To = %q", d.subject, d.to, d.inReplyTo)
+	}
+	if !d.attach {
+		t.Error("attachments should be forwarded by default")
+	}
+	if !strings.Contains(d.body, "---------- Forwarded message ----------\nFrom: Ann <ann@example.com>\n") || !strings.Contains(d.body, "\nPlease pay.\n") {
+		t.Errorf("body = %q", d.body)
+	}
+}
+
+func TestDraftParse(t *testing.T) {
+	d := newDraft(composeReply, composeTestEmail(), Address{})
+	err := d.parse("To: Ann <ann@example.com>, dan@example.com\nCc:\nSubject: Re: Paid\n\nDone.\n\n\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if formatAddresses(d.to) != "Ann <ann@example.com>, dan@example.com" || d.cc != nil {
+		t.Errorf("to = %v, cc = %v", d.to, d.cc)
+	}
+	if d.subject != "Re: Paid" || d.body != "Done.\n" {
+		t.Errorf("subject = %q, body = %q", d.subject, d.body)
+	}
+
+	for _, text := range []string{
+		"To:\nCc:\nSubject: x\n\nbody",
+		"To: not an address\nSubject: x\n\nbody",
+	} {
+		if err := d.parse(text); err == nil {
+			t.Errorf("%q should not parse", text)
+		}
+	}
+}
+
+func TestDraftMessage_ForwardsAttachments(t *testing.T) {
+	sink := startSMTPSink(t, false)
+	d := newDraft(composeForward, composeTestEmail(), Address{Name: "Support", Address: "support@acme.test"})
+	d.to = []Address{{Address: "dan@example.com"}}
+	builder, err := d.message(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.Send(smtpSender{cfg: sink.config("none")}); err != nil {
+		t.Fatal(err)
+	}
+	env, err := enmime.ReadEnvelope(bytes.NewReader(sink.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(env.Attachments) != 1 || env.Attachments[0].FileName != "invoice.pdf" || string(env.Attachments[0].Content) != "%PDF-1.4" {
+		t.Fatalf("attachments = %#v", env.Attachments)
+	}
+	if got := env.GetHeader("References"); got != "<msg-0@example.com> <msg-1@example.com> <msg-2@example.com>" {
+		t.Errorf("References = %q", got)
+	}
+	if !strings.HasSuffix(env.GetHeader("Message-ID"), "@acme.test>") {
+		t.Errorf("Message-ID = %q", env.GetHeader("Message-ID"))
+	}
+
+	d.attach = false
+	builder, _ = d.message(time.Now())
+	if err := builder.Send(smtpSender{cfg: sink.config("none")}); err != nil {
+		t.Fatal(err)
+	}
+	env, _ = enmime.ReadEnvelope(bytes.NewReader(sink.data))
+	if len(env.Attachments) != 0 {
+		t.Errorf("attachments left out still sent: %d", len(env.Attachments))
+	}
+}
+
+func TestSMTPSender_RequiresSTARTTLS(t *testing.T) {
+	sink := startSMTPSink(t, false)
+	err := smtpSender{cfg: sink.config("starttls")}.Send("a@example.com", []string{"b@example.com"}, []byte("Subject: x\r\n\r\nhi\r\n"))
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("err = %v", err)
+	}
+	if sink.from != "" {
+		t.Error("nothing should be sent without STARTTLS")
+	}
+}
+
+func TestSMTPSender_AuthenticatesToLocalServer(t *testing.T) {
+	sink := startSMTPSink(t, true)
+	cfg := sink.config("none")
+	cfg.username, cfg.password = "user", "secret"
+	if err := (smtpSender{cfg: cfg}).Send("a@example.com", []string{"b@example.com"}, []byte("Subject: x\r\n\r\nhi\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sink.authLine, "AUTH PLAIN ") || sink.from != "MAIL FROM:<a@example.com>" {
+		t.Errorf("auth = %q, from = %q", sink.authLine, sink.from)
+	}
+}
+
+func TestUpdate_ReplyAllEditsAndSends(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	sink := startSMTPSink(t, false)
+	m := bulkTestModel(&mockS3{})
+	m.smtp = sink.config("none")
+	e := composeTestEmail()
+	m.emails[0] = e
+	m.selectedEmail = &m.emails[0]
+	m.state = viewState
+
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("R")})
+	m = result.(model)
+	if cmd == nil || m.draft == nil {
+		t.Fatalf("no draft opened: %q", m.statusMessage)
+	}
+	if m.draft.from.Address != "support@acme.test" {
+		t.Errorf("from = %v", m.draft.from)
+	}
+	path := m.draft.path
+	if err := os.WriteFile(path, []byte(m.draft.template+"\nThanks, paid.\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	result, _ = m.Update(draftEditedMsg{})
+	m = result.(model)
+	if m.state != composeState || !strings.Contains(m.composeText(), "Cc: bob@example.com, cat@example.com") {
+		t.Fatalf("state = %v, prompt = %q", m.state, m.composeText())
+	}
+
+	result, cmd = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")})
+	m = result.(model)
+	for _, msg := range runBatch(cmd) {
+		result, _ = m.Update(msg)
+		m = result.(model)
+	}
+	if m.statusMessage != "Sent to 3 recipient(s)" || m.state != viewState || m.draft != nil {
+		t.Fatalf("status = %q, state = %v", m.statusMessage, m.state)
+	}
+	if len(sink.rcpts) != 3 {
+		t.Errorf("rcpts = %v", sink.rcpts)
+	}
+	data := string(sink.data)
+	if !strings.Contains(data, "In-Reply-To: <msg-2@example.com>") || !strings.Contains(data, "Thanks, paid.") {
+		t.Errorf("message = %s", data)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("the draft file should be removed once sent")
+	}
+}
+
+func TestUpdate_UnchangedDraftIsDiscarded(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	m := bulkTestModel(&mockS3{})
+	m.smtp = smtpConfig{host: "127.0.0.1", port: "1", security: "none"}
+	e := composeTestEmail()
+	m.selectedEmail = &e
+	m.state = viewState
+
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")})
+	m = result.(model)
+	path := m.draft.path
+	result, _ = m.Update(draftEditedMsg{})
+	m = result.(model)
+	if m.state != viewState || m.draft != nil || m.statusMessage != "Draft unchanged; not sent" {
+		t.Fatalf("state = %v, status = %q", m.state, m.statusMessage)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("the draft file should be removed")
+	}
+}
+
+func TestCompose_RequiresSMTPHost(t *testing.T) {
+	m := bulkTestModel(&mockS3{})
+	e := composeTestEmail()
+	m.selectedEmail = &e
+	m.state = viewState
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("F")})
+	m = result.(model)
+	if cmd != nil || m.draft != nil || m.statusMessage != "Set SMAILER_SMTP_HOST to send mail" {
+		t.Errorf("status = %q", m.statusMessage)
+	}
+}
+
+func TestEditorArgs(t *testing.T) {
+	tests := []struct {
+		visual, editor string
+		want           []string
+		wantErr        string
+	}{
+		{want: []string{"vi"}},
+		{editor: "nano -w", want: []string{"nano", "-w"}},
+		{visual: `"/Applications/Sublime Text.app/subl" --wait`, editor: "nano", want: []string{"/Applications/Sublime Text.app/subl", "--wait"}},
+		{visual: "  ", editor: "nano", wantErr: "$VISUAL is empty"},
+		{visual: `''`, wantErr: "$VISUAL is empty"},
+		{editor: `code "--wait`, wantErr: "$EDITOR: unterminated double quote"},
+	}
+	for _, tt := range tests {
+		// Setenv first so the variables are restored after the test.
+		t.Setenv("VISUAL", "")
+		t.Setenv("EDITOR", "")
+		os.Unsetenv("VISUAL")
+		os.Unsetenv("EDITOR")
+		if tt.visual != "" {
+			t.Setenv("VISUAL", tt.visual)
+		}
+		if tt.editor != "" {
+			t.Setenv("EDITOR", tt.editor)
+		}
+		got, err := editorArgs()
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("VISUAL=%q EDITOR=%q: err = %v, want %q", tt.visual, tt.editor, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("VISUAL=%q EDITOR=%q: got %q, %v", tt.visual, tt.editor, got, err)
+		}
+	}
+}
+
+func TestCompose_ReportsUnusableEditor(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	t.Setenv("VISUAL", "'vim")
+	m := bulkTestModel(&mockS3{})
+	m.smtp = smtpConfig{host: "127.0.0.1", port: "1", security: "none"}
+	e := composeTestEmail()
+	m.selectedEmail = &e
+	m.state = viewState
+
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")})
+	m = result.(model)
+	if cmd != nil || m.draft != nil || m.statusMessage != "Cannot start editor: $VISUAL: unterminated single quote" {
+		t.Errorf("status = %q", m.statusMessage)
+	}
+}
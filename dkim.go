@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	dkimPass      = "pass"
+	dkimFail      = "fail"
+	dkimPermError = "permerror"
+	dkimTempError = "temperror"
+)
+
+// keyResolver looks up the TXT records holding DKIM public keys. DNS is the
+// default; a key file stands in for it when checking signing configuration
+// offline or in tests.
+type keyResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+type dnsResolver struct{}
+
+func (dnsResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return net.DefaultResolver.LookupTXT(ctx, name)
+}
+
+// fileResolver serves TXT records from a local file. Each line holds the
+// record name followed by its value, e.g.
+//
+//	s1._domainkey.acme.example v=DKIM1; k=rsa; p=MIIBIjAN...
+type fileResolver struct {
+	records map[string][]string
+}
+
+func (r fileResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	records, ok := r.records[strings.ToLower(strings.TrimSuffix(name, "."))]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+func loadKeyFile(path string) (fileResolver, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return fileResolver{}, err
+	}
+	defer f.Close()
+
+	r := fileResolver{records: make(map[string][]string)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		r.records[name] = append(r.records[name], strings.Trim(strings.TrimSpace(value), `"`))
+	}
+	return r, scanner.Err()
+}
+
+// dkimResolverFromEnv uses the key file named by SMAILER_DKIM_KEYS when set,
+// and DNS otherwise. A key file that cannot be read is reported rather than
+// replaced by DNS, so checks never silently use keys the user did not choose.
+func dkimResolverFromEnv() (keyResolver, error) {
+	path := os.Getenv("SMAILER_DKIM_KEYS")
+	if path == "" {
+		return dnsResolver{}, nil
+	}
+	r, err := loadKeyFile(path)
+	if err != nil {
+		return r, fmt.Errorf("DKIM key file: %w", err)
+	}
+	return r, nil
+}
+
+// DKIMResult is the outcome of checking one DKIM-Signature header.
+type DKIMResult struct {
+	Domain    string
+	Selector  string
+	Algorithm string
+	BodyHash  string
+	Result    string
+	Detail    string
+}
+
+type dkimVerifiedMsg struct {
+	key     string
+	results []DKIMResult
+	err     error
+}
+
+type dkimSignature struct {
+	tags     map[string]string
+	raw      string
+	algo     string
+	hash     crypto.Hash
+	headerC  string
+	bodyC    string
+	bodyHash []byte
+	sig      []byte
+	headers  []string
+	length   int64
+}
+
+type headerField struct {
+	name string
+	raw  string
+}
+
+var signatureValuePattern = regexp.MustCompile(`(?i)((?:^|[:;])\s*b\s*=)[^;]*`)
+
+func (m model) verifySelectedDKIM() tea.Cmd {
+	if m.selectedEmail == nil {
+		return nil
+	}
+	selected := *m.selectedEmail
+	resolver := m.dkimResolver
+	if resolver == nil {
+		resolver = dnsResolver{}
+	}
+	return func() tea.Msg {
+		raw, err := m.readRawEmail(context.Background(), selected)
+		if err != nil {
+			return dkimVerifiedMsg{key: selected.Key, err: err}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return dkimVerifiedMsg{key: selected.Key, results: verifyDKIM(ctx, raw, resolver)}
+	}
+}
+
+// verifyDKIM checks every DKIM-Signature header in raw, in the order they
+// appear, following RFC 6376 and RFC 8463 for Ed25519 keys.
+func verifyDKIM(ctx context.Context, raw []byte, resolver keyResolver) []DKIMResult {
+	header, body := splitRawMessage(normaliseLineEndings(raw))
+	fields := splitHeaderFields(header)
+
+	var results []DKIMResult
+	for _, field := range fields {
+		if !strings.EqualFold(field.name, "DKIM-Signature") {
+			continue
+		}
+		results = append(results, verifySignature(ctx, field, fields, body, resolver))
+	}
+	return results
+}
+
+func verifySignature(ctx context.Context, field headerField, fields []headerField, body []byte, resolver keyResolver) DKIMResult {
+	sig, err := parseDKIMSignature(field.raw)
+	result := DKIMResult{Result: dkimPermError}
+	if sig != nil {
+		result.Domain = sig.tags["d"]
+		result.Selector = sig.tags["s"]
+		result.Algorithm = sig.algo
+	}
+	if err != nil {
+		result.Detail = err.Error()
+		return result
+	}
+
+	bodyHash := computeBodyHash(sig, body)
+	if bytes.Equal(bodyHash, sig.bodyHash) {
+		result.BodyHash = dkimPass
+	} else {
+		result.BodyHash = dkimFail
+	}
+
+	key, err := lookupDKIMKey(ctx, resolver, sig)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && !dnsErr.IsNotFound {
+			result.Result = dkimTempError
+		}
+		result.Detail = err.Error()
+		return result
+	}
+
+	h := sig.hash.New()
+	h.Write(signedHeaderData(sig, fields))
+	digest := h.Sum(nil)
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(pub, sig.hash, digest, sig.sig)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, digest, sig.sig) {
+			err = errors.New("ed25519 verification failed")
+		}
+	}
+	if err != nil {
+		result.Result = dkimFail
+		result.Detail = "signature did not verify"
+		return result
+	}
+	if result.BodyHash != dkimPass {
+		result.Result = dkimFail
+		result.Detail = "body hash did not match"
+		return result
+	}
+	result.Result = dkimPass
+	return result
+}
+
+func normaliseLineEndings(raw []byte) []byte {
+	raw = bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(raw, []byte("\n"), []byte("\r\n"))
+}
+
+func splitRawMessage(raw []byte) (header, body []byte) {
+	if idx := bytes.Index(raw, []byte("\r\n\r\n")); idx >= 0 {
+		return raw[:idx+2], raw[idx+4:]
+	}
+	return raw, nil
+}
+
+func splitHeaderFields(header []byte) []headerField {
+	var fields []headerField
+	for _, line := range strings.SplitAfter(string(header), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1].raw += line
+			continue
+		}
+		name, _, _ := strings.Cut(line, ":")
+		fields = append(fields, headerField{name: strings.TrimSpace(name), raw: line})
+	}
+	return fields
+}
+
+func parseDKIMTags(value string) map[string]string {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(value, ";") {
+		name, val, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		tags[strings.TrimSpace(name)] = strings.TrimSpace(val)
+	}
+	return tags
+}
+
+func stripWhitespace(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\r', '\n':
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func parseDKIMSignature(raw string) (*dkimSignature, error) {
+	_, value, _ := strings.Cut(raw, ":")
+	sig := &dkimSignature{raw: raw, tags: parseDKIMTags(value), length: -1}
+	for _, tag := range []string{"v", "a", "b", "bh", "d", "h", "s"} {
+		if sig.tags[tag] == "" {
+			return sig, fmt.Errorf("missing %s= tag", tag)
+		}
+	}
+	if sig.tags["v"] != "1" {
+		return sig, fmt.Errorf("unsupported version %q", sig.tags["v"])
+	}
+
+	sig.algo = strings.ToLower(sig.tags["a"])
+	switch sig.algo {
+	case "rsa-sha256", "ed25519-sha256":
+		sig.hash = crypto.SHA256
+	case "rsa-sha1":
+		sig.hash = crypto.SHA1
+	default:
+		return sig, fmt.Errorf("unsupported algorithm %q", sig.algo)
+	}
+
+	sig.headerC, sig.bodyC = "simple", "simple"
+	if c := strings.ToLower(sig.tags["c"]); c != "" {
+		headerC, bodyC, ok := strings.Cut(c, "/")
+		sig.headerC = headerC
+		if ok {
+			sig.bodyC = bodyC
+		}
+	}
+	for _, c := range []string{sig.headerC, sig.bodyC} {
+		if c != "simple" && c != "relaxed" {
+			return sig, fmt.Errorf("unsupported canonicalization %q", c)
+		}
+	}
+
+	var err error
+	if sig.bodyHash, err = base64.StdEncoding.DecodeString(stripWhitespace(sig.tags["bh"])); err != nil {
+		return sig, fmt.Errorf("invalid bh= tag: %w", err)
+	}
+	if sig.sig, err = base64.StdEncoding.DecodeString(stripWhitespace(sig.tags["b"])); err != nil {
+		return sig, fmt.Errorf("invalid b= tag: %w", err)
+	}
+	for _, name := range strings.Split(sig.tags["h"], ":") {
+		if name = strings.TrimSpace(name); name != "" {
+			sig.headers = append(sig.headers, name)
+		}
+	}
+	if l := sig.tags["l"]; l != "" {
+		if sig.length, err = strconv.ParseInt(l, 10, 64); err != nil || sig.length < 0 {
+			return sig, fmt.Errorf("invalid l= tag %q", l)
+		}
+	}
+	if x := sig.tags["x"]; x != "" {
+		if expiry, err := strconv.ParseInt(x, 10, 64); err == nil && time.Now().Unix() > expiry {
+			return sig, errors.New("signature expired")
+		}
+	}
+	return sig, nil
+}
+
+func lookupDKIMKey(ctx context.Context, resolver keyResolver, sig *dkimSignature) (crypto.PublicKey, error) {
+	name := sig.tags["s"] + "._domainkey." + sig.tags["d"]
+	records, err := resolver.LookupTXT(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("key lookup for %s: %w", name, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no key record at %s", name)
+	}
+	tags := parseDKIMTags(strings.Join(records, ""))
+	if v := tags["v"]; v != "" && v != "DKIM1" {
+		return nil, fmt.Errorf("unsupported key version %q", v)
+	}
+	p := stripWhitespace(tags["p"])
+	if p == "" {
+		return nil, errors.New("key has been revoked")
+	}
+	data, err := base64.StdEncoding.DecodeString(p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key data: %w", err)
+	}
+
+	keyType := strings.ToLower(tags["k"])
+	if keyType == "" {
+		keyType = "rsa"
+	}
+	if !strings.HasPrefix(sig.algo, keyType+"-") {
+		return nil, fmt.Errorf("key type %s does not match algorithm %s", keyType, sig.algo)
+	}
+	switch keyType {
+	case "rsa":
+		if pub, err := x509.ParsePKIXPublicKey(data); err == nil {
+			if rsaKey, ok := pub.(*rsa.PublicKey); ok {
+				return rsaKey, nil
+			}
+			return nil, errors.New("key is not an RSA key")
+		}
+		pub, err := x509.ParsePKCS1PublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA key: %w", err)
+		}
+		return pub, nil
+	case "ed25519":
+		if len(data) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key length")
+		}
+		return ed25519.PublicKey(data), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", keyType)
+}
+
+func computeBodyHash(sig *dkimSignature, body []byte) []byte {
+	canonical := canonicalBody(body, sig.bodyC)
+	if sig.length >= 0 && sig.length < int64(len(canonical)) {
+		canonical = canonical[:sig.length]
+	}
+	h := sig.hash.New()
+	h.Write(canonical)
+	return h.Sum(nil)
+}
+
+// canonicalBody applies the RFC 6376 section 3.4 body canonicalization.
+func canonicalBody(body []byte, method string) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	if method == "relaxed" {
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(collapseWhitespace(line), " ")
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		if method == "relaxed" {
+			return nil
+		}
+		return []byte("\r\n")
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+func collapseWhitespace(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if r == ' ' || r == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+// canonicalHeader applies the RFC 6376 section 3.4 header canonicalization
+// to one raw field, including its trailing CRLF.
+func canonicalHeader(raw, method string) string {
+	if method != "relaxed" {
+		return raw
+	}
+	name, value, _ := strings.Cut(raw, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.TrimSpace(collapseWhitespace(value))
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + value + "\r\n"
+}
+
+// signedHeaderData builds the data the signature covers: the h= fields,
+// each taken from the bottom of the header upwards, followed by the
+// signature field itself with an empty b= value and no trailing CRLF.
+func signedHeaderData(sig *dkimSignature, fields []headerField) []byte {
+	used := make(map[int]bool)
+	var buf bytes.Buffer
+	for _, name := range sig.headers {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if used[i] || !strings.EqualFold(fields[i].name, name) {
+				continue
+			}
+			used[i] = true
+			buf.WriteString(canonicalHeader(fields[i].raw, sig.headerC))
+			break
+		}
+	}
+	unsigned := signatureValuePattern.ReplaceAllString(sig.raw, "$1")
+	buf.WriteString(strings.TrimSuffix(canonicalHeader(unsigned, sig.headerC), "\r\n"))
+	return buf.Bytes()
+}
+
+func dkimStatus(results []DKIMResult) string {
+	if len(results) == 0 {
+		return "No DKIM signatures found"
+	}
+	passed := 0
+	for _, r := range results {
+		if r.Result == dkimPass {
+			passed++
+		}
+	}
+	return fmt.Sprintf("DKIM: %d of %d signature(s) verified", passed, len(results))
+}
+
+func renderDKIMResults(results []DKIMResult) string {
+	if results == nil {
+		return ""
+	}
+	if len(results) == 0 {
+		return "DKIM:    no signatures"
+	}
+	lines := make([]string, 0, len(results))
+	for i, r := range results {
+		label := "DKIM:    "
+		if i > 0 {
+			label = "         "
+		}
+		line := fmt.Sprintf("%s%s %s", label, verdictBadge(verdictCheck{name: "signature", result: r.Result}), r.Domain)
+		if r.Selector != "" {
+			line += " (s=" + r.Selector + ")"
+		}
+		if r.Algorithm != "" {
+			line += " " + r.Algorithm
+		}
+		if r.BodyHash != "" {
+			line += ", body hash " + r.BodyHash
+		}
+		if r.Detail != "" {
+			line += ": " + r.Detail
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
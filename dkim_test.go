@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const dkimMessage = "From: Acme <news@acme.example>\r\n" +
+	"To: inbox@example.com\r\n" +
+	"Subject: Signed  newsletter\r\n" +
+	"Date: Sat, 15 Mar 2025 10:30:00 +0000\r\n" +
+	"\r\n" +
+	"Hello   world \r\n" +
+	"\r\n" +
+	"\r\n"
+
+type fakeResolver map[string]string
+
+func (r fakeResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	record, ok := r[name]
+	if !ok {
+		return nil, &dnsNotFound{name: name}
+	}
+	return []string{record}, nil
+}
+
+type dnsNotFound struct{ name string }
+
+func (e *dnsNotFound) Error() string { return "no such host " + e.name }
+
+// signDKIM prepends a DKIM-Signature to raw, using the verifier's own
+// canonicalization so tests exercise the signing round trip.
+func signDKIM(t *testing.T, raw, algo, canon string, sign func(digest []byte) []byte) string {
+	t.Helper()
+	template := "DKIM-Signature: v=1; a=" + algo + "; c=" + canon + "; d=acme.example; s=s1;\r\n" +
+		"\th=from:to:subject:date; bh=AAAA; b=\r\n"
+	header, body := splitRawMessage([]byte(raw))
+	sig, err := parseDKIMSignature(template)
+	if err != nil && !strings.Contains(err.Error(), "missing b=") {
+		t.Fatalf("parse template: %v", err)
+	}
+	sig.algo, sig.headerC, sig.bodyC = algo, "simple", "simple"
+	sig.hash = crypto.SHA256
+	if h, b, ok := strings.Cut(canon, "/"); ok {
+		sig.headerC, sig.bodyC = h, b
+	}
+	sig.headers = []string{"from", "to", "subject", "date"}
+	sig.length = -1
+	bh := base64.StdEncoding.EncodeToString(computeBodyHash(sig, body))
+
+	sig.raw = strings.Replace(template, "bh=AAAA", "bh="+bh, 1)
+	h := crypto.SHA256.New()
+	h.Write(signedHeaderData(sig, splitHeaderFields(header)))
+	b := base64.StdEncoding.EncodeToString(sign(h.Sum(nil)))
+	return strings.TrimSuffix(sig.raw, "\r\n") + b + "\r\n" + raw
+}
+
+func rsaSigned(t *testing.T, raw, canon string) (string, fakeResolver) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	signed := signDKIM(t, raw, "rsa-sha256", canon, func(digest []byte) []byte {
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest)
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		return sig
+	})
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	return signed, fakeResolver{"s1._domainkey.acme.example": "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)}
+}
+
+func TestVerifyDKIM_RSARelaxedPasses(t *testing.T) {
+	signed, resolver := rsaSigned(t, dkimMessage, "relaxed/relaxed")
+	results := verifyDKIM(context.Background(), []byte(signed), resolver)
+	if len(results) != 1 {
+		t.Fatalf("results = %#v", results)
+	}
+	r := results[0]
+	if r.Result != dkimPass || r.BodyHash != dkimPass || r.Domain != "acme.example" || r.Selector != "s1" {
+		t.Fatalf("result = %#v", r)
+	}
+}
+
+func TestVerifyDKIM_Ed25519SimplePasses(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	signed := signDKIM(t, dkimMessage, "ed25519-sha256", "simple/simple", func(digest []byte) []byte {
+		return ed25519.Sign(priv, digest)
+	})
+	resolver := fakeResolver{"s1._domainkey.acme.example": "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub)}
+
+	results := verifyDKIM(context.Background(), []byte(signed), resolver)
+	if len(results) != 1 || results[0].Result != dkimPass {
+		t.Fatalf("results = %#v", results)
+	}
+}
+
+func TestVerifyDKIM_DetectsTampering(t *testing.T) {
+	signed, resolver := rsaSigned(t, dkimMessage, "relaxed/relaxed")
+
+	body := strings.Replace(signed, "Hello   world", "Hello there", 1)
+	r := verifyDKIM(context.Background(), []byte(body), resolver)[0]
+	if r.Result != dkimFail || r.BodyHash != dkimFail {
+		t.Fatalf("tampered body result = %#v", r)
+	}
+
+	header := strings.Replace(signed, "Signed  newsletter", "Urgent invoice", 1)
+	r = verifyDKIM(context.Background(), []byte(header), resolver)[0]
+	if r.Result != dkimFail || r.BodyHash != dkimPass {
+		t.Fatalf("tampered header result = %#v", r)
+	}
+}
+
+func TestVerifyDKIM_RelaxedToleratesRewrapping(t *testing.T) {
+	signed, resolver := rsaSigned(t, dkimMessage, "relaxed/relaxed")
+	rewrapped := strings.Replace(signed, "Subject: Signed  newsletter", "Subject:   Signed\r\n newsletter", 1)
+	rewrapped = strings.ReplaceAll(rewrapped, "\r\n", "\n")
+	r := verifyDKIM(context.Background(), []byte(rewrapped), resolver)[0]
+	if r.Result != dkimPass {
+		t.Fatalf("result = %#v", r)
+	}
+}
+
+func TestVerifyDKIM_KeyProblems(t *testing.T) {
+	signed, _ := rsaSigned(t, dkimMessage, "relaxed/relaxed")
+
+	r := verifyDKIM(context.Background(), []byte(signed), fakeResolver{})[0]
+	if r.Result != dkimPermError || !strings.Contains(r.Detail, "s1._domainkey.acme.example") {
+		t.Fatalf("missing key result = %#v", r)
+	}
+	revoked := fakeResolver{"s1._domainkey.acme.example": "v=DKIM1; p="}
+	r = verifyDKIM(context.Background(), []byte(signed), revoked)[0]
+	if r.Result != dkimPermError || !strings.Contains(r.Detail, "revoked") {
+		t.Fatalf("revoked key result = %#v", r)
+	}
+}
+
+func TestVerifyDKIM_NoSignatures(t *testing.T) {
+	results := verifyDKIM(context.Background(), []byte(dkimMessage), fakeResolver{})
+	if len(results) != 0 {
+		t.Fatalf("results = %#v", results)
+	}
+	if got := renderDKIMResults([]DKIMResult{}); got != "DKIM:    no signatures" {
+		t.Fatalf("render = %q", got)
+	}
+}
+
+// The canonicalization examples from RFC 6376 section 3.4.6.
+func TestCanonicalization_RFCExample(t *testing.T) {
+	fields := splitHeaderFields([]byte("A: X\r\nB : Y\t\r\n\tZ  \r\n"))
+	var relaxed string
+	for _, f := range fields {
+		relaxed += canonicalHeader(f.raw, "relaxed")
+	}
+	if relaxed != "a:X\r\nb:Y Z\r\n" {
+		t.Fatalf("relaxed headers = %q", relaxed)
+	}
+
+	body := []byte(" C \r\nD \t E\r\n\r\n\r\n")
+	if got := string(canonicalBody(body, "relaxed")); got != " C\r\nD E\r\n" {
+		t.Fatalf("relaxed body = %q", got)
+	}
+	if got := string(canonicalBody(body, "simple")); got != " C \r\nD \t E\r\n" {
+		t.Fatalf("simple body = %q", got)
+	}
+	if got := string(canonicalBody(nil, "simple")); got != "\r\n" {
+		t.Fatalf("empty simple body = %q", got)
+	}
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.txt")
+	data := "# test keys\ns1._domainkey.acme.example. \"v=DKIM1; k=ed25519; p=abc\"\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	r, err := loadKeyFile(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	records, err := r.LookupTXT(context.Background(), "S1._domainkey.acme.example")
+	if err != nil || len(records) != 1 || records[0] != "v=DKIM1; k=ed25519; p=abc" {
+		t.Fatalf("records = %#v err = %v", records, err)
+	}
+	if _, err := r.LookupTXT(context.Background(), "other._domainkey.acme.example"); err == nil {
+		t.Fatal("expected lookup error for unknown name")
+	}
+}
+
+func TestDKIMResolverFromEnv_ReportsUnreadableKeyFile(t *testing.T) {
+	t.Setenv("SMAILER_LABELS_FILE", filepath.Join(t.TempDir(), "labels.json"))
+	t.Setenv("SMAILER_DKIM_KEYS", filepath.Join(t.TempDir(), "missing.txt"))
+
+	r, err := dkimResolverFromEnv()
+	if err == nil || !strings.Contains(err.Error(), "DKIM key file") {
+		t.Fatalf("err = %v", err)
+	}
+	if _, ok := r.(dnsResolver); ok {
+		t.Error("a missing key file must not fall back to DNS")
+	}
+
+	m := initialModel(&mockS3{}, "test-bucket", "inbound/")
+	if !strings.Contains(m.statusMessage, "Could not read DKIM key file") {
+		t.Errorf("status = %q", m.statusMessage)
+	}
+}
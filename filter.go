@@ -0,0 +1,194 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+type filterTerm struct {
+	field string
+	value string
+}
+
+// filterFields holds the qualifiers understood in filter queries, such as
+// from:@acme.example. Address qualifiers match the address part only, so a
+// display name cannot be used to spoof a match.
+var filterFields = map[string]func(e Email, value string) bool{
+	"from": func(e Email, value string) bool {
+		if len(e.FromAddrs) == 0 {
+			return containsFold(e.From, value)
+		}
+		return anyContainsFold(addressValues(e.FromAddrs), value)
+	},
+	"to": func(e Email, value string) bool {
+		if len(e.ToAddrs) == 0 && len(e.CcAddrs) == 0 && len(e.DeliveredTo) == 0 {
+			return containsFold(e.To, value)
+		}
+		recipients := append(addressValues(e.ToAddrs), addressValues(e.CcAddrs)...)
+		recipients = append(recipients, addressValues(e.DeliveredTo)...)
+		return anyContainsFold(recipients, value)
+	},
+	"cc": func(e Email, value string) bool {
+		return anyContainsFold(addressValues(e.CcAddrs), value)
+	},
+	"subject": func(e Email, value string) bool {
+		return containsFold(e.Subject, value)
+	},
+	"key": func(e Email, value string) bool {
+		return containsFold(e.Key, value)
+	},
+	"spam":   matchVerdictFilter("spam"),
+	"virus":  matchVerdictFilter("virus"),
+	"dkim":   matchVerdictFilter("dkim"),
+	"spf":    matchVerdictFilter("spf"),
+	"dmarc":  matchVerdictFilter("dmarc"),
+	"checks": matchChecksFilter,
+	"type":   matchKindFilter,
+	"is":     matchStateFilter,
+	"assignee": func(e Email, value string) bool {
+		return containsFold(e.assignee(), value)
+	},
+	"label": func(e Email, value string) bool {
+		for _, l := range e.Labels {
+			if strings.EqualFold(l, value) {
+				return true
+			}
+		}
+		return false
+	},
+	"older": matchAgeFilter(true),
+	"newer": matchAgeFilter(false),
+}
+
+// matchStateFilter handles is:unread, is:read, is:flagged and is:assigned.
+func matchStateFilter(e Email, value string) bool {
+	switch strings.ToLower(value) {
+	case "unread":
+		return e.isUnread()
+	case "read":
+		return !e.isUnread()
+	case "flagged":
+		return e.isFlagged()
+	case "unflagged":
+		return !e.isFlagged()
+	case "assigned":
+		return e.assignee() != ""
+	case "unassigned":
+		return e.assignee() == ""
+	}
+	return false
+}
+
+// filterNow is the clock age qualifiers are measured against.
+var filterNow = time.Now
+
+// matchAgeFilter compares when an email was delivered, its S3 LastModified
+// time, with an age such as 30d, 12h or 2w, or with a calendar date such as
+// 2025-01-31. The Date header is set by the sender and is not used, so a
+// purge selects the same mail whether or not the query reads headers.
+func matchAgeFilter(older bool) func(e Email, value string) bool {
+	return func(e Email, value string) bool {
+		cutoff, ok := parseAge(value, filterNow())
+		date := e.S3Date
+		if !ok || date.IsZero() {
+			return false
+		}
+		if older {
+			return date.Before(cutoff)
+		}
+		return !date.Before(cutoff)
+	}
+}
+
+func parseAge(value string, now time.Time) (time.Time, bool) {
+	if t, err := time.ParseInLocation("2006-01-02", value, now.Location()); err == nil {
+		return t, true
+	}
+	if len(value) < 2 {
+		return time.Time{}, false
+	}
+	n, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || n < 0 {
+		return time.Time{}, false
+	}
+	switch strings.ToLower(value[len(value)-1:]) {
+	case "h":
+		return now.Add(-time.Duration(n) * time.Hour), true
+	case "d":
+		return now.AddDate(0, 0, -n), true
+	case "w":
+		return now.AddDate(0, 0, -7*n), true
+	case "m":
+		return now.AddDate(0, -n, 0), true
+	case "y":
+		return now.AddDate(-n, 0, 0), true
+	}
+	return time.Time{}, false
+}
+
+func containsFold(haystack, needle string) bool {
+	return strings.Contains(strings.ToLower(haystack), strings.ToLower(needle))
+}
+
+func anyContainsFold(values []string, needle string) bool {
+	for _, v := range values {
+		if containsFold(v, needle) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitFilterTokens(query string) []string {
+	var tokens []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case (r == ' ' || r == '\t') && !quoted:
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
+
+// parseFilterQuery separates field:value terms from the free text, which is
+// still matched as a single phrase across the main headers and key.
+func parseFilterQuery(query string) ([]filterTerm, string) {
+	var terms []filterTerm
+	var text []string
+	for _, token := range splitFilterTokens(query) {
+		field, value, ok := strings.Cut(token, ":")
+		field = strings.ToLower(field)
+		if _, known := filterFields[field]; ok && known && value != "" {
+			terms = append(terms, filterTerm{field: field, value: value})
+			continue
+		}
+		text = append(text, token)
+	}
+	return terms, strings.Join(text, " ")
+}
+
+func emailMatchesFilter(e Email, terms []filterTerm, text string) bool {
+	for _, term := range terms {
+		if !filterFields[term.field](e, term.value) {
+			return false
+		}
+	}
+	if text == "" {
+		return true
+	}
+	haystack := strings.Join([]string{e.From, e.To, e.Subject, e.Key}, " ")
+	return containsFold(haystack, text)
+}
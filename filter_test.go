@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseFilterQuery_SplitsQualifiedTerms(t *testing.T) {
+	terms, text := parseFilterQuery(`from:@acme.example subject:"weekly report" invoice due`)
+
+	if len(terms) != 2 {
+		t.Fatalf("terms = %#v", terms)
+	}
+	if terms[0] != (filterTerm{field: "from", value: "@acme.example"}) || terms[1] != (filterTerm{field: "subject", value: "weekly report"}) {
+		t.Fatalf("terms = %#v", terms)
+	}
+	if text != "invoice due" {
+		t.Fatalf("text = %q", text)
+	}
+}
+
+func TestParseFilterQuery_UnknownFieldIsText(t *testing.T) {
+	terms, text := parseFilterQuery("re: hello")
+
+	if len(terms) != 0 || text != "re: hello" {
+		t.Fatalf("terms = %#v, text = %q", terms, text)
+	}
+}
+
+func TestEmailMatchesFilter_AddressQualifiersIgnoreDisplayName(t *testing.T) {
+	spoof := Email{
+		From:      `"support@acme.example" <attacker@evil.example>`,
+		FromAddrs: []Address{{Name: "support@acme.example", Address: "attacker@evil.example"}},
+	}
+	real := Email{
+		From:      "Acme <support@acme.example>",
+		FromAddrs: []Address{{Name: "Acme", Address: "support@acme.example"}},
+		CcAddrs:   []Address{{Address: "team@example.com"}},
+	}
+
+	terms, text := parseFilterQuery("from:acme.example")
+	if emailMatchesFilter(spoof, terms, text) {
+		t.Error("display name should not satisfy from:")
+	}
+	if !emailMatchesFilter(real, terms, text) {
+		t.Error("address should satisfy from:")
+	}
+
+	terms, text = parseFilterQuery("to:team@")
+	if !emailMatchesFilter(real, terms, text) {
+		t.Error("to: should include Cc recipients")
+	}
+
+	terms, text = parseFilterQuery("acme")
+	if !emailMatchesFilter(spoof, terms, text) {
+		t.Error("free text should still match display names")
+	}
+}
+
+func TestFilteredEmails_CombinesTermsAndText(t *testing.T) {
+	m := newTestModel()
+	m.emails = []Email{
+		{From: "a@acme.example", FromAddrs: []Address{{Address: "a@acme.example"}}, Subject: "Invoice"},
+		{From: "b@other.example", FromAddrs: []Address{{Address: "b@other.example"}}, Subject: "Invoice"},
+		{From: "c@acme.example", FromAddrs: []Address{{Address: "c@acme.example"}}, Subject: "Hello"},
+	}
+	m.filterQuery = "from:acme.example invoice"
+
+	filtered := m.filteredEmails()
+
+	if len(filtered) != 1 || filtered[0].From != "a@acme.example" {
+		t.Fatalf("filtered = %#v", filtered)
+	}
+}
+
+func TestAgeFilters_CompareWithRelativeAndCalendarDates(t *testing.T) {
+	now := time.Date(2025, 3, 31, 12, 0, 0, 0, time.UTC)
+	filterNow = func() time.Time { return now }
+	defer func() { filterNow = time.Now }()
+
+	old := Email{S3Date: now.AddDate(0, 0, -40)}
+	recent := Email{S3Date: now.Add(-2 * time.Hour)}
+	backdated := Email{Date: now.AddDate(-1, 0, 0), S3Date: now.Add(-2 * time.Hour)}
+	undelivered := Email{Date: now.AddDate(0, 0, -40)}
+
+	cases := []struct {
+		query string
+		email Email
+		want  bool
+	}{
+		{"older:30d", old, true},
+		{"older:30d", recent, false},
+		{"newer:3h", recent, true},
+		{"newer:1w", old, false},
+		{"older:1m", old, true},
+		{"older:2025-03-01", old, true},
+		{"newer:2025-03-01", recent, true},
+		{"older:30d", backdated, false},
+		{"newer:3h", backdated, true},
+		{"older:30d", undelivered, false},
+		{"newer:30d", undelivered, false},
+		{"older:soon", old, false},
+	}
+	for _, tc := range cases {
+		terms, text := parseFilterQuery(tc.query)
+		if got := emailMatchesFilter(tc.email, terms, text); got != tc.want {
+			t.Errorf("%s on %v = %v, want %v", tc.query, tc.email.S3Date, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type segmentKind int
+
+const (
+	segmentText segmentKind = iota
+	segmentQuote
+	segmentSignature
+)
+
+type bodySegment struct {
+	kind  segmentKind
+	lines []string
+}
+
+var (
+	attributionLine     = regexp.MustCompile(`(?i)^\s*on\s.+\s(wrote|writes):\s*$`)
+	attributionStart    = regexp.MustCompile(`(?i)^\s*on\s.+`)
+	attributionEnd      = regexp.MustCompile(`(?i)\s(wrote|writes):\s*$`)
+	outlookSeparator    = regexp.MustCompile(`(?i)^\s*-{2,}\s*(original message|forwarded message)\s*-{2,}\s*$`)
+	outlookRule         = regexp.MustCompile(`^\s*_{10,}\s*$`)
+	outlookHeaderFrom   = regexp.MustCompile(`(?i)^\s*\**from:\**\s+\S`)
+	outlookHeaderSent   = regexp.MustCompile(`(?i)^\s*\**(sent|date):\**\s+\S`)
+	signatureDelimiters = map[string]bool{"-- ": true, "--": true}
+)
+
+func isQuotedLine(line string) bool {
+	return strings.HasPrefix(strings.TrimLeft(line, " \t"), ">")
+}
+
+// splitBodySegments splits a message body into plain text, quoted history and
+// signature blocks so the viewer can fold the parts nobody needs to reread.
+func splitBodySegments(body string) []bodySegment {
+	lines := strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n")
+	var segments []bodySegment
+	push := func(kind segmentKind, block []string) {
+		if len(block) == 0 {
+			return
+		}
+		if n := len(segments); n > 0 && segments[n-1].kind == kind {
+			segments[n-1].lines = append(segments[n-1].lines, block...)
+			return
+		}
+		segments = append(segments, bodySegment{kind: kind, lines: block})
+	}
+
+	var text []string
+	flushText := func() {
+		push(segmentText, text)
+		text = nil
+	}
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isOutlookBoundary(lines, i):
+			flushText()
+			push(segmentQuote, lines[i:])
+			i = len(lines)
+		case attributionAt(lines, i) > 0:
+			end := i + attributionAt(lines, i)
+			end = quoteBlockEnd(lines, end)
+			flushText()
+			push(segmentQuote, lines[i:end])
+			i = end
+		case isQuotedLine(line):
+			end := quoteBlockEnd(lines, i)
+			flushText()
+			push(segmentQuote, lines[i:end])
+			i = end
+		case signatureDelimiters[line]:
+			end := i + 1
+			for end < len(lines) && !isQuotedLine(lines[end]) && attributionAt(lines, end) == 0 && !isOutlookBoundary(lines, end) {
+				end++
+			}
+			flushText()
+			push(segmentSignature, lines[i:end])
+			i = end
+		default:
+			text = append(text, line)
+			i++
+		}
+	}
+	flushText()
+	return segments
+}
+
+// attributionAt reports how many lines an "On ... wrote:" attribution spans
+// when it starts at i and is followed by quoted text, or 0 otherwise. Mail
+// clients often wrap long attributions over two lines.
+func attributionAt(lines []string, i int) int {
+	span := 0
+	switch {
+	case attributionLine.MatchString(lines[i]):
+		span = 1
+	case attributionStart.MatchString(lines[i]) && i+1 < len(lines) && attributionEnd.MatchString(lines[i+1]):
+		span = 2
+	default:
+		return 0
+	}
+	next := i + span
+	for next < len(lines) && strings.TrimSpace(lines[next]) == "" {
+		next++
+	}
+	if next < len(lines) && isQuotedLine(lines[next]) {
+		return span
+	}
+	return 0
+}
+
+func isOutlookBoundary(lines []string, i int) bool {
+	line := lines[i]
+	if outlookSeparator.MatchString(line) {
+		return true
+	}
+	if outlookRule.MatchString(line) {
+		for j := i + 1; j < len(lines) && j <= i+2; j++ {
+			if outlookHeaderFrom.MatchString(lines[j]) {
+				return true
+			}
+		}
+		return false
+	}
+	if outlookHeaderFrom.MatchString(line) {
+		for j := i + 1; j < len(lines) && j <= i+3; j++ {
+			if outlookHeaderSent.MatchString(lines[j]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// quoteBlockEnd returns the index just past the quoted block starting at or
+// after i. Blank lines inside a quote are kept when more quoted text follows.
+func quoteBlockEnd(lines []string, i int) int {
+	end := i
+	for j := i; j < len(lines); j++ {
+		switch {
+		case isQuotedLine(lines[j]):
+			end = j + 1
+		case strings.TrimSpace(lines[j]) == "":
+			continue
+		default:
+			return end
+		}
+	}
+	return end
+}
+
+func foldPlaceholder(segment bodySegment) string {
+	count := 0
+	for _, line := range segment.lines {
+		if strings.TrimSpace(line) != "" {
+			count++
+		}
+	}
+	label := "quoted"
+	if segment.kind == segmentSignature {
+		label = "signature"
+	}
+	return helpStyle.Render(fmt.Sprintf("[... %d %s line(s) hidden, z to expand]", count, label))
+}
+
+// renderBodySegments renders each segment of the body and returns the joined
+// output together with the line each segment starts on, which lets the viewer
+// keep its scroll position when folds open or close.
+func (m model) renderBodySegments(e *Email) (string, []int) {
+	segments := splitBodySegments(e.Body)
+	rendered := make([]string, 0, len(segments))
+	starts := make([]int, 0, len(segments))
+	line := 0
+	for _, segment := range segments {
+		var out string
+		if segment.kind != segmentText && !m.showQuoted {
+			out = foldPlaceholder(segment)
+		} else {
+			out = m.renderMarkdown(strings.Join(segment.lines, "\n"))
+		}
+		starts = append(starts, line)
+		rendered = append(rendered, out)
+		line += strings.Count(out, "\n") + 2
+	}
+	if images := m.renderImages(e.Images); images != "" {
+		rendered = append(rendered, images)
+	}
+	return strings.Join(rendered, "\n\n"), starts
+}
+
+func (m model) renderMarkdown(text string) string {
+	if m.glamourRenderer == nil {
+		return text
+	}
+	rendered, err := m.glamourRenderer.Render(text)
+	if err != nil {
+		return text
+	}
+	return strings.Trim(rendered, "\n")
+}
+
+func (m *model) toggleQuoted() {
+	if m.selectedEmail == nil {
+		return
+	}
+	_, before := m.renderBodySegments(m.selectedEmail)
+	top := m.viewport.YOffset
+	index, offset := 0, 0
+	for i, start := range before {
+		if start <= top {
+			index, offset = i, top-start
+		}
+	}
+
+	m.showQuoted = !m.showQuoted
+	content, after := m.renderBodySegments(m.selectedEmail)
+	m.setViewerContent(content)
+	if index >= len(after) {
+		return
+	}
+	segments := splitBodySegments(m.selectedEmail.Body)
+	if segments[index].kind != segmentText {
+		offset = 0
+	}
+	m.viewport.SetYOffset(after[index] + offset)
+}
+
+func foldStatus(showQuoted bool) string {
+	if showQuoted {
+		return "Showing quoted text"
+	}
+	return "Quoted text folded"
+}
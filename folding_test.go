@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestSplitBodySegments_DetectsQuotedReply(t *testing.T) {
+	body := "Thanks, that fixed it.\n\nOn Mon, 3 Mar 2025 at 10:00, Support <help@example.com> wrote:\n> Have you tried turning it off?\n>\n> Regards"
+
+	segments := splitBodySegments(body)
+
+	if len(segments) != 2 {
+		t.Fatalf("expected 2 segments, got %d: %#v", len(segments), segments)
+	}
+	if segments[0].kind != segmentText || segments[1].kind != segmentQuote {
+		t.Fatalf("unexpected kinds: %#v", segments)
+	}
+	if !strings.HasPrefix(segments[1].lines[0], "On Mon") {
+		t.Fatalf("attribution should start the quote, got %q", segments[1].lines[0])
+	}
+}
+
+func TestSplitBodySegments_DetectsWrappedAttribution(t *testing.T) {
+	body := "Reply\nOn Mon, 3 Mar 2025 at 10:00, Someone With A Long Name\n<someone@example.com> wrote:\n\n> quoted"
+
+	segments := splitBodySegments(body)
+
+	if len(segments) != 2 || len(segments[1].lines) != 4 {
+		t.Fatalf("unexpected segments: %#v", segments)
+	}
+}
+
+func TestSplitBodySegments_OutlookSeparatorRunsToEnd(t *testing.T) {
+	body := "See below.\n\n-----Original Message-----\nFrom: Bob\nSent: Monday\nSubject: Hi\n\nOld text"
+
+	segments := splitBodySegments(body)
+
+	if len(segments) != 2 || segments[1].kind != segmentQuote {
+		t.Fatalf("unexpected segments: %#v", segments)
+	}
+	if segments[1].lines[len(segments[1].lines)-1] != "Old text" {
+		t.Fatalf("quote should run to end, got %#v", segments[1].lines)
+	}
+}
+
+func TestSplitBodySegments_OutlookHeaderBlock(t *testing.T) {
+	body := "Sure.\n________________________________\nFrom: Bob <bob@example.com>\nSent: 03 March 2025 10:00\nTo: Alice\n\nOld text"
+
+	segments := splitBodySegments(body)
+
+	if len(segments) != 2 || segments[1].kind != segmentQuote {
+		t.Fatalf("unexpected segments: %#v", segments)
+	}
+}
+
+func TestSplitBodySegments_SignatureStopsAtQuote(t *testing.T) {
+	body := "Hello\n-- \nAlice\nAcme Ltd\n> quoted"
+
+	segments := splitBodySegments(body)
+
+	if len(segments) != 3 {
+		t.Fatalf("expected 3 segments, got %#v", segments)
+	}
+	if segments[1].kind != segmentSignature || len(segments[1].lines) != 3 {
+		t.Fatalf("unexpected signature: %#v", segments[1])
+	}
+	if segments[2].kind != segmentQuote {
+		t.Fatalf("expected trailing quote, got %#v", segments[2])
+	}
+}
+
+func TestSplitBodySegments_WroteWithoutQuoteIsText(t *testing.T) {
+	segments := splitBodySegments("On Monday the team wrote:\nnothing quoted here")
+
+	if len(segments) != 1 || segments[0].kind != segmentText {
+		t.Fatalf("unexpected segments: %#v", segments)
+	}
+}
+
+func TestGetEmailBody_FoldsQuotesByDefault(t *testing.T) {
+	m := model{}
+	email := &Email{Body: "Reply\n> one\n> two"}
+
+	folded := m.getEmailBody(email)
+	if strings.Contains(folded, "one") || !strings.Contains(folded, "2 quoted line(s) hidden") {
+		t.Fatalf("expected folded quote, got %q", folded)
+	}
+
+	m.showQuoted = true
+	expanded := m.getEmailBody(email)
+	if !strings.Contains(expanded, "> one") {
+		t.Fatalf("expected expanded quote, got %q", expanded)
+	}
+}
+
+func TestToggleQuoted_KeepsScrollPosition(t *testing.T) {
+	m := newReadyTestModel()
+	m.glamourRenderer = nil
+	m.viewport.Height = 5
+	lines := make([]string, 0, 40)
+	for i := 0; i < 10; i++ {
+		lines = append(lines, "> quoted")
+	}
+	for i := 0; i < 30; i++ {
+		lines = append(lines, "line")
+	}
+	lines[len(lines)-5] = "anchor"
+	m.selectedEmail = &Email{Body: strings.Join(lines, "\n"), BodyLoaded: true}
+	m.viewport.SetContent(m.getEmailBody(m.selectedEmail))
+	m.viewport.SetYOffset(27)
+	anchor := strings.Split(m.viewport.View(), "\n")[0]
+
+	m.toggleQuoted()
+
+	if got := strings.Split(m.viewport.View(), "\n")[0]; got != anchor {
+		t.Fatalf("top line = %q, want %q", got, anchor)
+	}
+	if m.viewport.YOffset != 27+9 {
+		t.Fatalf("YOffset = %d, want %d", m.viewport.YOffset, 36)
+	}
+}
+
+func TestUpdate_ViewZTogglesQuotes(t *testing.T) {
+	m := newReadyTestModel()
+	m.state = viewState
+	m.selectedEmail = &Email{Body: "Reply\n> quoted", BodyLoaded: true}
+
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'z'}})
+	rm := result.(model)
+
+	if !rm.showQuoted {
+		t.Fatal("z should expand quoted text")
+	}
+	if rm.statusMessage != "Showing quoted text" {
+		t.Fatalf("status = %q", rm.statusMessage)
+	}
+}
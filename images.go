@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jhillyerd/enmime"
+)
+
+const (
+	imageProtocolNone   = ""
+	imageProtocolKitty  = "kitty"
+	imageProtocolITerm  = "iterm"
+	imageProtocolSixel  = "sixel"
+	defaultImageMaxSize = 2 << 20
+	maxImageColumns     = 60
+	maxImageRows        = 20
+	cellPixelWidth      = 8
+	cellPixelHeight     = 16
+	kittyChunkSize      = 4096
+	// maxImagePixels caps the size an image may declare before it is
+	// decoded, as a tiny compressed file can claim enough pixels to exhaust
+	// memory.
+	maxImagePixels = 4096 * 4096
+)
+
+type InlineImage struct {
+	Name        string
+	ContentID   string
+	ContentType string
+	Data        []byte
+}
+
+// detectImageProtocol picks a graphics protocol from what the terminal
+// advertises in its environment. SMAILER_IMAGES overrides the detection and
+// accepts kitty, iterm, sixel or none.
+func detectImageProtocol() string {
+	switch strings.ToLower(os.Getenv("SMAILER_IMAGES")) {
+	case "kitty":
+		return imageProtocolKitty
+	case "iterm", "iterm2":
+		return imageProtocolITerm
+	case "sixel":
+		return imageProtocolSixel
+	case "none", "off", "0":
+		return imageProtocolNone
+	}
+	term := strings.ToLower(os.Getenv("TERM"))
+	program := os.Getenv("TERM_PROGRAM")
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "" || strings.Contains(term, "kitty") || program == "ghostty":
+		return imageProtocolKitty
+	case program == "iTerm.app" || program == "WezTerm" || os.Getenv("LC_TERMINAL") == "iTerm2":
+		return imageProtocolITerm
+	case strings.Contains(term, "sixel") || strings.HasPrefix(term, "foot") || strings.HasPrefix(term, "mlterm"):
+		return imageProtocolSixel
+	}
+	return imageProtocolNone
+}
+
+func imageMaxSizeFromEnv() int {
+	if raw := os.Getenv("SMAILER_IMAGE_MAX_BYTES"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultImageMaxSize
+}
+
+func collectImages(env *enmime.Envelope) []InlineImage {
+	var images []InlineImage
+	seen := make(map[*enmime.Part]bool)
+	groups := [][]*enmime.Part{env.Inlines, env.OtherParts, env.Attachments}
+	for _, parts := range groups {
+		for _, part := range parts {
+			if seen[part] || !strings.HasPrefix(strings.ToLower(part.ContentType), "image/") {
+				continue
+			}
+			seen[part] = true
+			name := strings.TrimSpace(part.FileName)
+			if name == "" {
+				name = strings.Trim(part.ContentID, "<>")
+			}
+			if name == "" {
+				name = "image"
+			}
+			images = append(images, InlineImage{
+				Name:        name,
+				ContentID:   strings.Trim(part.ContentID, "<>"),
+				ContentType: strings.ToLower(part.ContentType),
+				Data:        append([]byte(nil), part.Content...),
+			})
+		}
+	}
+	return images
+}
+
+func (m model) renderImages(images []InlineImage) string {
+	if len(images) == 0 {
+		return ""
+	}
+	maxSize := m.imageMaxSize
+	if maxSize <= 0 {
+		maxSize = defaultImageMaxSize
+	}
+	maxCols := min(maxImageColumns, max(10, m.width-8))
+
+	blocks := []string{helpStyle.Render(fmt.Sprintf("Images (%d)", len(images)))}
+	for _, img := range images {
+		label := imageLabel(img)
+		if m.imageProtocol == imageProtocolNone {
+			blocks = append(blocks, helpStyle.Render("[image: "+label+"]"))
+			continue
+		}
+		if len(img.Data) > maxSize {
+			blocks = append(blocks, helpStyle.Render("[image: "+label+", too large to display]"))
+			continue
+		}
+		rendered, err := encodeTerminalImage(m.imageProtocol, img.Data, maxCols, maxImageRows)
+		if err != nil {
+			blocks = append(blocks, helpStyle.Render("[image: "+label+", "+err.Error()+"]"))
+			continue
+		}
+		blocks = append(blocks, helpStyle.Render(label)+"\n"+rendered)
+	}
+	return strings.Join(blocks, "\n")
+}
+
+func imageLabel(img InlineImage) string {
+	parts := []string{img.Name}
+	if img.ContentType != "" {
+		parts = append(parts, img.ContentType)
+	}
+	parts = append(parts, formatBytes(int64(len(img.Data))))
+	if img.ContentID != "" {
+		parts = append(parts, "cid:"+img.ContentID)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
+
+// fitCells scales an image of the given pixel size into a box of at most
+// maxCols by maxRows terminal cells, preserving its aspect ratio.
+func fitCells(width, height, maxCols, maxRows int) (int, int) {
+	cols := max(1, (width+cellPixelWidth-1)/cellPixelWidth)
+	rows := max(1, (height+cellPixelHeight-1)/cellPixelHeight)
+	if cols > maxCols {
+		rows = max(1, rows*maxCols/cols)
+		cols = maxCols
+	}
+	if rows > maxRows {
+		cols = max(1, cols*maxRows/rows)
+		rows = maxRows
+	}
+	return cols, rows
+}
+
+// encodeTerminalImage returns the escape sequence that draws the image
+// followed by blank lines reserving the rows it covers, so the viewport keeps
+// its line accounting intact.
+func encodeTerminalImage(protocol string, data []byte, maxCols, maxRows int) (string, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("unsupported image format")
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxImagePixels {
+		return "", fmt.Errorf("%dx%d pixels, too large to display", config.Width, config.Height)
+	}
+	cols, rows := fitCells(config.Width, config.Height, maxCols, maxRows)
+
+	var seq string
+	switch protocol {
+	case imageProtocolKitty, imageProtocolSixel:
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return "", fmt.Errorf("unsupported image format")
+		}
+		scaled := scaleImage(img, cols*cellPixelWidth, rows*cellPixelHeight)
+		if protocol == imageProtocolSixel {
+			seq = sixelImage(scaled)
+		} else if seq, err = kittyImage(scaled, cols, rows); err != nil {
+			return "", err
+		}
+	case imageProtocolITerm:
+		seq = itermImage(data, cols, rows)
+	default:
+		return "", fmt.Errorf("no image protocol")
+	}
+	return seq + strings.Repeat("\n", rows-1), nil
+}
+
+// kittyImage sends img as PNG; callers scale it to the cells it covers
+// first, so the payload is not the full-resolution original.
+func kittyImage(img image.Image, cols, rows int) (string, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	payload := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	var out strings.Builder
+	for i := 0; i < len(payload); i += kittyChunkSize {
+		end := min(i+kittyChunkSize, len(payload))
+		more := 0
+		if end < len(payload) {
+			more = 1
+		}
+		if i == 0 {
+			fmt.Fprintf(&out, "\x1b_Ga=T,f=100,q=2,C=1,c=%d,r=%d,m=%d;%s\x1b\\", cols, rows, more, payload[i:end])
+		} else {
+			fmt.Fprintf(&out, "\x1b_Gm=%d;%s\x1b\\", more, payload[i:end])
+		}
+	}
+	return out.String(), nil
+}
+
+func itermImage(data []byte, cols, rows int) string {
+	return fmt.Sprintf("\x1b]1337;File=inline=1;size=%d;width=%d;height=%d;preserveAspectRatio=1:%s\a",
+		len(data), cols, rows, base64.StdEncoding.EncodeToString(data))
+}
+
+func scaleImage(src image.Image, maxWidth, maxHeight int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxWidth && height <= maxHeight {
+		return src
+	}
+	scale := min(float64(maxWidth)/float64(width), float64(maxHeight)/float64(height))
+	dstW, dstH := max(1, int(float64(width)*scale)), max(1, int(float64(height)*scale))
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			dst.Set(x, y, src.At(bounds.Min.X+x*width/dstW, bounds.Min.Y+y*height/dstH))
+		}
+	}
+	return dst
+}
+
+// sixelImage encodes the image against the 216-colour web-safe palette, which
+// every sixel terminal supports and keeps the output small.
+func sixelImage(src image.Image) string {
+	bounds := src.Bounds()
+	pal := color.Palette(palette.WebSafe)
+	img := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), pal)
+	draw.Draw(img, img.Bounds(), src, bounds.Min, draw.Src)
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "\x1bPq\"1;1;%d;%d", width, height)
+	for i, c := range pal {
+		r, g, b, _ := c.RGBA()
+		fmt.Fprintf(&out, "#%d;2;%d;%d;%d", i, r*100/0xffff, g*100/0xffff, b*100/0xffff)
+	}
+
+	for band := 0; band < height; band += 6 {
+		used := make(map[uint8]bool)
+		for y := band; y < min(band+6, height); y++ {
+			for x := 0; x < width; x++ {
+				used[img.ColorIndexAt(x, y)] = true
+			}
+		}
+		first := true
+		for idx := 0; idx < len(pal); idx++ {
+			if !used[uint8(idx)] {
+				continue
+			}
+			if !first {
+				out.WriteByte('$')
+			}
+			first = false
+			fmt.Fprintf(&out, "#%d", idx)
+			var run byte
+			count := 0
+			flush := func() {
+				switch {
+				case count == 0:
+				case count > 3:
+					fmt.Fprintf(&out, "!%d%c", count, run)
+				default:
+					out.WriteString(strings.Repeat(string(run), count))
+				}
+			}
+			for x := 0; x < width; x++ {
+				var bits byte
+				for dy := 0; dy < 6 && band+dy < height; dy++ {
+					if img.ColorIndexAt(x, band+dy) == uint8(idx) {
+						bits |= 1 << dy
+					}
+				}
+				ch := 63 + bits
+				if ch == run {
+					count++
+					continue
+				}
+				flush()
+				run, count = ch, 1
+			}
+			flush()
+		}
+		out.WriteByte('-')
+	}
+	out.WriteString("\x1b\\")
+	return out.String()
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectImageProtocol_UsesOverrideAndEnvironment(t *testing.T) {
+	t.Setenv("SMAILER_IMAGES", "")
+	t.Setenv("KITTY_WINDOW_ID", "")
+	t.Setenv("TERM", "xterm-256color")
+	t.Setenv("TERM_PROGRAM", "")
+	t.Setenv("LC_TERMINAL", "")
+	if got := detectImageProtocol(); got != imageProtocolNone {
+		t.Fatalf("got %q, want none", got)
+	}
+
+	t.Setenv("TERM_PROGRAM", "iTerm.app")
+	if got := detectImageProtocol(); got != imageProtocolITerm {
+		t.Fatalf("got %q, want iterm", got)
+	}
+
+	t.Setenv("KITTY_WINDOW_ID", "1")
+	if got := detectImageProtocol(); got != imageProtocolKitty {
+		t.Fatalf("got %q, want kitty", got)
+	}
+
+	t.Setenv("SMAILER_IMAGES", "none")
+	if got := detectImageProtocol(); got != imageProtocolNone {
+		t.Fatalf("override ignored, got %q", got)
+	}
+}
+
+func TestImageMaxSizeFromEnv(t *testing.T) {
+	t.Setenv("SMAILER_IMAGE_MAX_BYTES", "")
+	if got := imageMaxSizeFromEnv(); got != defaultImageMaxSize {
+		t.Fatalf("got %d", got)
+	}
+	t.Setenv("SMAILER_IMAGE_MAX_BYTES", "1024")
+	if got := imageMaxSizeFromEnv(); got != 1024 {
+		t.Fatalf("got %d", got)
+	}
+}
+
+func TestFitCells_CapsSizePreservingAspect(t *testing.T) {
+	cols, rows := fitCells(1600, 400, 60, 20)
+	if cols != 60 || rows != 7 {
+		t.Fatalf("got %dx%d, want 60x7", cols, rows)
+	}
+	cols, rows = fitCells(160, 1600, 60, 20)
+	if cols != 4 || rows != 20 {
+		t.Fatalf("got %dx%d, want 4x20", cols, rows)
+	}
+}
+
+func TestEncodeTerminalImage_Protocols(t *testing.T) {
+	data := testPNG(t, 32, 48)
+
+	kitty, err := encodeTerminalImage(imageProtocolKitty, data, 60, 20)
+	if err != nil || !strings.HasPrefix(kitty, "\x1b_Ga=T,f=100") || strings.Count(kitty, "\n") != 2 {
+		t.Fatalf("unexpected kitty output %q (%v)", kitty, err)
+	}
+
+	iterm, err := encodeTerminalImage(imageProtocolITerm, data, 60, 20)
+	if err != nil || !strings.Contains(iterm, base64.StdEncoding.EncodeToString(data)) {
+		t.Fatalf("unexpected iterm output (%v)", err)
+	}
+
+	sixel, err := encodeTerminalImage(imageProtocolSixel, data, 60, 20)
+	if err != nil || !strings.HasPrefix(sixel, "\x1bPq\"1;1;32;48") || !strings.Contains(sixel, "\x1b\\") {
+		t.Fatalf("unexpected sixel output %q (%v)", sixel, err)
+	}
+
+	if _, err := encodeTerminalImage(imageProtocolKitty, []byte("not an image"), 60, 20); err == nil {
+		t.Fatal("expected decode error")
+	}
+}
+
+// declaredPNG is a PNG header claiming width by height pixels with no image
+// data behind it.
+func declaredPNG(width, height uint32) []byte {
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], width)
+	binary.BigEndian.PutUint32(ihdr[8:], height)
+	ihdr[12], ihdr[13] = 8, 6 // 8-bit RGBA
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(13))
+	buf.Write(ihdr)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(ihdr))
+	return buf.Bytes()
+}
+
+func TestEncodeTerminalImage_RejectsHugeDimensionsBeforeDecoding(t *testing.T) {
+	data := declaredPNG(50000, 50000)
+	for _, protocol := range []string{imageProtocolKitty, imageProtocolITerm, imageProtocolSixel} {
+		_, err := encodeTerminalImage(protocol, data, 60, 20)
+		if err == nil || !strings.Contains(err.Error(), "50000x50000 pixels, too large") {
+			t.Errorf("%s: err = %v", protocol, err)
+		}
+	}
+}
+
+func TestEncodeTerminalImage_KittyDownscales(t *testing.T) {
+	kitty, err := encodeTerminalImage(imageProtocolKitty, testPNG(t, 1200, 900), 60, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload strings.Builder
+	for _, chunk := range strings.Split(kitty, "\x1b\\")[:strings.Count(kitty, "\x1b\\")] {
+		payload.WriteString(chunk[strings.Index(chunk, ";")+1:])
+	}
+	data, err := base64.StdEncoding.DecodeString(payload.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width > 60*cellPixelWidth || config.Height > 20*cellPixelHeight {
+		t.Fatalf("kitty payload is %dx%d (%v)", config.Width, config.Height, err)
+	}
+}
+
+func TestRenderImages_FallsBackToPlaceholders(t *testing.T) {
+	images := []InlineImage{{Name: "logo.png", ContentID: "logo@acme", ContentType: "image/png", Data: testPNG(t, 8, 8)}}
+
+	m := model{}
+	got := m.renderImages(images)
+	if !strings.Contains(got, "[image: logo.png, image/png") || !strings.Contains(got, "cid:logo@acme") {
+		t.Fatalf("expected placeholder, got %q", got)
+	}
+
+	m.imageProtocol = imageProtocolKitty
+	m.imageMaxSize = 10
+	if got := m.renderImages(images); !strings.Contains(got, "too large to display") {
+		t.Fatalf("expected size cap, got %q", got)
+	}
+}
+
+func TestParseFullEmail_CollectsInlineImages(t *testing.T) {
+	data := base64.StdEncoding.EncodeToString(testPNG(t, 4, 4))
+	raw := "From: a@example.com\r\nSubject: Logo\r\nMIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/related; boundary=RR\r\n\r\n" +
+		"--RR\r\nContent-Type: text/html\r\n\r\n<p>Hi</p><img src=\"cid:logo@acme\">\r\n" +
+		"--RR\r\nContent-Type: image/png\r\nContent-ID: <logo@acme>\r\nContent-Disposition: inline; filename=logo.png\r\nContent-Transfer-Encoding: base64\r\n\r\n" +
+		data + "\r\n--RR--\r\n"
+
+	email, err := parseFullEmail([]byte(raw), "inbound/logo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(email.Images) != 1 || email.Images[0].ContentID != "logo@acme" || email.Images[0].Name != "logo.png" {
+		t.Fatalf("unexpected images %#v", email.Images)
+	}
+}
+
+func TestFindMatches_SkipsImagePayloads(t *testing.T) {
+	content := "\x1b_Ga=T;needle\x1b\\\n\x1b]1337;File=inline=1:needle\a"
+
+	if matches := findMatches(content, "needle"); len(matches) != 0 {
+		t.Fatalf("expected no matches inside image escapes, got %#v", matches)
+	}
+}
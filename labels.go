@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// labelsTag mirrors an email's labels into its object tags when
+// SMAILER_LABEL_TAGS is set, space separated as tag values cannot hold commas.
+const labelsTag = "smailer-labels"
+
+var labelColors = []string{"33", "35", "69", "99", "141", "167", "172", "178", "70", "37"}
+
+// labelStore keeps labels in a local JSON file keyed by "bucket/key", so
+// mail can be categorised without touching the objects.
+type labelStore struct {
+	path   string
+	labels map[string][]string
+}
+
+// labelStorePath is SMAILER_LABELS_FILE, or labels.json in the user's config
+// directory.
+func labelStorePath() string {
+	if path := strings.TrimSpace(os.Getenv("SMAILER_LABELS_FILE")); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil || dir == "" {
+		return filepath.Join(".smailer", "labels.json")
+	}
+	return filepath.Join(dir, "smailer", "labels.json")
+}
+
+func labelSyncFromEnv() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("SMAILER_LABEL_TAGS"))) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
+
+// loadLabelStore reads the store at path. A missing file is an empty store.
+func loadLabelStore(path string) (*labelStore, error) {
+	store := &labelStore{path: path, labels: make(map[string][]string)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return store, err
+	}
+	if err := json.Unmarshal(data, &store.labels); err != nil {
+		return store, fmt.Errorf("%s: %w", path, err)
+	}
+	return store, nil
+}
+
+func labelID(bucket, key string) string {
+	return bucket + "/" + key
+}
+
+func (s *labelStore) get(bucket, key string) []string {
+	if s == nil {
+		return nil
+	}
+	return s.labels[labelID(bucket, key)]
+}
+
+// set replaces the labels of one message and writes the store.
+func (s *labelStore) set(bucket, key string, labels []string) error {
+	if len(labels) == 0 {
+		delete(s.labels, labelID(bucket, key))
+	} else {
+		s.labels[labelID(bucket, key)] = labels
+	}
+	return s.save()
+}
+
+// move re-keys the labels of a message copied to toBucket/toKey and removed
+// from its old key, so they follow it into the archive, trash and back.
+func (s *labelStore) move(fromBucket, fromKey, toBucket, toKey string) error {
+	if s == nil {
+		return nil
+	}
+	labels, ok := s.labels[labelID(fromBucket, fromKey)]
+	if !ok {
+		return nil
+	}
+	delete(s.labels, labelID(fromBucket, fromKey))
+	s.labels[labelID(toBucket, toKey)] = labels
+	return s.save()
+}
+
+// save writes the store through a temporary file so a crash cannot leave it
+// half written.
+func (s *labelStore) save() error {
+	data, err := json.MarshalIndent(s.labels, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), "labels-*.json")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// validLabel limits labels to the characters S3 allows in tag values, less
+// the space used to separate them.
+func validLabel(label string) bool {
+	if label == "" {
+		return false
+	}
+	for _, r := range label {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("+-=._:/@", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// editLabels applies the words of input to current. Words starting with +
+// or - add or remove a label; otherwise the words replace the labels.
+func editLabels(current []string, input string) ([]string, error) {
+	words := strings.Fields(input)
+	incremental := len(words) > 0
+	for _, w := range words {
+		incremental = incremental && (w[0] == '+' || w[0] == '-')
+	}
+	set := make(map[string]bool)
+	if incremental {
+		for _, l := range current {
+			set[l] = true
+		}
+	}
+	for _, w := range words {
+		label := w
+		if incremental {
+			label = w[1:]
+		}
+		if !validLabel(label) {
+			return nil, fmt.Errorf("invalid label %q: use letters, digits and + - = . _ : / @", label)
+		}
+		if incremental && w[0] == '-' {
+			delete(set, label)
+		} else {
+			set[label] = true
+		}
+	}
+	labels := make([]string, 0, len(set))
+	for l := range set {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	return labels, nil
+}
+
+// emailLabels returns the stored labels of e. With tag sync on, labels
+// found in the object's tags are used when the local store has none.
+func (m model) emailLabels(e Email) []string {
+	if labels := m.labels.get(m.bucket, e.Key); len(labels) > 0 {
+		return labels
+	}
+	if m.labelSync && e.Tags[labelsTag] != "" {
+		return strings.Fields(e.Tags[labelsTag])
+	}
+	return nil
+}
+
+// openLabels shows the "Labels:" prompt. A single email's labels are filled
+// in for editing; for several, the prompt starts empty for +add and -remove.
+func (m *model) openLabels() {
+	targets := m.tagTargets()
+	if len(targets) == 0 {
+		return
+	}
+	value := ""
+	if len(targets) == 1 {
+		value = strings.Join(m.emailLabels(targets[0]), " ")
+	}
+	m.labelActive = true
+	m.labelInput.SetValue(value)
+	m.labelInput.CursorEnd()
+	m.labelInput.Focus()
+}
+
+// applyLabels saves the edited labels of every target, mirroring them to the
+// object tags when sync is on.
+func (m *model) applyLabels(input string) tea.Cmd {
+	targets := m.tagTargets()
+	var cmds []tea.Cmd
+	for _, e := range targets {
+		labels, err := editLabels(m.emailLabels(e), input)
+		if err == nil {
+			err = m.labels.set(m.bucket, e.Key, labels)
+		}
+		if err != nil {
+			m.setStatus("Labels not saved: " + err.Error())
+			m.updateTableRows()
+			return tea.Batch(cmds...)
+		}
+		if m.labelSync {
+			cmds = append(cmds, m.updateTags([]Email{e}, map[string]string{labelsTag: strings.Join(labels, " ")}))
+		}
+	}
+	if m.selectedEmail != nil {
+		m.selectedEmail.Labels = m.emailLabels(*m.selectedEmail)
+	}
+	m.updateTableRows()
+	m.setStatus(fmt.Sprintf("Updated labels on %d email(s)", len(targets)))
+	return tea.Batch(cmds...)
+}
+
+func labelColor(label string) lipgloss.Color {
+	h := fnv.New32a()
+	h.Write([]byte(label))
+	return lipgloss.Color(labelColors[h.Sum32()%uint32(len(labelColors))])
+}
+
+// renderLabelChips draws labels as coloured chips for the email header.
+func renderLabelChips(labels []string) string {
+	chips := make([]string, 0, len(labels))
+	for _, l := range labels {
+		chips = append(chips, lipgloss.NewStyle().
+			Foreground(lipgloss.Color("0")).
+			Background(labelColor(l)).
+			Padding(0, 1).
+			Render(l))
+	}
+	return strings.Join(chips, " ")
+}
+
+// labelCell fits coloured label names into a table cell of width columns,
+// ending with +N when some do not fit. Like boldCell it leaves room for the
+// table counting escape sequences as text.
+func labelCell(labels []string, width int) string {
+	colored := lipgloss.NewStyle().Bold(true).Render("x") != "x"
+	var b strings.Builder
+	used := 0
+	for i, l := range labels {
+		on, off := "", ""
+		if colored {
+			on, off = "\x1b[38;5;"+string(labelColor(l))+"m", "\x1b[39m"
+		}
+		sep := 0
+		if i > 0 {
+			sep = 1
+		}
+		cost := sep + len(l) + max(0, len(on)-1) + max(0, len(off)-1)
+		tail := 0
+		if i < len(labels)-1 {
+			tail = len(fmt.Sprintf(" +%d", len(labels)-i-1))
+		}
+		if used+cost+tail > width {
+			if i == 0 {
+				return fmt.Sprintf("+%d", len(labels))
+			}
+			fmt.Fprintf(&b, " +%d", len(labels)-i)
+			return b.String()
+		}
+		if sep > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(on + l + off)
+		used += cost
+	}
+	return b.String()
+}
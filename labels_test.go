@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func labelTestModel(t *testing.T, mock s3API) model {
+	t.Helper()
+	m := bulkTestModel(mock)
+	store, err := loadLabelStore(filepath.Join(t.TempDir(), "labels.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.labels = store
+	return m
+}
+
+func TestLabelStore_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "labels.json")
+	store, err := loadLabelStore(path)
+	if err != nil {
+		t.Fatalf("missing file: %v", err)
+	}
+	if err := store.set("bucket", "inbound/a", []string{"billing", "urgent"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.set("other", "inbound/a", []string{"spam"}); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := loadLabelStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.get("bucket", "inbound/a"); !reflect.DeepEqual(got, []string{"billing", "urgent"}) {
+		t.Errorf("labels = %v", got)
+	}
+	if got := reloaded.get("other", "inbound/a"); !reflect.DeepEqual(got, []string{"spam"}) {
+		t.Errorf("labels in other bucket = %v", got)
+	}
+
+	if err := reloaded.set("bucket", "inbound/a", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reloaded.labels[labelID("bucket", "inbound/a")]; ok {
+		t.Error("clearing the labels should drop the entry")
+	}
+}
+
+func TestEditLabels(t *testing.T) {
+	cases := []struct {
+		current []string
+		input   string
+		want    []string
+	}{
+		{nil, "urgent billing", []string{"billing", "urgent"}},
+		{[]string{"billing", "urgent"}, "support", []string{"support"}},
+		{[]string{"billing", "urgent"}, "+support -urgent", []string{"billing", "support"}},
+		{[]string{"billing"}, "+billing", []string{"billing"}},
+		{[]string{"billing"}, "", []string{}},
+	}
+	for _, c := range cases {
+		got, err := editLabels(c.current, c.input)
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("editLabels(%v, %q) = %v, %v; want %v", c.current, c.input, got, err, c.want)
+		}
+	}
+	if _, err := editLabels(nil, "bad,label"); err == nil {
+		t.Error("commas should be rejected")
+	}
+}
+
+func TestLabelFilter(t *testing.T) {
+	emails := []Email{
+		{Key: "a", Labels: []string{"Billing"}},
+		{Key: "b", Labels: []string{"billing-old", "urgent"}},
+	}
+	cases := map[string]string{
+		"label:billing": "a",
+		"label:urgent":  "b",
+		"label:bill":    "",
+	}
+	for query, want := range cases {
+		terms, text := parseFilterQuery(query)
+		var got []string
+		for _, e := range emails {
+			if emailMatchesFilter(e, terms, text) {
+				got = append(got, e.Key)
+			}
+		}
+		if strings.Join(got, ",") != want {
+			t.Errorf("%s matched %v, want %q", query, got, want)
+		}
+	}
+}
+
+func TestUpdate_LabelPromptEditsMarkedEmails(t *testing.T) {
+	m := labelTestModel(t, &mockS3{})
+	m.marked = map[string]bool{"inbound/a": true, "inbound/b": true}
+
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("L")})
+	m = result.(model)
+	if !m.labelActive || m.labelInput.Value() != "" {
+		t.Fatalf("label prompt = %v %q", m.labelActive, m.labelInput.Value())
+	}
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("+billing")})
+	m = result.(model)
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = result.(model)
+	if msgs := runBatch(cmd); len(msgs) != 0 {
+		t.Errorf("labels without tag sync should not touch S3: %#v", msgs)
+	}
+	if m.statusMessage != "Updated labels on 2 email(s)" {
+		t.Errorf("status = %q", m.statusMessage)
+	}
+	if got := m.labels.get("test-bucket", "inbound/b"); !reflect.DeepEqual(got, []string{"billing"}) {
+		t.Errorf("stored labels = %v", got)
+	}
+	if m.labels.get("test-bucket", "inbound/c") != nil {
+		t.Error("unmarked email should keep no labels")
+	}
+	if row := m.table.Rows()[0]; row[labelsColumn] != "billing" {
+		t.Errorf("labels cell = %q", row[labelsColumn])
+	}
+
+	m.filterQuery = "label:billing"
+	m.updateTableRows()
+	if len(m.visibleEmails) != 2 {
+		t.Errorf("label filter kept %d emails", len(m.visibleEmails))
+	}
+}
+
+func TestLabelPrompt_PrefillsSingleEmailInViewer(t *testing.T) {
+	m := labelTestModel(t, &mockS3{})
+	if err := m.labels.set("test-bucket", "inbound/a", []string{"billing", "urgent"}); err != nil {
+		t.Fatal(err)
+	}
+	m.updateTableRows()
+	e := m.emails[0]
+	m.selectedEmail = &e
+	m.state = viewState
+
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("L")})
+	m = result.(model)
+	if m.labelInput.Value() != "billing urgent" {
+		t.Fatalf("prompt = %q", m.labelInput.Value())
+	}
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m = result.(model)
+	if m.labelActive || m.state != viewState {
+		t.Errorf("esc should close only the prompt: active %v, state %v", m.labelActive, m.state)
+	}
+}
+
+func TestApplyLabels_SyncsObjectTags(t *testing.T) {
+	tagging := &taggingMock{tags: map[string][]types.Tag{
+		"inbound/b": {{Key: aws.String(labelsTag), Value: aws.String("support")}, {Key: aws.String(readTag), Value: aws.String("true")}},
+	}}
+	m := labelTestModel(t, tagging.install(&mockS3{}))
+	m.labelSync = true
+	m.emails[1].Tags = map[string]string{labelsTag: "support", readTag: "true"}
+	m.updateTableRows()
+	if got := m.emails[1].Labels; !reflect.DeepEqual(got, []string{"support"}) {
+		t.Fatalf("labels from tags = %v", got)
+	}
+
+	m.table.SetCursor(1)
+	cmd := m.applyLabels("+billing")
+	for _, msg := range runBatch(cmd) {
+		result, _ := m.Update(msg)
+		m = result.(model)
+	}
+	if got := tagging.get("inbound/b", labelsTag); got != "billing support" {
+		t.Errorf("tag = %q", got)
+	}
+	if tagging.get("inbound/b", readTag) != "true" {
+		t.Error("other tags should be kept")
+	}
+	if got := m.labels.get("test-bucket", "inbound/b"); !reflect.DeepEqual(got, []string{"billing", "support"}) {
+		t.Errorf("stored labels = %v", got)
+	}
+}
+
+func movingMock() *mockS3 {
+	return &mockS3{
+		headObjectFunc: headETag(`"e1"`),
+		copyObjectFunc: func(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+			return copiedETag(`"e1"`), nil
+		},
+		deleteObjectFunc: func(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+			return &s3.DeleteObjectOutput{}, nil
+		},
+	}
+}
+
+func TestLabels_FollowMovedEmail(t *testing.T) {
+	m := labelTestModel(t, movingMock())
+	if err := m.labels.set("test-bucket", "inbound/b", []string{"billing"}); err != nil {
+		t.Fatal(err)
+	}
+	m.table.SetCursor(1)
+
+	for _, msg := range runBatch(m.startBulk(bulkMove, "s3://cold-mail/{yyyy}/")) {
+		result, _ := m.Update(msg)
+		m = result.(model)
+	}
+	if got := m.labels.get("cold-mail", "2025/b"); !reflect.DeepEqual(got, []string{"billing"}) {
+		t.Errorf("labels at the new key = %v", got)
+	}
+	if m.labels.get("test-bucket", "inbound/b") != nil {
+		t.Error("labels should leave the old key")
+	}
+	reloaded, err := loadLabelStore(m.labels.path)
+	if err != nil || !reflect.DeepEqual(reloaded.get("cold-mail", "2025/b"), []string{"billing"}) {
+		t.Errorf("moved labels not saved: %v %v", reloaded.labels, err)
+	}
+}
+
+func TestLabels_FollowTrashAndUndo(t *testing.T) {
+	m := labelTestModel(t, movingMock())
+	m.trashPrefix = "trash/"
+	if err := m.labels.set("test-bucket", "inbound/b", []string{"urgent"}); err != nil {
+		t.Fatal(err)
+	}
+	m.state = confirmDeleteState
+	m.previousState = listState
+	m.selectedEmail = &m.emails[1]
+
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")})
+	m = result.(model)
+	result, _ = m.Update(cmd())
+	m = result.(model)
+	if got := m.labels.get("test-bucket", "trash/inbound/b"); !reflect.DeepEqual(got, []string{"urgent"}) {
+		t.Fatalf("labels in the trash = %v", got)
+	}
+
+	result, cmd = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("u")})
+	m = result.(model)
+	result, _ = m.Update(cmd())
+	m = result.(model)
+	if got := m.labels.get("test-bucket", "inbound/b"); !reflect.DeepEqual(got, []string{"urgent"}) {
+		t.Errorf("restored labels = %v", got)
+	}
+	if e := m.findEmailByKey("inbound/b"); e == nil || !reflect.DeepEqual(e.Labels, []string{"urgent"}) {
+		t.Errorf("restored row = %#v", e)
+	}
+}
+
+func TestLabelCell_TruncatesWithCount(t *testing.T) {
+	labels := []string{"billing", "support", "urgent"}
+	if got := labelCell(labels, 40); got != "billing support urgent" {
+		t.Errorf("wide cell = %q", got)
+	}
+	if got := labelCell(labels, 12); got != "billing +2" {
+		t.Errorf("narrow cell = %q", got)
+	}
+	if got := labelCell(labels, 4); got != "+3" {
+		t.Errorf("tiny cell = %q", got)
+	}
+	if got := labelCell(nil, 10); got != "" {
+		t.Errorf("empty cell = %q", got)
+	}
+}
@@ -9,8 +9,8 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/bubbles/spinner"
+	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/joho/godotenv"
 )
@@ -43,8 +43,21 @@ func main() {
 	}
 	client := s3.NewFromConfig(cfg)
 
-	p := tea.NewProgram(initialModel(client, bucket, prefix))
-	if _, err := p.Run(); err != nil {
+	m := initialModel(client, bucket, prefix)
+	// Large messages are spooled here and the folder is removed on exit, so
+	// without it spool files would be left behind in the temp directory.
+	m.spoolDir, err = os.MkdirTemp("", "smailer-")
+	if err != nil {
+		fmt.Printf("Error creating spool folder: %v\n", err)
+		os.Exit(1)
+	}
+
+	p := tea.NewProgram(m)
+	_, err = p.Run()
+	if m.spoolDir != "" {
+		os.RemoveAll(m.spoolDir)
+	}
+	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -63,6 +76,43 @@ func initialModel(client s3API, bucket, prefix string) model {
 		spinner:  s,
 		loading:  true,
 		saveDir:  defaultSaveDir(),
+
+		imageProtocol: detectImageProtocol(),
+		imageMaxSize:  imageMaxSizeFromEnv(),
+		securityKeys:  loadSecurityKeysFromEnv(),
+		scanPolicy:    scanPolicyFromEnv(),
+		downloads:     make(chan downloadProgressMsg, 1),
+
+		maxMessageBytes:    byteLimitFromEnv("SMAILER_MAX_MESSAGE_BYTES", defaultMaxMessageBytes),
+		maxAttachmentBytes: byteLimitFromEnv("SMAILER_MAX_ATTACHMENT_BYTES", defaultMaxAttachmentBytes),
+		bodyBudget:         byteLimitFromEnv("SMAILER_BODY_CACHE_BYTES", defaultBodyCacheBytes),
+		prefetchCount:      prefetchCountFromEnv(),
+		bulkSlots:          make(chan struct{}, bulkConcurrency),
+		tagAccess:          &tagAccess{},
+		internalDomains:    internalDomainsFromEnv(),
+		trashPrefix:        trashPrefixFromEnv(),
+		archiveTemplate:    archiveTemplateFromEnv(),
+		userName:           assigneeFromEnv(),
+		labelSync:          labelSyncFromEnv(),
+		smtp:               smtpConfigFromEnv(),
+	}
+
+	scanner, err := scannerFromEnv()
+	m.scanner = scanner
+	if err != nil {
+		m.setStatus("Attachment scanning misconfigured: " + err.Error())
+	}
+
+	resolver, err := dkimResolverFromEnv()
+	m.dkimResolver = resolver
+	if err != nil {
+		m.setStatus("Could not read " + err.Error())
+	}
+
+	labels, err := loadLabelStore(labelStorePath())
+	m.labels = labels
+	if err != nil {
+		m.setStatus("Could not read labels: " + err.Error())
 	}
 
 	if bucket == "" {
@@ -19,6 +19,12 @@ type s3API interface {
 	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
 	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
 	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+	GetObjectTagging(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error)
+	PutObjectTagging(ctx context.Context, params *s3.PutObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.PutObjectTaggingOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	ListObjectVersions(ctx context.Context, params *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error)
 }
 
 type state int
@@ -28,6 +34,11 @@ const (
 	listState
 	viewState
 	confirmDeleteState
+	confirmBulkState
+	purgeState
+	trashState
+	versionsState
+	composeState
 )
 
 type Email struct {
@@ -40,45 +51,127 @@ type Email struct {
 	Key     string
 	Size    int64
 
-	RawLoaded   bool
-	BodyLoaded  bool
+	FromAddrs   []Address
+	ToAddrs     []Address
+	CcAddrs     []Address
+	ReplyTo     []Address
+	DeliveredTo []Address
+
+	RawLoaded    bool
+	BodyLoaded   bool
 	SummaryError bool
-	Raw         []byte
-	Attachments []Attachment
+	Raw          []byte
+	Spool        string
+	Attachments  []Attachment
+	Calendar     *Calendar
+	Images       []InlineImage
+	Diagnostics  []string
+	Verdicts     Verdicts
+	Receipt      *SESReceipt
+	Kind         string
+	Report       *DeliveryReport
+	DKIMResults  []DKIMResult
+	Security     *Security
+	Warnings     []string
+	ScanResults  []ScanResult
+	Tags         map[string]string
+	Labels       []string
+	MessageID    string
+	References   string
+
+	// links are the anchors of the HTML body, kept for the phishing checks.
+	links []linkRef
 }
 
 type Attachment struct {
-	Name string
-	Data []byte
+	Name        string
+	Data        []byte
+	ContentType string
+	Sniffed     string
+	Size        int64
+	Omitted     bool
+	Source      *partSource
 }
 
 type model struct {
-	table           table.Model
-	viewport        viewport.Model
-	spinner         spinner.Model
-	filterInput     textinput.Model
-	bucketsList     list.Model
-	emails          []Email
-	visibleEmails   []Email
-	state           state
-	previousState   state
-	selectedEmail   *Email
-	selectedIndex   int
-	s3Client        s3API
-	bucket          string
-	prefix          string
-	continuation    *string
-	hasMore         bool
-	ready           bool
-	width           int
-	height          int
-	err             error
-	loading         bool
-	glamourRenderer *glamour.TermRenderer
-	statusMessage   string
-	filterActive    bool
-	filterQuery     string
-	saveDir         string
+	table              table.Model
+	viewport           viewport.Model
+	spinner            spinner.Model
+	filterInput        textinput.Model
+	searchInput        textinput.Model
+	moveInput          textinput.Model
+	purgeInput         textinput.Model
+	assignInput        textinput.Model
+	labelInput         textinput.Model
+	bucketsList        list.Model
+	trashList          list.Model
+	versionsList       list.Model
+	emails             []Email
+	visibleEmails      []Email
+	state              state
+	previousState      state
+	selectedEmail      *Email
+	selectedIndex      int
+	s3Client           s3API
+	bucket             string
+	prefix             string
+	continuation       *string
+	hasMore            bool
+	ready              bool
+	width              int
+	height             int
+	err                error
+	loading            bool
+	glamourRenderer    *glamour.TermRenderer
+	statusMessage      string
+	filterActive       bool
+	filterQuery        string
+	saveDir            string
+	showQuoted         bool
+	searchActive       bool
+	searchQuery        string
+	searchMatches      []searchMatch
+	searchIndex        int
+	viewerContent      string
+	imageProtocol      string
+	imageMaxSize       int
+	showDiagnostics    bool
+	dkimResolver       keyResolver
+	securityKeys       *securityKeys
+	scanner            attachmentScanner
+	scanPolicy         string
+	maxMessageBytes    int64
+	maxAttachmentBytes int64
+	spoolDir           string
+	bodyBudget         int64
+	recentBodies       []string
+	prefetchCount      int
+	prefetching        map[string]bool
+	marked             map[string]bool
+	moveActive         bool
+	suggestParent      string
+	archiveTemplate    string
+	assignActive       bool
+	userName           string
+	labels             *labelStore
+	labelSync          bool
+	labelActive        bool
+	smtp               smtpConfig
+	draft              *draft
+	pendingBulk        bulkJob
+	bulk               bulkJob
+	bulkSlots          chan struct{}
+	tagAccess          *tagAccess
+	internalDomains    []string
+	purgeActive        bool
+	purge              purgeRun
+	trashPrefix        string
+	trashConfirm       bool
+	undo               *undoAction
+	undoSeq            int
+	openVersion        *versionItem
+	versionEmail       *Email
+	downloads          chan downloadProgressMsg
 }
 
 type emailsLoadedMsg struct {
@@ -97,7 +190,10 @@ type errorMsg struct {
 }
 
 type emailDeletedMsg struct {
-	err error
+	key           string
+	trashKey      string
+	markerVersion string
+	err           error
 }
 
 type emailLoadedMsg struct {
@@ -110,8 +206,10 @@ type emailSavedMsg struct {
 }
 
 type attachmentsSavedMsg struct {
-	paths []string
-	err   error
+	key     string
+	paths   []string
+	results []ScanResult
+	err     error
 }
 
 type clearStatusMsg struct{}
@@ -129,16 +227,10 @@ func (m model) filteredEmails() []Email {
 		return append([]Email(nil), m.emails...)
 	}
 
-	query := strings.ToLower(strings.TrimSpace(m.filterQuery))
+	terms, text := parseFilterQuery(strings.TrimSpace(m.filterQuery))
 	filtered := make([]Email, 0, len(m.emails))
 	for _, email := range m.emails {
-		haystack := strings.ToLower(strings.Join([]string{
-			email.From,
-			email.To,
-			email.Subject,
-			email.Key,
-		}, " "))
-		if strings.Contains(haystack, query) {
+		if emailMatchesFilter(email, terms, text) {
 			filtered = append(filtered, email)
 		}
 	}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const defaultArchiveTemplate = "archive/{yyyy}/{mm}/"
+
+type prefixesLoadedMsg struct {
+	parent   string
+	prefixes []string
+}
+
+// archiveTemplateFromEnv reads SMAILER_ARCHIVE_TEMPLATE, the destination "a"
+// archives to. {yyyy}, {mm} and {dd} are filled from each message's date.
+func archiveTemplateFromEnv() string {
+	if raw := strings.TrimSpace(os.Getenv("SMAILER_ARCHIVE_TEMPLATE")); raw != "" {
+		return raw
+	}
+	return defaultArchiveTemplate
+}
+
+// expandTemplate fills the date placeholders of a move destination.
+func expandTemplate(template string, date time.Time) string {
+	return strings.NewReplacer(
+		"{yyyy}", date.Format("2006"),
+		"{mm}", date.Format("01"),
+		"{dd}", date.Format("02"),
+	).Replace(template)
+}
+
+// moveTarget splits a destination into bucket and prefix. Destinations are
+// prefixes in the current bucket unless written as s3://bucket/prefix.
+func (m model) moveTarget(target string) (bucket, prefix string) {
+	if rest, ok := strings.CutPrefix(target, "s3://"); ok {
+		bucket, prefix, _ = strings.Cut(rest, "/")
+		return bucket, prefix
+	}
+	return m.bucket, target
+}
+
+// moveDestination keeps the key's name below the current prefix and places
+// it under target, expanded for the email's date.
+func (m model) moveDestination(e Email, target string) (bucket, key string) {
+	date := e.Date
+	if date.IsZero() {
+		date = e.S3Date
+	}
+	bucket, prefix := m.moveTarget(expandTemplate(target, date))
+	name := strings.TrimPrefix(e.Key, m.prefix)
+	if name == e.Key {
+		name = path.Base(e.Key)
+	}
+	prefix = strings.TrimRight(prefix, "/")
+	if prefix == "" {
+		return bucket, name
+	}
+	return bucket, prefix + "/" + name
+}
+
+// moveObject moves key to dest within the current bucket.
+func (m model) moveObject(ctx context.Context, key, dest string) error {
+	return m.moveObjectTo(ctx, key, m.bucket, dest)
+}
+
+// moveObjectTo copies key to dest in bucket, checks the copy against the
+// original and only then deletes the original.
+func (m model) moveObjectTo(ctx context.Context, key, bucket, dest string) error {
+	if bucket == m.bucket && key == dest {
+		return fmt.Errorf("already in %s", path.Dir(dest)+"/")
+	}
+	source, err := m.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(m.bucket),
+		Key:          aws.String(key),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return err
+	}
+	copied, err := m.s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String(bucket),
+		Key:               aws.String(dest),
+		CopySource:        aws.String(copySource(m.bucket, key)),
+		CopySourceIfMatch: source.ETag,
+	})
+	if err != nil {
+		return err
+	}
+	if err := m.verifyCopy(ctx, source, copied, bucket, dest); err != nil {
+		// Leaving the copy behind would add another duplicate on every retry.
+		if _, delErr := m.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(dest),
+		}); delErr != nil {
+			return fmt.Errorf("%w; copy left at s3://%s/%s: %v", err, bucket, dest, delErr)
+		}
+		return err
+	}
+	_, err = m.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(m.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// verifyCopy checks that the copy's ETag matches the original. The ETag is
+// only an MD5 of the content for single-part uploads without SSE-KMS or
+// SSE-C, and the destination bucket's default encryption can change that for
+// the copy, so unless both sides qualify the copy's size and any checksum
+// both objects carry are compared instead; CopySourceIfMatch already pinned
+// the source.
+func (m model) verifyCopy(ctx context.Context, source *s3.HeadObjectOutput, copied *s3.CopyObjectOutput, bucket, dest string) error {
+	want := aws.ToString(source.ETag)
+	if etagIsMD5(source) && copyKeepsMD5(copied) {
+		var got string
+		if copied != nil && copied.CopyObjectResult != nil {
+			got = aws.ToString(copied.CopyObjectResult.ETag)
+		}
+		if got != want {
+			return fmt.Errorf("copy has ETag %s, expected %s; original kept", got, want)
+		}
+		return nil
+	}
+	head, err := m.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(dest),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return fmt.Errorf("could not check copy: %w; original kept", err)
+	}
+	if aws.ToInt64(head.ContentLength) != aws.ToInt64(source.ContentLength) {
+		return fmt.Errorf("copy is %d bytes, expected %d; original kept", aws.ToInt64(head.ContentLength), aws.ToInt64(source.ContentLength))
+	}
+	if name, got, want, ok := mismatchedChecksum(source, head); ok {
+		return fmt.Errorf("copy has %s %s, expected %s; original kept", name, got, want)
+	}
+	return nil
+}
+
+// etagIsMD5 reports whether the object's ETag is the MD5 of its content and
+// so survives a copy unchanged.
+func etagIsMD5(head *s3.HeadObjectOutput) bool {
+	if strings.Contains(aws.ToString(head.ETag), "-") || aws.ToString(head.SSECustomerAlgorithm) != "" {
+		return false
+	}
+	switch head.ServerSideEncryption {
+	case "", types.ServerSideEncryptionAes256:
+		return true
+	}
+	return false
+}
+
+// copyKeepsMD5 reports whether the copy was stored with encryption that keeps
+// its ETag an MD5 of the content.
+func copyKeepsMD5(copied *s3.CopyObjectOutput) bool {
+	if copied == nil || aws.ToString(copied.SSECustomerAlgorithm) != "" {
+		return false
+	}
+	switch copied.ServerSideEncryption {
+	case "", types.ServerSideEncryptionAes256:
+		return true
+	}
+	return false
+}
+
+// mismatchedChecksum compares the full-object checksums present on both
+// objects. Composite checksums of multipart uploads ("…-N") are skipped.
+func mismatchedChecksum(source, dest *s3.HeadObjectOutput) (name, got, want string, mismatch bool) {
+	for _, c := range []struct {
+		name      string
+		want, got *string
+	}{
+		{"CRC64NVME", source.ChecksumCRC64NVME, dest.ChecksumCRC64NVME},
+		{"CRC32C", source.ChecksumCRC32C, dest.ChecksumCRC32C},
+		{"CRC32", source.ChecksumCRC32, dest.ChecksumCRC32},
+		{"SHA256", source.ChecksumSHA256, dest.ChecksumSHA256},
+		{"SHA1", source.ChecksumSHA1, dest.ChecksumSHA1},
+	} {
+		want, got := aws.ToString(c.want), aws.ToString(c.got)
+		if want == "" || got == "" || strings.Contains(want, "-") || strings.Contains(got, "-") {
+			continue
+		}
+		if want != got {
+			return c.name, got, want, true
+		}
+	}
+	return "", "", "", false
+}
+
+// copySource is the URL-encoded "bucket/key" form CopyObject expects.
+func copySource(bucket, key string) string {
+	return bucket + "/" + strings.ReplaceAll(url.PathEscape(key), "%2F", "/")
+}
+
+// prefixParent is the folder part of a destination being typed.
+func prefixParent(value string) string {
+	return value[:strings.LastIndex(value, "/")+1]
+}
+
+// suggestPrefixes lists the folders under the one being typed so the move
+// prompt can complete them with tab. Other buckets are not listed.
+func (m *model) suggestPrefixes() tea.Cmd {
+	value := m.moveInput.Value()
+	if strings.HasPrefix(value, "s3://") {
+		return nil
+	}
+	parent := prefixParent(value)
+	if parent == m.suggestParent {
+		return nil
+	}
+	m.suggestParent = parent
+	return m.loadPrefixes(parent)
+}
+
+func (m model) loadPrefixes(parent string) tea.Cmd {
+	return func() tea.Msg {
+		page, err := m.s3Client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+			Bucket:    aws.String(m.bucket),
+			Prefix:    aws.String(parent),
+			Delimiter: aws.String("/"),
+		})
+		if err != nil {
+			return prefixesLoadedMsg{parent: parent}
+		}
+		prefixes := make([]string, 0, len(page.CommonPrefixes))
+		for _, p := range page.CommonPrefixes {
+			if prefix := aws.ToString(p.Prefix); !m.inTrash(prefix) {
+				prefixes = append(prefixes, prefix)
+			}
+		}
+		return prefixesLoadedMsg{parent: parent, prefixes: prefixes}
+	}
+}
+
+func (m *model) storePrefixes(msg prefixesLoadedMsg) {
+	if msg.parent != m.suggestParent {
+		return
+	}
+	m.moveInput.SetSuggestions(append(msg.prefixes, m.archiveTemplate))
+}
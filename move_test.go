@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestMoveDestination_ExpandsTemplateAndBucket(t *testing.T) {
+	m := newMockTestModel(&mockS3{})
+	e := Email{Key: "inbound/sub/abc", Date: time.Date(2026, 10, 3, 0, 0, 0, 0, time.UTC)}
+
+	cases := []struct{ target, bucket, key string }{
+		{"archive/{yyyy}/{mm}/", "test-bucket", "archive/2026/10/sub/abc"},
+		{"archive/{yyyy}-{mm}-{dd}", "test-bucket", "archive/2026-10-03/sub/abc"},
+		{"s3://cold-mail/{yyyy}/", "cold-mail", "2026/sub/abc"},
+		{"s3://cold-mail", "cold-mail", "sub/abc"},
+	}
+	for _, tc := range cases {
+		bucket, key := m.moveDestination(e, tc.target)
+		if bucket != tc.bucket || key != tc.key {
+			t.Errorf("%s: got %s %s, want %s %s", tc.target, bucket, key, tc.bucket, tc.key)
+		}
+	}
+
+	undated := Email{Key: "inbound/x", S3Date: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)}
+	if _, key := m.moveDestination(undated, defaultArchiveTemplate); key != "archive/2024/02/x" {
+		t.Errorf("undated email should use the S3 date: %s", key)
+	}
+}
+
+func TestMoveObjectTo_DeletesOnlyVerifiedCopies(t *testing.T) {
+	var copied *s3.CopyObjectInput
+	var deleted []string
+	copyETag := `"abc"`
+	mock := &mockS3{
+		headObjectFunc: headETag(`"abc"`),
+		copyObjectFunc: func(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+			copied = params
+			return copiedETag(copyETag), nil
+		},
+		deleteObjectFunc: func(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+			deleted = append(deleted, aws.ToString(params.Key))
+			return &s3.DeleteObjectOutput{}, nil
+		},
+	}
+	m := newMockTestModel(mock)
+
+	if err := m.moveObjectTo(context.Background(), "inbound/a", "cold-mail", "2026/a"); err != nil {
+		t.Fatal(err)
+	}
+	if aws.ToString(copied.Bucket) != "cold-mail" || aws.ToString(copied.CopySourceIfMatch) != `"abc"` || aws.ToString(copied.CopySource) != "test-bucket/inbound/a" {
+		t.Fatalf("copy = %#v", copied)
+	}
+	if len(deleted) != 1 || deleted[0] != "inbound/a" {
+		t.Fatalf("deleted = %v", deleted)
+	}
+
+	copyETag = `"other"`
+	err := m.moveObjectTo(context.Background(), "inbound/b", "test-bucket", "archive/b")
+	if err == nil || !strings.Contains(err.Error(), "original kept") {
+		t.Fatalf("err = %v", err)
+	}
+	if len(deleted) != 2 || deleted[1] != "archive/b" {
+		t.Errorf("a mismatched copy must be removed and the original kept: %v", deleted)
+	}
+}
+
+func TestVerifyCopy_MultipartComparesSize(t *testing.T) {
+	size := int64(7)
+	mock := &mockS3{
+		headObjectFunc: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			return &s3.HeadObjectOutput{ETag: aws.String(`"new"`), ContentLength: aws.Int64(size)}, nil
+		},
+	}
+	m := newMockTestModel(mock)
+	source := &s3.HeadObjectOutput{ETag: aws.String(`"abc-3"`), ContentLength: aws.Int64(7)}
+
+	if err := m.verifyCopy(context.Background(), source, copiedETag(`"new"`), "test-bucket", "archive/a"); err != nil {
+		t.Fatalf("same size should verify: %v", err)
+	}
+	size = 6
+	if err := m.verifyCopy(context.Background(), source, copiedETag(`"new"`), "test-bucket", "archive/a"); err == nil {
+		t.Fatal("short copy should fail verification")
+	}
+}
+
+func TestMoveObjectTo_SSEKMSComparesSizeAndChecksum(t *testing.T) {
+	var deleted []string
+	destChecksum := "AAAAAAAAAAA="
+	mock := &mockS3{
+		headObjectFunc: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			if params.ChecksumMode != types.ChecksumModeEnabled {
+				t.Errorf("HEAD %s without checksum mode", aws.ToString(params.Key))
+			}
+			head := &s3.HeadObjectOutput{
+				ETag:                 aws.String(`"kms-source"`),
+				ContentLength:        aws.Int64(7),
+				ServerSideEncryption: types.ServerSideEncryptionAwsKms,
+				ChecksumCRC64NVME:    aws.String("AAAAAAAAAAA="),
+			}
+			if aws.ToString(params.Key) != "inbound/a" {
+				head.ETag = aws.String(`"kms-copy"`)
+				head.ChecksumCRC64NVME = aws.String(destChecksum)
+			}
+			return head, nil
+		},
+		copyObjectFunc: func(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+			if aws.ToString(params.CopySourceIfMatch) != `"kms-source"` {
+				t.Errorf("CopySourceIfMatch = %q", aws.ToString(params.CopySourceIfMatch))
+			}
+			return copiedETag(`"kms-copy"`), nil
+		},
+		deleteObjectFunc: func(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+			deleted = append(deleted, aws.ToString(params.Key))
+			return &s3.DeleteObjectOutput{}, nil
+		},
+	}
+	m := newMockTestModel(mock)
+
+	if err := m.moveObject(context.Background(), "inbound/a", "archive/a"); err != nil {
+		t.Fatalf("SSE-KMS copy with a different ETag should verify: %v", err)
+	}
+	if len(deleted) != 1 || deleted[0] != "inbound/a" {
+		t.Fatalf("deleted = %v", deleted)
+	}
+
+	destChecksum = "BBBBBBBBBBB="
+	if err := m.moveObject(context.Background(), "inbound/a", "archive/a"); err == nil || !strings.Contains(err.Error(), "CRC64NVME") {
+		t.Fatalf("checksum mismatch should keep the original: %v", err)
+	}
+	if len(deleted) != 2 || deleted[1] != "archive/a" {
+		t.Errorf("checksum mismatch should remove the copy and keep the original: %v", deleted)
+	}
+}
+
+func TestMoveObjectTo_KMSDestinationComparesSizeAndRemovesBadCopy(t *testing.T) {
+	var deleted []string
+	destSize := int64(7)
+	mock := &mockS3{
+		headObjectFunc: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			if aws.ToString(params.Bucket) == "cold-mail" {
+				return &s3.HeadObjectOutput{ETag: aws.String(`"kms-copy"`), ContentLength: aws.Int64(destSize)}, nil
+			}
+			return &s3.HeadObjectOutput{ETag: aws.String(`"plain"`), ContentLength: aws.Int64(7)}, nil
+		},
+		copyObjectFunc: func(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+			out := copiedETag(`"kms-copy"`)
+			out.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+			return out, nil
+		},
+		deleteObjectFunc: func(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+			deleted = append(deleted, aws.ToString(params.Bucket)+"/"+aws.ToString(params.Key))
+			return &s3.DeleteObjectOutput{}, nil
+		},
+	}
+	m := newMockTestModel(mock)
+
+	if err := m.moveObjectTo(context.Background(), "inbound/a", "cold-mail", "2025/a"); err != nil {
+		t.Fatalf("a copy encrypted by the destination's default key should verify by size: %v", err)
+	}
+	if len(deleted) != 1 || deleted[0] != "test-bucket/inbound/a" {
+		t.Fatalf("deleted = %v", deleted)
+	}
+
+	destSize = 6
+	if err := m.moveObjectTo(context.Background(), "inbound/a", "cold-mail", "2025/a"); err == nil || !strings.Contains(err.Error(), "original kept") {
+		t.Fatalf("err = %v", err)
+	}
+	if len(deleted) != 2 || deleted[1] != "cold-mail/2025/a" {
+		t.Errorf("the short copy should be removed, not the original: %v", deleted)
+	}
+}
+
+func TestArchiveKey_MovesEmailUnderCursorByDate(t *testing.T) {
+	var copies []string
+	mock := &mockS3{
+		headObjectFunc: headETag(`"e1"`),
+		copyObjectFunc: func(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+			copies = append(copies, aws.ToString(params.Key))
+			return copiedETag(`"e1"`), nil
+		},
+		deleteObjectFunc: func(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+			return &s3.DeleteObjectOutput{}, nil
+		},
+	}
+	m := bulkTestModel(mock)
+	m.archiveTemplate = defaultArchiveTemplate
+	m.table.SetCursor(1)
+
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("a")})
+	m = result.(model)
+	if want := "Move this email to archive/{yyyy}/{mm}/ (e.g. archive/2025/01/b)?"; m.state != confirmBulkState || !strings.Contains(m.bulkConfirmText(), want) {
+		t.Fatalf("state = %v, text = %q", m.state, m.bulkConfirmText())
+	}
+
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")})
+	m = result.(model)
+	for _, msg := range runBatch(cmd) {
+		result, _ = m.Update(msg)
+		m = result.(model)
+	}
+	if len(copies) != 1 || copies[0] != "archive/2025/01/b" || m.findEmailByKey("inbound/b") != nil {
+		t.Fatalf("copies = %v, emails = %d", copies, len(m.emails))
+	}
+}
+
+func TestMovePrompt_SuggestsPrefixes(t *testing.T) {
+	var listed []string
+	mock := &mockS3{
+		listObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			listed = append(listed, aws.ToString(params.Prefix))
+			if aws.ToString(params.Delimiter) != "/" {
+				t.Errorf("delimiter = %q", aws.ToString(params.Delimiter))
+			}
+			return &s3.ListObjectsV2Output{CommonPrefixes: []types.CommonPrefix{
+				{Prefix: aws.String("archive/")}, {Prefix: aws.String("inbound/")}, {Prefix: aws.String("trash/")},
+			}}, nil
+		},
+	}
+	m := bulkTestModel(mock)
+	m.trashPrefix = "trash/"
+	m.archiveTemplate = defaultArchiveTemplate
+
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("m")})
+	m = result.(model)
+	if !m.moveActive || cmd == nil {
+		t.Fatal("m should open the move prompt for the email under the cursor")
+	}
+	result, _ = m.Update(cmd())
+	m = result.(model)
+	if len(listed) != 1 || listed[0] != "inbound/" {
+		t.Fatalf("listed = %v", listed)
+	}
+
+	m.moveInput.SetValue("")
+	m.suggestParent = "x"
+	result, _ = m.Update(m.suggestPrefixes()())
+	m = result.(model)
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("ar")})
+	m = result.(model)
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyTab})
+	m = result.(model)
+	if got := m.moveInput.Value(); got != "archive/" {
+		t.Errorf("tab should complete a listed prefix: %q", got)
+	}
+	if strings.Contains(strings.Join(m.moveInput.AvailableSuggestions(), ","), "trash/") {
+		t.Error("the trash should not be offered as a destination")
+	}
+}
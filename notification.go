@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SESReceipt is the receipt metadata from an SES "Received" notification,
+// kept when the archived object is the notification JSON rather than MIME.
+type SESReceipt struct {
+	NotificationType     string
+	MessageID            string
+	Source               string
+	Timestamp            time.Time
+	Recipients           []string
+	Action               string
+	ProcessingTimeMillis int
+	Verdicts             Verdicts
+	ContentMissing       bool
+}
+
+type snsEnvelope struct {
+	Type    string `json:"Type"`
+	Message string `json:"Message"`
+}
+
+type sesVerdict struct {
+	Status string `json:"status"`
+}
+
+type sesRecipient struct {
+	EmailAddress   string `json:"emailAddress"`
+	Action         string `json:"action"`
+	Status         string `json:"status"`
+	DiagnosticCode string `json:"diagnosticCode"`
+}
+
+// notificationInfo is what an SES notification tells us beyond the message
+// itself: receipt metadata for inbound mail, or the report for bounces and
+// complaints.
+type notificationInfo struct {
+	receipt *SESReceipt
+	report  *DeliveryReport
+}
+
+type sesNotification struct {
+	NotificationType string `json:"notificationType"`
+	EventType        string `json:"eventType"`
+	Mail             struct {
+		Timestamp   time.Time `json:"timestamp"`
+		Source      string    `json:"source"`
+		MessageID   string    `json:"messageId"`
+		Destination []string  `json:"destination"`
+		Headers     []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"headers"`
+		CommonHeaders struct {
+			From      []string `json:"from"`
+			To        []string `json:"to"`
+			Subject   string   `json:"subject"`
+			Date      string   `json:"date"`
+			MessageID string   `json:"messageId"`
+		} `json:"commonHeaders"`
+	} `json:"mail"`
+	Receipt struct {
+		Recipients           []string   `json:"recipients"`
+		ProcessingTimeMillis int        `json:"processingTimeMillis"`
+		SpamVerdict          sesVerdict `json:"spamVerdict"`
+		VirusVerdict         sesVerdict `json:"virusVerdict"`
+		SPFVerdict           sesVerdict `json:"spfVerdict"`
+		DKIMVerdict          sesVerdict `json:"dkimVerdict"`
+		DMARCVerdict         sesVerdict `json:"dmarcVerdict"`
+		Action               struct {
+			Type     string `json:"type"`
+			Encoding string `json:"encoding"`
+		} `json:"action"`
+	} `json:"receipt"`
+	Bounce struct {
+		BounceType        string         `json:"bounceType"`
+		BounceSubType     string         `json:"bounceSubType"`
+		BouncedRecipients []sesRecipient `json:"bouncedRecipients"`
+		Timestamp         time.Time      `json:"timestamp"`
+		ReportingMTA      string         `json:"reportingMTA"`
+	} `json:"bounce"`
+	Complaint struct {
+		ComplainedRecipients  []sesRecipient `json:"complainedRecipients"`
+		ComplaintFeedbackType string         `json:"complaintFeedbackType"`
+		UserAgent             string         `json:"userAgent"`
+		Timestamp             time.Time      `json:"timestamp"`
+	} `json:"complaint"`
+	Content string `json:"content"`
+}
+
+func looksLikeJSON(prefix []byte) bool {
+	trimmed := bytes.TrimLeft(prefix, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
+
+// decodeSESNotification accepts either a bare SES notification or one still
+// wrapped in the SNS envelope that delivered it.
+func decodeSESNotification(data []byte) (*sesNotification, error) {
+	var envelope snsEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+	if envelope.Type == "Notification" && envelope.Message != "" {
+		data = []byte(envelope.Message)
+	}
+	var n sesNotification
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, err
+	}
+	if n.NotificationType == "" {
+		n.NotificationType = n.EventType
+	}
+	if n.NotificationType == "" {
+		return nil, fmt.Errorf("not an SES notification")
+	}
+	return &n, nil
+}
+
+// unwrapMessage returns the MIME message held in an S3 object. Raw MIME is
+// returned unchanged; SES notification JSON yields its embedded message, or a
+// synthesised one for bounces and complaints, plus the notification details.
+func unwrapMessage(data []byte) ([]byte, notificationInfo, error) {
+	if !looksLikeJSON(data) {
+		return data, notificationInfo{}, nil
+	}
+	n, err := decodeSESNotification(data)
+	if err != nil {
+		return nil, notificationInfo{}, err
+	}
+
+	switch n.NotificationType {
+	case "Received":
+		receipt := n.receipt()
+		raw, err := n.message()
+		if err != nil {
+			return nil, notificationInfo{}, err
+		}
+		if raw == nil {
+			receipt.ContentMissing = true
+			raw = n.synthesiseMessage()
+		}
+		return raw, notificationInfo{receipt: receipt}, nil
+	case "Bounce", "Complaint":
+		report := n.deliveryReport()
+		return n.synthesiseReportMessage(report), notificationInfo{report: report}, nil
+	}
+	return nil, notificationInfo{}, fmt.Errorf("unsupported SES notification type %q", n.NotificationType)
+}
+
+func (n *sesNotification) receipt() *SESReceipt {
+	return &SESReceipt{
+		NotificationType:     n.NotificationType,
+		MessageID:            n.Mail.MessageID,
+		Source:               n.Mail.Source,
+		Timestamp:            n.Mail.Timestamp,
+		Recipients:           n.Receipt.Recipients,
+		Action:               n.Receipt.Action.Type,
+		ProcessingTimeMillis: n.Receipt.ProcessingTimeMillis,
+		Verdicts: Verdicts{
+			Spam:  normaliseVerdict(n.Receipt.SpamVerdict.Status),
+			Virus: normaliseVerdict(n.Receipt.VirusVerdict.Status),
+			SPF:   normaliseVerdict(n.Receipt.SPFVerdict.Status),
+			DKIM:  normaliseVerdict(n.Receipt.DKIMVerdict.Status),
+			DMARC: normaliseVerdict(n.Receipt.DMARCVerdict.Status),
+		},
+	}
+}
+
+// message decodes the content field. SNS actions publish either UTF-8 or
+// base64, and older archives do not always record which.
+func (n *sesNotification) message() ([]byte, error) {
+	if n.Content == "" {
+		return nil, nil
+	}
+	if strings.EqualFold(n.Receipt.Action.Encoding, "BASE64") {
+		raw, err := base64.StdEncoding.DecodeString(n.Content)
+		if err != nil {
+			return nil, fmt.Errorf("decode notification content: %w", err)
+		}
+		return raw, nil
+	}
+	if n.Receipt.Action.Encoding == "" && !strings.Contains(n.Content, ":") {
+		if raw, err := base64.StdEncoding.DecodeString(n.Content); err == nil {
+			return raw, nil
+		}
+	}
+	return []byte(n.Content), nil
+}
+
+// synthesiseMessage rebuilds a header-only message for notifications whose
+// content was omitted, which SES does for messages over 150 KB.
+func (n *sesNotification) synthesiseMessage() []byte {
+	var buf bytes.Buffer
+	if len(n.Mail.Headers) > 0 {
+		for _, h := range n.Mail.Headers {
+			fmt.Fprintf(&buf, "%s: %s\r\n", h.Name, h.Value)
+		}
+	} else {
+		common := n.Mail.CommonHeaders
+		fmt.Fprintf(&buf, "From: %s\r\n", strings.Join(common.From, ", "))
+		fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(common.To, ", "))
+		fmt.Fprintf(&buf, "Subject: %s\r\n", common.Subject)
+		if common.Date != "" {
+			fmt.Fprintf(&buf, "Date: %s\r\n", common.Date)
+		}
+		if common.MessageID != "" {
+			fmt.Fprintf(&buf, "Message-ID: %s\r\n", common.MessageID)
+		}
+	}
+	buf.WriteString("\r\n(The message content was not included in the SES notification.)\r\n")
+	return buf.Bytes()
+}
+
+func (n *sesNotification) deliveryReport() *DeliveryReport {
+	report := &DeliveryReport{}
+	var recipients []sesRecipient
+	if n.NotificationType == "Bounce" {
+		report.Kind = kindBounce
+		report.Type = strings.Trim(n.Bounce.BounceType+"/"+n.Bounce.BounceSubType, "/")
+		report.ReportingMTA = stripTypePrefix(n.Bounce.ReportingMTA)
+		recipients = n.Bounce.BouncedRecipients
+	} else {
+		report.Kind = kindComplaint
+		report.Type = n.Complaint.ComplaintFeedbackType
+		report.ReportingMTA = n.Complaint.UserAgent
+		recipients = n.Complaint.ComplainedRecipients
+	}
+	for _, r := range recipients {
+		action := strings.ToLower(r.Action)
+		if report.Kind == kindComplaint && action == "" {
+			action = kindComplaint
+		}
+		report.Recipients = append(report.Recipients, ReportRecipient{
+			Address:        r.EmailAddress,
+			Action:         action,
+			Status:         r.Status,
+			DiagnosticCode: stripTypePrefix(r.DiagnosticCode),
+		})
+	}
+
+	common := n.Mail.CommonHeaders
+	add := func(name, value string) {
+		if value != "" {
+			report.OriginalHeaders = append(report.OriginalHeaders, HeaderField{Name: name, Value: value})
+		}
+	}
+	add("From", strings.Join(common.From, ", "))
+	add("To", strings.Join(common.To, ", "))
+	add("Subject", common.Subject)
+	add("Date", common.Date)
+	add("Message-Id", common.MessageID)
+	return report
+}
+
+// synthesiseReportMessage builds a message for bounce and complaint
+// notifications, which carry no MIME content of their own.
+func (n *sesNotification) synthesiseReportMessage(report *DeliveryReport) []byte {
+	timestamp := n.Bounce.Timestamp
+	if report.Kind == kindComplaint {
+		timestamp = n.Complaint.Timestamp
+	}
+	if timestamp.IsZero() {
+		timestamp = n.Mail.Timestamp
+	}
+	label := "Delivery Status Notification"
+	if report.Kind == kindComplaint {
+		label = "Complaint Notification"
+	}
+	if report.Type != "" {
+		label += " (" + report.Type + ")"
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("From: Amazon SES <MAILER-DAEMON@amazonses.com>\r\n")
+	if n.Mail.Source != "" {
+		fmt.Fprintf(&buf, "To: %s\r\n", n.Mail.Source)
+	}
+	fmt.Fprintf(&buf, "Subject: %s: %s\r\n", label, n.Mail.CommonHeaders.Subject)
+	if !timestamp.IsZero() {
+		fmt.Fprintf(&buf, "Date: %s\r\n", timestamp.Format(time.RFC1123Z))
+	}
+	buf.WriteString("\r\n")
+	for _, r := range report.Recipients {
+		fmt.Fprintf(&buf, "%s %s %s %s\r\n", r.Address, r.Action, r.Status, r.DiagnosticCode)
+	}
+	return buf.Bytes()
+}
+
+func (info notificationInfo) apply(e *Email) {
+	applyReceipt(e, info.receipt)
+	if info.report != nil {
+		e.Report = info.report
+		e.Kind = info.report.Kind
+	}
+}
+
+// applyReceipt fills verdicts the message headers did not carry from the
+// notification's receipt section.
+func applyReceipt(e *Email, receipt *SESReceipt) {
+	if receipt == nil {
+		return
+	}
+	e.Receipt = receipt
+	fill := func(dst *string, src string) {
+		if *dst == "" {
+			*dst = src
+		}
+	}
+	fill(&e.Verdicts.Spam, receipt.Verdicts.Spam)
+	fill(&e.Verdicts.Virus, receipt.Verdicts.Virus)
+	fill(&e.Verdicts.SPF, receipt.Verdicts.SPF)
+	fill(&e.Verdicts.DKIM, receipt.Verdicts.DKIM)
+	fill(&e.Verdicts.DMARC, receipt.Verdicts.DMARC)
+	if e.Date.IsZero() {
+		e.Date = receipt.Timestamp
+	}
+}
+
+func renderReceipt(receipt *SESReceipt) string {
+	if receipt == nil {
+		return ""
+	}
+	line := "SES:     notification"
+	if receipt.Action != "" {
+		line += " via " + receipt.Action
+	}
+	if len(receipt.Recipients) > 0 {
+		line += " for " + strings.Join(receipt.Recipients, ", ")
+	}
+	if receipt.ContentMissing {
+		line += " (headers only, content omitted)"
+	}
+	return line
+}
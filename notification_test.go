@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const notificationMIME = "From: Acme <a@acme.example>\r\nTo: inbox@example.com\r\nSubject: From SNS\r\nDate: Sat, 15 Mar 2025 10:30:00 +0000\r\n\r\nHello from SNS"
+
+func sesNotificationJSON(t *testing.T, content, encoding string) string {
+	t.Helper()
+	doc := map[string]any{
+		"notificationType": "Received",
+		"mail": map[string]any{
+			"timestamp": "2025-03-15T10:30:01.000Z",
+			"source":    "a@acme.example",
+			"messageId": "abc123",
+			"commonHeaders": map[string]any{
+				"from":    []string{"Acme <a@acme.example>"},
+				"to":      []string{"inbox@example.com"},
+				"subject": "From SNS",
+			},
+		},
+		"receipt": map[string]any{
+			"recipients":   []string{"inbox@example.com"},
+			"spamVerdict":  map[string]string{"status": "PASS"},
+			"virusVerdict": map[string]string{"status": "PASS"},
+			"spfVerdict":   map[string]string{"status": "PASS"},
+			"dkimVerdict":  map[string]string{"status": "GRAY"},
+			"dmarcVerdict": map[string]string{"status": "FAIL"},
+			"action":       map[string]string{"type": "SNS", "encoding": encoding},
+		},
+	}
+	if content != "" {
+		doc["content"] = content
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return string(data)
+}
+
+func wrapInSNS(t *testing.T, message string) string {
+	t.Helper()
+	data, err := json.Marshal(map[string]string{"Type": "Notification", "MessageId": "sns-1", "Message": message})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return string(data)
+}
+
+func TestUnwrapMessage_PassesThroughMIME(t *testing.T) {
+	raw, info, err := unwrapMessage([]byte(notificationMIME))
+	if err != nil || info.receipt != nil || string(raw) != notificationMIME {
+		t.Fatalf("unexpected result %q %#v %v", raw, info, err)
+	}
+}
+
+func TestUnwrapMessage_DecodesBase64InsideSNSEnvelope(t *testing.T) {
+	content := base64.StdEncoding.EncodeToString([]byte(notificationMIME))
+	doc := wrapInSNS(t, sesNotificationJSON(t, content, "BASE64"))
+
+	raw, info, err := unwrapMessage([]byte(doc))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(raw) != notificationMIME {
+		t.Fatalf("raw = %q", raw)
+	}
+	receipt := info.receipt
+	if receipt.Action != "SNS" || receipt.Verdicts.DMARC != "fail" || receipt.Recipients[0] != "inbox@example.com" {
+		t.Fatalf("receipt = %#v", receipt)
+	}
+}
+
+func TestUnwrapMessage_SynthesisesWhenContentMissing(t *testing.T) {
+	raw, info, err := unwrapMessage([]byte(sesNotificationJSON(t, "", "UTF8")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if receipt := info.receipt; !receipt.ContentMissing || !strings.Contains(string(raw), "Subject: From SNS") {
+		t.Fatalf("raw = %q receipt = %#v", raw, receipt)
+	}
+}
+
+func TestUnwrapMessage_RejectsOtherJSON(t *testing.T) {
+	if _, _, err := unwrapMessage([]byte(`{"hello":"world"}`)); err == nil {
+		t.Fatal("expected error for non-SES JSON")
+	}
+}
+
+func TestFetchEmailSummary_ReadsSESNotification(t *testing.T) {
+	doc := sesNotificationJSON(t, notificationMIME, "UTF8")
+	mock := &mockS3{
+		getObjectFunc: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(doc))}, nil
+		},
+	}
+	m := model{s3Client: mock, bucket: "b"}
+
+	email, err := m.fetchEmailSummary(context.Background(), types.Object{Key: aws.String("k")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if email.Subject != "From SNS" || email.fromDisplay() != "Acme" {
+		t.Fatalf("email = %#v", email)
+	}
+	if email.Receipt == nil || email.Verdicts.DMARC != "fail" || email.Verdicts.DKIM != "gray" {
+		t.Fatalf("receipt verdicts not applied: %#v", email.Verdicts)
+	}
+}
+
+func TestFetchAndParseEmail_ReadsSESNotification(t *testing.T) {
+	doc := wrapInSNS(t, sesNotificationJSON(t, base64.StdEncoding.EncodeToString([]byte(notificationMIME)), "BASE64"))
+	mock := &mockS3{
+		getObjectFunc: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(doc))}, nil
+		},
+	}
+	m := model{s3Client: mock, bucket: "b"}
+
+	email, err := m.fetchAndParseEmail(context.Background(), "k")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(email.Body, "Hello from SNS") || string(email.Raw) != notificationMIME {
+		t.Fatalf("email = %#v", email)
+	}
+	if !strings.Contains(renderReceipt(email.Receipt), "via SNS for inbox@example.com") {
+		t.Fatalf("receipt line = %q", renderReceipt(email.Receipt))
+	}
+}
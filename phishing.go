@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/charmbracelet/lipgloss"
+	"golang.org/x/net/html"
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+var warningBannerStyle = lipgloss.NewStyle().
+	Bold(true).
+	Foreground(lipgloss.Color("230")).
+	Background(lipgloss.Color("124")).
+	Padding(0, 1)
+
+var executableExtensions = map[string]bool{
+	".exe": true, ".scr": true, ".bat": true, ".cmd": true, ".com": true, ".pif": true,
+	".js": true, ".jse": true, ".vbs": true, ".vbe": true, ".wsf": true, ".wsh": true,
+	".hta": true, ".msi": true, ".jar": true, ".ps1": true, ".lnk": true, ".cpl": true,
+	".iso": true, ".img": true, ".reg": true, ".dll": true,
+}
+
+var macroExtensions = map[string]bool{
+	".docm": true, ".dotm": true, ".xlsm": true, ".xltm": true, ".xlam": true,
+	".pptm": true, ".potm": true, ".ppam": true, ".ppsm": true, ".sldm": true,
+}
+
+// homoglyphs maps characters commonly swapped in lookalike domains to the
+// letters they imitate.
+var homoglyphs = strings.NewReplacer("rn", "m", "vv", "w", "0", "o", "1", "l", "3", "e", "5", "s", "@", "a")
+
+type linkRef struct {
+	text string
+	href string
+}
+
+// internalDomainsFromEnv reads SMAILER_INTERNAL_DOMAINS, a comma-separated
+// list of domains that belong to the organisation.
+func internalDomainsFromEnv() []string {
+	var domains []string
+	for _, d := range strings.Split(os.Getenv("SMAILER_INTERNAL_DOMAINS"), ",") {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// detectPhishing runs the local heuristics over a parsed message. The
+// configured internal domains are joined by those in Delivered-To and
+// X-Original-To, which the receiving server adds. To and Cc are written by
+// the sender, so trusting them would let a lookalike domain vouch for itself.
+func detectPhishing(e *Email, configured []string) []string {
+	internal := append([]string(nil), configured...)
+	for _, a := range e.DeliveredTo {
+		if d := addressDomain(a.Address); d != "" {
+			internal = append(internal, registrableDomain(d))
+		}
+	}
+
+	links := e.links
+	var warnings []string
+	warnings = append(warnings, linkMismatchWarnings(links)...)
+	warnings = append(warnings, impersonationWarnings(e.FromAddrs, internal)...)
+	warnings = append(warnings, lookalikeWarnings(e, links, internal)...)
+	warnings = append(warnings, attachmentWarnings(e.Attachments)...)
+	if w := replyToWarning(e.FromAddrs, e.ReplyTo); w != "" {
+		warnings = append(warnings, w)
+	}
+	return warnings
+}
+
+func addressDomain(address string) string {
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		return strings.ToLower(strings.TrimSuffix(address[i+1:], ">"))
+	}
+	return ""
+}
+
+func registrableDomain(host string) string {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if d, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return d
+	}
+	return host
+}
+
+func extractLinks(body string) []linkRef {
+	if body == "" {
+		return nil
+	}
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	var links []linkRef
+	var text func(n *html.Node, b *strings.Builder)
+	text = func(n *html.Node, b *strings.Builder) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			text(c, b)
+		}
+	}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "a" {
+			for _, attr := range n.Attr {
+				if attr.Key == "href" {
+					var b strings.Builder
+					text(n, &b)
+					links = append(links, linkRef{text: strings.TrimSpace(b.String()), href: strings.TrimSpace(attr.Val)})
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return links
+}
+
+func linkHost(raw string) string {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// looksLikeDomain reports whether link text reads as a URL or host name, so
+// that only links which claim a destination are compared with their href.
+func looksLikeDomain(text string) bool {
+	if strings.ContainsAny(text, " \t\n") || !strings.Contains(text, ".") {
+		return false
+	}
+	host := linkHost(text)
+	if host == "" {
+		return false
+	}
+	_, icann := publicsuffix.PublicSuffix(host)
+	_, err := publicsuffix.EffectiveTLDPlusOne(host)
+	return icann && err == nil
+}
+
+func linkMismatchWarnings(links []linkRef) []string {
+	var warnings []string
+	seen := make(map[string]bool)
+	for _, link := range links {
+		if !strings.HasPrefix(strings.ToLower(link.href), "http") || !looksLikeDomain(link.text) {
+			continue
+		}
+		shown, actual := registrableDomain(linkHost(link.text)), registrableDomain(linkHost(link.href))
+		if shown == "" || actual == "" || shown == actual {
+			continue
+		}
+		warning := fmt.Sprintf("Link text shows %s but points to %s", shown, actual)
+		if !seen[warning] {
+			seen[warning] = true
+			warnings = append(warnings, warning)
+		}
+	}
+	return warnings
+}
+
+func isInternal(domain string, internal []string) bool {
+	domain = registrableDomain(domain)
+	for _, d := range internal {
+		if registrableDomain(d) == domain {
+			return true
+		}
+	}
+	return false
+}
+
+func impersonationWarnings(from []Address, internal []string) []string {
+	var warnings []string
+	for _, a := range from {
+		domain := addressDomain(a.Address)
+		if a.Name == "" || domain == "" || isInternal(domain, internal) {
+			continue
+		}
+		for _, field := range strings.FieldsFunc(a.Name, func(r rune) bool {
+			return unicode.IsSpace(r) || strings.ContainsRune(`<>()"',;`, r)
+		}) {
+			named := addressDomain(field)
+			if named == "" || !strings.Contains(field, "@") {
+				continue
+			}
+			if isInternal(named, internal) {
+				warnings = append(warnings, fmt.Sprintf("Display name shows internal address %s but mail is from %s", field, a.Address))
+			} else if registrableDomain(named) != registrableDomain(domain) {
+				warnings = append(warnings, fmt.Sprintf("Display name shows %s but mail is from %s", field, a.Address))
+			}
+		}
+	}
+	return warnings
+}
+
+func lookalikeWarnings(e *Email, links []linkRef, internal []string) []string {
+	hosts := make(map[string]string)
+	for _, link := range links {
+		if h := linkHost(link.href); h != "" && strings.HasPrefix(strings.ToLower(link.href), "http") {
+			hosts[h] = "link"
+		}
+	}
+	for _, a := range append(append([]Address(nil), e.FromAddrs...), e.ReplyTo...) {
+		if d := addressDomain(a.Address); d != "" {
+			hosts[d] = "sender"
+		}
+	}
+	var warnings []string
+	for host, role := range hosts {
+		if w := lookalikeWarning(host, role, internal); w != "" {
+			warnings = append(warnings, w)
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
+
+func lookalikeWarning(host, role string, internal []string) string {
+	if strings.Contains(host, "xn--") {
+		unicodeHost, err := idna.ToUnicode(host)
+		if err == nil && unicodeHost != host {
+			return fmt.Sprintf("Punycode %s domain %s displays as %s", role, host, unicodeHost)
+		}
+		return fmt.Sprintf("Punycode %s domain %s", role, host)
+	}
+	for _, r := range host {
+		if r > unicode.MaxASCII {
+			return fmt.Sprintf("Non-ASCII %s domain %s", role, host)
+		}
+	}
+	if isInternal(host, internal) {
+		return ""
+	}
+	candidate := registrableDomain(host)
+	for _, d := range internal {
+		target := registrableDomain(d)
+		if candidate == target {
+			continue
+		}
+		if homoglyphs.Replace(candidate) == homoglyphs.Replace(target) || editDistance(candidate, target) == 1 {
+			return fmt.Sprintf("%s domain %s looks like internal domain %s", capitalise(role), host, target)
+		}
+	}
+	return ""
+}
+
+func capitalise(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func attachmentWarnings(attachments []Attachment) []string {
+	var warnings []string
+	for _, a := range attachments {
+		if w := typeMismatchWarning(a); w != "" {
+			warnings = append(warnings, w)
+			continue
+		}
+		name := strings.ToLower(strings.TrimSpace(a.Name))
+		ext := filepath.Ext(name)
+		switch {
+		case executableExtensions[ext]:
+			if inner := filepath.Ext(strings.TrimSuffix(name, ext)); inner != "" && !executableExtensions[inner] {
+				warnings = append(warnings, fmt.Sprintf("Attachment %s hides an executable behind a %s extension", a.Name, inner))
+			} else {
+				warnings = append(warnings, fmt.Sprintf("Executable attachment %s", a.Name))
+			}
+		case macroExtensions[ext]:
+			warnings = append(warnings, fmt.Sprintf("Macro-enabled attachment %s", a.Name))
+		}
+	}
+	return warnings
+}
+
+func replyToWarning(from, replyTo []Address) string {
+	if len(from) == 0 || len(replyTo) == 0 {
+		return ""
+	}
+	fromDomain := registrableDomain(addressDomain(from[0].Address))
+	for _, r := range replyTo {
+		if d := addressDomain(r.Address); d != "" && registrableDomain(d) != fromDomain {
+			return fmt.Sprintf("Reply-To %s differs from sender %s", r.Address, from[0].Address)
+		}
+	}
+	return ""
+}
+
+func renderWarnings(warnings []string, width int) string {
+	if len(warnings) == 0 {
+		return ""
+	}
+	lines := make([]string, 0, len(warnings)+1)
+	lines = append(lines, fmt.Sprintf("⚠ Suspicious message: %d warning(s)", len(warnings)))
+	for _, w := range warnings {
+		lines = append(lines, "  - "+w)
+	}
+	style := warningBannerStyle
+	if width > 0 {
+		style = style.Width(width)
+	}
+	return style.Render(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func hasWarning(warnings []string, substr string) bool {
+	for _, w := range warnings {
+		if strings.Contains(w, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDetectPhishing_LinkMismatch(t *testing.T) {
+	html := `<p>Sign in at <a href="https://login.evil-site.net/acme">https://www.acme.com/login</a>
+		or <a href="https://www.acme.com/help">our help pages</a>
+		or <a href="https://docs.acme.com/">acme.com</a></p>`
+	warnings := detectPhishing(&Email{links: extractLinks(html)}, nil)
+	if !hasWarning(warnings, "Link text shows acme.com but points to evil-site.net") {
+		t.Fatalf("warnings = %#v", warnings)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("only the mismatched link should warn: %#v", warnings)
+	}
+}
+
+func TestDetectPhishing_DisplayNameImpersonation(t *testing.T) {
+	e := &Email{
+		FromAddrs:   []Address{{Name: "ceo@acme.com", Address: "ceo.acme@freemail.net"}},
+		DeliveredTo: []Address{{Address: "finance@acme.com"}},
+	}
+	warnings := detectPhishing(e, nil)
+	if !hasWarning(warnings, "Display name shows internal address ceo@acme.com") {
+		t.Fatalf("warnings = %#v", warnings)
+	}
+
+	e.FromAddrs = []Address{{Name: "Jane Doe", Address: "jane@acme.com"}}
+	if warnings := detectPhishing(e, nil); len(warnings) != 0 {
+		t.Fatalf("internal sender should not warn: %#v", warnings)
+	}
+}
+
+func TestDetectPhishing_SenderWrittenRecipientsAreNotInternal(t *testing.T) {
+	e := &Email{
+		FromAddrs: []Address{{Name: "ceo@acrne.com", Address: "ceo@acrne.com"}},
+		ToAddrs:   []Address{{Address: "finance@acrne.com"}},
+		CcAddrs:   []Address{{Address: "audit@acrne.com"}},
+	}
+	warnings := detectPhishing(e, []string{"acme.com"})
+	if !hasWarning(warnings, "looks like internal domain acme.com") {
+		t.Fatalf("a lookalike in To must not vouch for itself: %#v", warnings)
+	}
+}
+
+func TestDetectPhishing_LookalikeDomains(t *testing.T) {
+	tests := map[string]string{
+		"billing@acrne.com":          "looks like internal domain acme.com",
+		"billing@acme-corp.com":      "",
+		"billing@acmee.com":          "looks like internal domain acme.com",
+		"billing@xn--acm-7ma.com":    "Punycode sender domain",
+		"billing@partner.example.co": "",
+	}
+	for from, want := range tests {
+		warnings := detectPhishing(&Email{FromAddrs: []Address{{Address: from}}}, []string{"acme.com"})
+		if want == "" {
+			if len(warnings) != 0 {
+				t.Errorf("%s: unexpected warnings %#v", from, warnings)
+			}
+			continue
+		}
+		if !hasWarning(warnings, want) {
+			t.Errorf("%s: warnings = %#v, want %q", from, warnings, want)
+		}
+	}
+}
+
+func TestDetectPhishing_Attachments(t *testing.T) {
+	e := &Email{Attachments: []Attachment{
+		{Name: "invoice.pdf.exe"},
+		{Name: "setup.msi"},
+		{Name: "budget.xlsm"},
+		{Name: "report.pdf"},
+	}}
+	warnings := detectPhishing(e, nil)
+	for _, want := range []string{
+		"invoice.pdf.exe hides an executable behind a .pdf extension",
+		"Executable attachment setup.msi",
+		"Macro-enabled attachment budget.xlsm",
+	} {
+		if !hasWarning(warnings, want) {
+			t.Errorf("missing %q in %#v", want, warnings)
+		}
+	}
+	if len(warnings) != 3 {
+		t.Fatalf("warnings = %#v", warnings)
+	}
+}
+
+func TestDetectPhishing_ReplyTo(t *testing.T) {
+	e := &Email{
+		FromAddrs: []Address{{Address: "billing@supplier.com"}},
+		ReplyTo:   []Address{{Address: "billing.supplier@gmail.com"}},
+	}
+	if !hasWarning(detectPhishing(e, nil), "Reply-To billing.supplier@gmail.com differs from sender billing@supplier.com") {
+		t.Fatal("expected a Reply-To warning")
+	}
+	e.ReplyTo = []Address{{Address: "accounts@mail.supplier.com"}}
+	if warnings := detectPhishing(e, nil); len(warnings) != 0 {
+		t.Fatalf("same-domain Reply-To should not warn: %#v", warnings)
+	}
+}
+
+func TestParseFullEmail_SetsWarnings(t *testing.T) {
+	raw := "From: \"it-support@acme.com\" <helpdesk@acme-support.net>\r\n" +
+		"Delivered-To: staff@acme.com\r\n" +
+		"To: staff@acme.com\r\n" +
+		"Reply-To: reset@other.net\r\n" +
+		"Subject: Password expiry\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<a href=\"http://acme-support.net/reset\">https://acme.com/reset</a>\r\n"
+	m := newMockTestModel(&mockS3{})
+	email, err := m.parseEmailBytes([]byte(raw), "phish.eml")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(email.Warnings) != 3 {
+		t.Fatalf("warnings = %#v", email.Warnings)
+	}
+	if banner := renderWarnings(email.Warnings, 0); !strings.Contains(banner, "Suspicious message: 3 warning(s)") {
+		t.Fatalf("banner = %q", banner)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const defaultPrefetchCount = 2
+
+type emailPrefetchedMsg struct {
+	key   string
+	email *Email
+	err   error
+}
+
+// prefetchCountFromEnv reads SMAILER_PREFETCH, the number of messages either
+// side of the open one to load in the background. 0 disables prefetching.
+func prefetchCountFromEnv() int {
+	if raw := strings.TrimSpace(os.Getenv("SMAILER_PREFETCH")); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return defaultPrefetchCount
+}
+
+// openEmail shows the visible email at index in the viewer, loading its body
+// unless it is cached or already being prefetched, and starts prefetching
+// its neighbours.
+func (m model) openEmail(index int) (model, tea.Cmd) {
+	m.selectedIndex = index
+	m.table.SetCursor(index)
+	selected := m.visibleEmails[index]
+	m.selectedEmail = &selected
+	m.state = viewState
+	m.showQuoted = false
+	m.searchQuery = ""
+	m.searchInput.SetValue("")
+	m.setViewerContent("Loading email...")
+
+	prefetch := tea.Batch(m.prefetchAround(index), m.markOpenedRead(selected))
+	if selected.BodyLoaded {
+		m.touchBody(selected.Key)
+		m.setViewerContent(m.getEmailBody(m.selectedEmail))
+		return m, prefetch
+	}
+	if m.prefetching[selected.Key] {
+		return m, tea.Batch(prefetch, m.spinner.Tick)
+	}
+	return m, tea.Batch(m.loadSelectedEmail(), prefetch, m.spinner.Tick)
+}
+
+// stepEmail opens the message delta rows away from the open one. Moving past
+// the last row loads the next page when there is one.
+func (m model) stepEmail(delta int) (model, tea.Cmd) {
+	index := m.selectedIndex
+	if m.selectedEmail != nil {
+		for i, e := range m.visibleEmails {
+			if e.Key == m.selectedEmail.Key {
+				index = i
+				break
+			}
+		}
+	}
+	index += delta
+	switch {
+	case index < 0:
+		m.setStatus("Already at the first message")
+		return m, nil
+	case index >= len(m.visibleEmails):
+		if m.hasMore && !m.loading {
+			m.loading = true
+			m.setStatus("Loading more emails...")
+			return m, m.loadEmails()
+		}
+		m.setStatus("No more messages")
+		return m, nil
+	}
+	return m.openEmail(index)
+}
+
+// prefetchAround loads the bodies of up to prefetchCount visible emails on
+// each side of index, nearest first, skipping ones already loaded or in
+// flight.
+func (m *model) prefetchAround(index int) tea.Cmd {
+	var cmds []tea.Cmd
+	for distance := 1; distance <= m.prefetchCount; distance++ {
+		for _, i := range []int{index + distance, index - distance} {
+			if i < 0 || i >= len(m.visibleEmails) {
+				continue
+			}
+			key := m.visibleEmails[i].Key
+			if m.visibleEmails[i].BodyLoaded || m.prefetching[key] {
+				continue
+			}
+			if m.prefetching == nil {
+				m.prefetching = make(map[string]bool)
+			}
+			m.prefetching[key] = true
+			cmds = append(cmds, m.prefetchEmail(key))
+		}
+	}
+	return tea.Batch(cmds...)
+}
+
+func (m model) prefetchEmail(key string) tea.Cmd {
+	return func() tea.Msg {
+		email, err := m.fetchAndParseEmail(context.Background(), key)
+		return emailPrefetchedMsg{key: key, email: email, err: err}
+	}
+}
+
+// storePrefetched merges a prefetched body into the list. If the user is
+// already waiting on this message it is shown straight away; otherwise
+// failures are dropped quietly, as the message is fetched again when opened.
+func (m *model) storePrefetched(msg emailPrefetchedMsg) {
+	delete(m.prefetching, msg.key)
+	waiting := m.state == viewState && m.selectedEmail != nil && m.selectedEmail.Key == msg.key
+	if msg.err != nil {
+		if waiting && !m.selectedEmail.BodyLoaded {
+			m.setStatus("Error: " + msg.err.Error())
+		}
+		return
+	}
+	if msg.email == nil {
+		return
+	}
+	if current := m.findEmailByKey(msg.key); current == nil || current.BodyLoaded {
+		removeSpool(*msg.email)
+		return
+	}
+	m.replaceEmail(*msg.email)
+	if waiting {
+		m.selectedEmail = m.findEmailByKey(msg.key)
+		m.touchBody(msg.key)
+		m.setViewerContent(m.getEmailBody(m.selectedEmail))
+		return
+	}
+	m.evictBodies()
+}
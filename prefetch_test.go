@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func prefetchTestModel() model {
+	m := newReadyTestModel()
+	m.prefetchCount = 2
+	m.emails = []Email{
+		{Key: "a", Subject: "A"},
+		{Key: "b", Subject: "B"},
+		{Key: "c", Subject: "C", Body: "loaded body", BodyLoaded: true},
+		{Key: "d", Subject: "D"},
+		{Key: "e", Subject: "E"},
+		{Key: "f", Subject: "F"},
+	}
+	m.updateTableRows()
+	return m
+}
+
+func TestPrefetchAround_LoadsNeighboursOnce(t *testing.T) {
+	m := prefetchTestModel()
+
+	if cmd := m.prefetchAround(2); cmd == nil {
+		t.Fatal("expected prefetch commands")
+	}
+	for _, key := range []string{"a", "b", "d", "e"} {
+		if !m.prefetching[key] {
+			t.Errorf("%s should be prefetching", key)
+		}
+	}
+	if m.prefetching["c"] || m.prefetching["f"] {
+		t.Errorf("prefetching = %v", m.prefetching)
+	}
+	if cmd := m.prefetchAround(2); cmd != nil {
+		t.Error("messages already in flight should not be fetched twice")
+	}
+}
+
+func TestPrefetchAround_DisabledWithZeroCount(t *testing.T) {
+	m := prefetchTestModel()
+	m.prefetchCount = 0
+	if cmd := m.prefetchAround(2); cmd != nil {
+		t.Error("expected no prefetch")
+	}
+}
+
+func TestUpdate_NextAndPreviousMoveBetweenEmails(t *testing.T) {
+	m := prefetchTestModel()
+	m.prefetchCount = 0
+	m.table.SetCursor(2)
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = result.(model)
+
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'n'}})
+	m = result.(model)
+	if m.selectedEmail.Key != "d" || m.table.Cursor() != 3 {
+		t.Fatalf("n opened %q at row %d", m.selectedEmail.Key, m.table.Cursor())
+	}
+	if cmd == nil || m.viewerContent != "Loading email..." {
+		t.Errorf("expected d to be loaded, viewer = %q", m.viewerContent)
+	}
+
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'p'}})
+	m = result.(model)
+	if m.selectedEmail.Key != "c" || !strings.Contains(m.viewerContent, "loaded body") {
+		t.Fatalf("p opened %q, viewer = %q", m.selectedEmail.Key, m.viewerContent)
+	}
+}
+
+func TestUpdate_PreviousStopsAtFirstEmail(t *testing.T) {
+	m := prefetchTestModel()
+	m.prefetchCount = 0
+	m.state = viewState
+	m.selectedEmail = &m.visibleEmails[0]
+
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'p'}})
+	rm := result.(model)
+	if cmd != nil || rm.selectedEmail.Key != "a" || rm.statusMessage != "Already at the first message" {
+		t.Fatalf("selected = %q, status = %q", rm.selectedEmail.Key, rm.statusMessage)
+	}
+}
+
+func TestUpdate_NextAtEndLoadsMoreEmails(t *testing.T) {
+	m := prefetchTestModel()
+	m.state = viewState
+	m.selectedEmail = &m.visibleEmails[5]
+	m.hasMore = true
+
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'n'}})
+	rm := result.(model)
+	if cmd == nil || !rm.loading {
+		t.Fatal("expected the next page to load")
+	}
+
+	rm.loading = false
+	rm.hasMore = false
+	result, _ = rm.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'n'}})
+	if got := result.(model).statusMessage; got != "No more messages" {
+		t.Errorf("status = %q", got)
+	}
+}
+
+func TestUpdate_NextFollowsSearchMatchesWhileSearching(t *testing.T) {
+	m := prefetchTestModel()
+	m.state = viewState
+	m.selectedEmail = &m.visibleEmails[2]
+	m.setViewerContent("match one\nmatch two")
+	m.applySearch("match")
+
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'n'}})
+	rm := result.(model)
+	if rm.selectedEmail.Key != "c" || rm.searchIndex != 1 {
+		t.Errorf("selected = %q, search index = %d", rm.selectedEmail.Key, rm.searchIndex)
+	}
+}
+
+func TestStorePrefetched_MergesBodyAndShowsWaitingEmail(t *testing.T) {
+	m := prefetchTestModel()
+	m.prefetchCount = 0
+	m.table.SetCursor(3)
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = result.(model)
+	m.prefetching = map[string]bool{"d": true, "e": true}
+
+	result, _ = m.Update(emailPrefetchedMsg{key: "e", email: &Email{Key: "e", Body: "body of e", BodyLoaded: true}})
+	m = result.(model)
+	if !m.findEmailByKey("e").BodyLoaded || m.prefetching["e"] {
+		t.Error("prefetched body should be stored")
+	}
+	if m.selectedEmail.Key != "d" || m.viewerContent != "Loading email..." {
+		t.Errorf("viewer should still wait for d: %q", m.viewerContent)
+	}
+
+	result, _ = m.Update(emailPrefetchedMsg{key: "d", email: &Email{Key: "d", Body: "body of d", BodyLoaded: true}})
+	m = result.(model)
+	if !strings.Contains(m.viewerContent, "body of d") {
+		t.Errorf("viewer = %q", m.viewerContent)
+	}
+}
+
+func TestStorePrefetched_ReportsErrorOnlyForWaitingEmail(t *testing.T) {
+	m := prefetchTestModel()
+	m.state = viewState
+	m.selectedEmail = &m.visibleEmails[0]
+
+	m.storePrefetched(emailPrefetchedMsg{key: "b", err: errors.New("boom")})
+	if m.statusMessage != "" {
+		t.Errorf("status = %q", m.statusMessage)
+	}
+	m.storePrefetched(emailPrefetchedMsg{key: "a", err: errors.New("boom")})
+	if m.statusMessage != "Error: boom" {
+		t.Errorf("status = %q", m.statusMessage)
+	}
+}
+
+func TestUpdate_LateLoadDoesNotReplaceOpenEmail(t *testing.T) {
+	m := prefetchTestModel()
+	m.emails[1].Body, m.emails[1].BodyLoaded = "prefetched b", true
+	m.updateTableRows()
+	m.state = viewState
+	m.selectedEmail = m.findEmailByKey("b")
+	m.setViewerContent(m.getEmailBody(m.selectedEmail))
+
+	result, _ := m.Update(emailLoadedMsg{email: Email{Key: "a", Subject: "A", Body: "late a", BodyLoaded: true}})
+	m = result.(model)
+	if m.selectedEmail == nil || m.selectedEmail.Key != "b" || !strings.Contains(m.viewerContent, "prefetched b") {
+		t.Fatalf("late load of a took over the viewer: %v %q", m.selectedEmail, m.viewerContent)
+	}
+	if a := m.findEmailByKey("a"); a == nil || a.Body != "late a" {
+		t.Errorf("the late load should still be kept: %#v", a)
+	}
+
+	m.state = versionsState
+	m.viewerContent = "versions"
+	result, _ = m.Update(emailLoadedMsg{email: Email{Key: "b", Subject: "B", Body: "reloaded b", BodyLoaded: true}})
+	m = result.(model)
+	if m.viewerContent != "versions" {
+		t.Errorf("a load outside the viewer replaced %q", m.viewerContent)
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// deleteBatchSize is the most keys S3 accepts in one DeleteObjects call.
+const deleteBatchSize = 1000
+
+// s3OnlyFilterFields can be judged from the object listing alone, so a purge
+// using only these does not need to read every message.
+var s3OnlyFilterFields = map[string]bool{"older": true, "newer": true, "key": true}
+
+// tagFilterFields are judged from object tags and labels rather than the
+// message, so a purge using them reads tags instead of downloading mail.
+var tagFilterFields = map[string]bool{"is": true, "assignee": true, "label": true}
+
+// errPurgeTagsUnreadable refuses a purge whose state qualifiers cannot be
+// judged: without tags every email would look read, unflagged and
+// unassigned, and is:read would delete unread mail too.
+var errPurgeTagsUnreadable = errors.New("object tags could not be read, so is: and assignee: cannot be used in a purge")
+
+// purgeRun is a purge from its dry-run preview through to the final report.
+type purgeRun struct {
+	query    string
+	scanning bool
+	scanned  int
+	matches  []Email
+	running  bool
+	finished bool
+	batches  int
+	done     int
+	deleted  int
+	failures []purgeFailure
+	err      error
+}
+
+type purgeFailure struct {
+	key     string
+	code    string
+	message string
+}
+
+type purgeMatchedMsg struct {
+	query   string
+	matches []Email
+	scanned int
+	err     error
+}
+
+// purgeScanMsg reports how many objects a running scan has looked at. It
+// carries the channel it came from so the view can wait for the next one.
+type purgeScanMsg struct {
+	query    string
+	scanned  int
+	progress <-chan purgeScanMsg
+}
+
+type purgeBatchMsg struct {
+	deleted  []string
+	failures []purgeFailure
+}
+
+// startPurge opens the purge view and scans the whole prefix for matches.
+func (m *model) startPurge(query string) tea.Cmd {
+	m.state = purgeState
+	m.purge = purgeRun{query: query, scanning: true}
+	m.setViewerContent(renderPurge(m.purge))
+	progress := make(chan purgeScanMsg, 1)
+	return tea.Batch(m.findPurgeMatches(query, progress), waitForPurgeScan(progress), m.spinner.Tick)
+}
+
+func waitForPurgeScan(progress <-chan purgeScanMsg) tea.Cmd {
+	return func() tea.Msg {
+		msg, ok := <-progress
+		if !ok {
+			return nil
+		}
+		return msg
+	}
+}
+
+// findPurgeMatches lists every object under the prefix and applies query.
+// Objects are only downloaded when the query needs their headers, and their
+// tags are only read for state and label qualifiers. Both are fetched
+// concurrently within bulkSlots. The running count is sent on progress,
+// which is closed when the scan ends; it may be nil.
+func (m model) findPurgeMatches(query string, progress chan purgeScanMsg) tea.Cmd {
+	return func() tea.Msg {
+		if progress != nil {
+			defer close(progress)
+		}
+		ctx := context.Background()
+		terms, text := parseFilterQuery(query)
+		needsHeaders := text != ""
+		needsTags, needsState := false, false
+		for _, term := range terms {
+			needsHeaders = needsHeaders || !s3OnlyFilterFields[term.field] && !tagFilterFields[term.field]
+			needsState = needsState || term.field == "is" || term.field == "assignee"
+			needsTags = needsTags || needsState || term.field == "label" && m.labelSync
+		}
+
+		var matches []Email
+		var scanned atomic.Int64
+		report := func() {
+			n := int(scanned.Add(1))
+			if progress == nil {
+				return
+			}
+			// A dropped update is overtaken by the next one or the result.
+			select {
+			case progress <- purgeScanMsg{query: query, scanned: n, progress: progress}:
+			default:
+			}
+		}
+		var continuation *string
+		for {
+			page, err := m.s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+				Bucket:            aws.String(m.bucket),
+				Prefix:            aws.String(m.prefix),
+				ContinuationToken: continuation,
+			})
+			if err != nil {
+				return purgeMatchedMsg{query: query, err: err}
+			}
+			var objects []types.Object
+			for _, obj := range page.Contents {
+				if obj.Key != nil && !m.inTrash(*obj.Key) {
+					objects = append(objects, obj)
+				}
+			}
+			emails := m.purgeSummaries(ctx, objects, needsHeaders, report)
+			if needsTags {
+				m.fetchAllTags(ctx, emails)
+			}
+			for _, e := range emails {
+				if needsState && e.Tags == nil {
+					return purgeMatchedMsg{query: query, err: errPurgeTagsUnreadable}
+				}
+				e.Labels = m.emailLabels(e)
+				if emailMatchesFilter(e, terms, text) {
+					matches = append(matches, e)
+				}
+			}
+			if !aws.ToBool(page.IsTruncated) || page.NextContinuationToken == nil {
+				break
+			}
+			continuation = page.NextContinuationToken
+		}
+		return purgeMatchedMsg{query: query, matches: matches, scanned: int(scanned.Load())}
+	}
+}
+
+// purgeSummaries describes each object, reading its headers when needed.
+// Downloads run concurrently within bulkSlots and keep the listing order.
+func (m model) purgeSummaries(ctx context.Context, objects []types.Object, needsHeaders bool, report func()) []Email {
+	emails := make([]Email, len(objects))
+	var wg sync.WaitGroup
+	for i, obj := range objects {
+		emails[i] = *listingSummary(obj)
+		if !needsHeaders {
+			report()
+			continue
+		}
+		wg.Add(1)
+		go func(i int, obj types.Object) {
+			defer wg.Done()
+			if m.bulkSlots != nil {
+				m.bulkSlots <- struct{}{}
+				defer func() { <-m.bulkSlots }()
+			}
+			if summary, err := m.fetchEmailSummary(ctx, obj); err == nil {
+				emails[i] = *summary
+			}
+			report()
+		}(i, obj)
+	}
+	wg.Wait()
+	return emails
+}
+
+// listingSummary describes an object from its listing entry alone.
+func listingSummary(obj types.Object) *Email {
+	email := fallbackEmailSummary(obj)
+	email.Subject = ""
+	email.SummaryError = false
+	return email
+}
+
+// runPurge deletes the previewed matches in batches of deleteBatchSize.
+func (m *model) runPurge() tea.Cmd {
+	if m.purge.scanning || m.purge.running || m.purge.finished || len(m.purge.matches) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(m.purge.matches))
+	for _, e := range m.purge.matches {
+		keys = append(keys, e.Key)
+	}
+	var cmds []tea.Cmd
+	for start := 0; start < len(keys); start += deleteBatchSize {
+		cmds = append(cmds, m.deleteBatch(keys[start:min(start+deleteBatchSize, len(keys))]))
+	}
+	m.purge.running = true
+	m.purge.batches = len(cmds)
+	m.setStatus(purgeStatus(m.purge))
+	return tea.Batch(cmds...)
+}
+
+// deleteBatch removes keys with one DeleteObjects call in quiet mode, so the
+// response lists only the keys that failed.
+func (m model) deleteBatch(keys []string) tea.Cmd {
+	return func() tea.Msg {
+		if m.bulkSlots != nil {
+			m.bulkSlots <- struct{}{}
+			defer func() { <-m.bulkSlots }()
+		}
+		objects := make([]types.ObjectIdentifier, 0, len(keys))
+		for _, key := range keys {
+			objects = append(objects, types.ObjectIdentifier{Key: aws.String(key)})
+		}
+		output, err := m.s3Client.DeleteObjects(context.Background(), &s3.DeleteObjectsInput{
+			Bucket: aws.String(m.bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			failures := make([]purgeFailure, 0, len(keys))
+			for _, key := range keys {
+				failures = append(failures, purgeFailure{key: key, message: err.Error()})
+			}
+			return purgeBatchMsg{failures: failures}
+		}
+
+		failed := make(map[string]bool, len(output.Errors))
+		var failures []purgeFailure
+		for _, e := range output.Errors {
+			key := aws.ToString(e.Key)
+			failed[key] = true
+			failures = append(failures, purgeFailure{key: key, code: aws.ToString(e.Code), message: aws.ToString(e.Message)})
+		}
+		deleted := make([]string, 0, len(keys))
+		for _, key := range keys {
+			if !failed[key] {
+				deleted = append(deleted, key)
+			}
+		}
+		return purgeBatchMsg{deleted: deleted, failures: failures}
+	}
+}
+
+func (m *model) recordPurgeScan(msg purgeScanMsg) tea.Cmd {
+	if msg.query != m.purge.query || !m.purge.scanning {
+		return nil
+	}
+	m.purge.scanned = max(m.purge.scanned, msg.scanned)
+	if m.state == purgeState {
+		m.setViewerContent(renderPurge(m.purge))
+	}
+	return waitForPurgeScan(msg.progress)
+}
+
+func (m *model) recordPurgeMatches(msg purgeMatchedMsg) {
+	if msg.query != m.purge.query || !m.purge.scanning {
+		return
+	}
+	m.purge.scanning = false
+	m.purge.scanned = msg.scanned
+	m.purge.matches = msg.matches
+	m.purge.err = msg.err
+	if msg.err != nil {
+		m.setStatus("Purge scan failed: " + msg.err.Error())
+	}
+	if m.state == purgeState {
+		m.setViewerContent(renderPurge(m.purge))
+	}
+}
+
+// recordPurgeBatch folds one batch into the run and drops deleted keys from
+// the list.
+func (m *model) recordPurgeBatch(msg purgeBatchMsg) {
+	if !m.purge.running {
+		return
+	}
+	m.purge.done++
+	m.purge.deleted += len(msg.deleted)
+	m.purge.failures = append(m.purge.failures, msg.failures...)
+	if len(msg.deleted) > 0 {
+		gone := make(map[string]bool, len(msg.deleted))
+		for _, key := range msg.deleted {
+			gone[key] = true
+			delete(m.marked, key)
+		}
+		filtered := m.emails[:0]
+		for _, e := range m.emails {
+			if gone[e.Key] {
+				removeSpool(e)
+				continue
+			}
+			filtered = append(filtered, e)
+		}
+		m.emails = filtered
+		m.updateTableRows()
+	}
+	if m.purge.done == m.purge.batches {
+		m.purge.running = false
+		m.purge.finished = true
+	}
+	m.setStatus(purgeStatus(m.purge))
+	if m.state == purgeState {
+		m.setViewerContent(renderPurge(m.purge))
+	}
+}
+
+func purgeStatus(run purgeRun) string {
+	if run.running {
+		return fmt.Sprintf("Purging: batch %d/%d, %d deleted...", run.done, run.batches, run.deleted)
+	}
+	status := fmt.Sprintf("Purged %d email(s)", run.deleted)
+	if n := len(run.failures); n > 0 {
+		status += fmt.Sprintf(", %d failed", n)
+	}
+	return status
+}
+
+// renderPurge shows the dry-run preview, then the per-key failures once the
+// purge has run.
+func renderPurge(run purgeRun) string {
+	var b strings.Builder
+	switch {
+	case run.scanning:
+		fmt.Fprintf(&b, "Scanning for emails matching '%s'... %d scanned", run.query, run.scanned)
+		return b.String()
+	case run.err != nil:
+		fmt.Fprintf(&b, "Could not scan for '%s': %v", run.query, run.err)
+		return b.String()
+	case run.finished:
+		fmt.Fprintf(&b, "%s.\n", purgeStatus(run))
+		if len(run.failures) > 0 {
+			b.WriteString("\nFailed keys:\n")
+			for _, f := range run.failures {
+				detail := f.message
+				if f.code != "" {
+					detail = f.code + ": " + detail
+				}
+				fmt.Fprintf(&b, "  %s  %s\n", f.key, detail)
+			}
+		}
+		return b.String()
+	case run.running:
+		fmt.Fprintf(&b, "%s\n", purgeStatus(run))
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "Dry run: %d of %d email(s) match '%s'.\n", len(run.matches), run.scanned, run.query)
+	if len(run.matches) == 0 {
+		b.WriteString("\nNothing to delete.")
+		return b.String()
+	}
+	b.WriteString("Press y to delete them all, esc to cancel.\n\n")
+	for _, e := range run.matches {
+		date := e.Date
+		if date.IsZero() {
+			date = e.S3Date
+		}
+		line := date.Format("2006-01-02 15:04") + "  " + e.Key
+		if e.Subject != "" {
+			line += "  " + e.fromDisplay() + ": " + e.Subject
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestFindPurgeMatches_AgeQueryUsesListingOnly(t *testing.T) {
+	now := time.Now()
+	pages := 0
+	mock := &mockS3{
+		listObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			pages++
+			if params.ContinuationToken == nil {
+				return &s3.ListObjectsV2Output{
+					Contents:              []types.Object{{Key: aws.String("inbound/old"), LastModified: aws.Time(now.AddDate(0, 0, -60))}},
+					IsTruncated:           aws.Bool(true),
+					NextContinuationToken: aws.String("next"),
+				}, nil
+			}
+			return &s3.ListObjectsV2Output{
+				Contents: []types.Object{{Key: aws.String("inbound/new"), LastModified: aws.Time(now.Add(-time.Hour))}},
+			}, nil
+		},
+		getObjectFunc: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			t.Errorf("age-only purge should not download %s", aws.ToString(params.Key))
+			return nil, errors.New("unexpected")
+		},
+	}
+	m := newMockTestModel(mock)
+
+	msg := m.findPurgeMatches("older:30d", nil)().(purgeMatchedMsg)
+
+	if msg.err != nil || pages != 2 || msg.scanned != 2 {
+		t.Fatalf("err = %v, pages = %d, scanned = %d", msg.err, pages, msg.scanned)
+	}
+	if len(msg.matches) != 1 || msg.matches[0].Key != "inbound/old" {
+		t.Fatalf("matches = %#v", msg.matches)
+	}
+}
+
+func TestFindPurgeMatches_ReadsHeadersConcurrentlyAndReportsProgress(t *testing.T) {
+	date := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var inFlight, most atomic.Int32
+	mock := &mockS3{
+		listObjectsV2Func: pageOfObjects(6),
+		getObjectFunc: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for old := most.Load(); n > old && !most.CompareAndSwap(old, n); old = most.Load() {
+			}
+			time.Sleep(20 * time.Millisecond)
+			from := "friend@example.com"
+			if aws.ToString(params.Key) == "inbound/4" {
+				from = "news@test.example"
+			}
+			return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(buildMIMEEmail(from, "me@example.com", "Hi", "body", date)))}, nil
+		},
+	}
+	m := newMockTestModel(mock)
+	m.bulkSlots = make(chan struct{}, 2)
+	progress := make(chan purgeScanMsg, 1)
+
+	var reported []int
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range progress {
+			reported = append(reported, msg.scanned)
+		}
+	}()
+	msg := m.findPurgeMatches("from:@test.example", progress)().(purgeMatchedMsg)
+	<-done
+
+	if len(msg.matches) != 1 || msg.matches[0].Key != "inbound/4" || msg.scanned != 6 {
+		t.Fatalf("matches = %#v, scanned = %d", msg.matches, msg.scanned)
+	}
+	if got := most.Load(); got != 2 {
+		t.Errorf("%d downloads in flight, want the 2 slots used", got)
+	}
+	if len(reported) == 0 || reported[len(reported)-1] > 6 {
+		t.Errorf("progress = %v", reported)
+	}
+}
+
+func TestUpdate_PurgeScanShowsLiveCount(t *testing.T) {
+	m := newReadyTestModel()
+	m.state = purgeState
+	m.purge = purgeRun{query: "from:@test.example", scanning: true}
+	progress := make(chan purgeScanMsg)
+
+	updated, cmd := m.Update(purgeScanMsg{query: "from:@test.example", scanned: 42, progress: progress})
+	m = updated.(model)
+
+	if m.purge.scanned != 42 || !strings.Contains(m.viewport.View(), "42 scanned") {
+		t.Errorf("scanned = %d, view = %q", m.purge.scanned, m.viewport.View())
+	}
+	if cmd == nil {
+		t.Fatal("expected a command waiting for the next progress update")
+	}
+	close(progress)
+
+	updated, _ = m.Update(purgeScanMsg{query: "older:1d", scanned: 99, progress: progress})
+	if got := updated.(model).purge.scanned; got != 42 {
+		t.Errorf("progress from another query changed scanned to %d", got)
+	}
+}
+
+func TestFindPurgeMatches_SenderQueryReadsHeaders(t *testing.T) {
+	date := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	bodies := map[string]string{
+		"inbound/a": buildMIMEEmail("news@test.example", "me@example.com", "Digest", "body", date),
+		"inbound/b": buildMIMEEmail("friend@example.com", "me@example.com", "Hi", "body", date),
+	}
+	mock := &mockS3{
+		listObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			return &s3.ListObjectsV2Output{Contents: []types.Object{
+				{Key: aws.String("inbound/a"), LastModified: aws.Time(date)},
+				{Key: aws.String("inbound/b"), LastModified: aws.Time(date)},
+			}}, nil
+		},
+		getObjectFunc: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(bodies[aws.ToString(params.Key)]))}, nil
+		},
+	}
+	m := newMockTestModel(mock)
+
+	msg := m.findPurgeMatches("from:@test.example", nil)().(purgeMatchedMsg)
+
+	if len(msg.matches) != 1 || msg.matches[0].Key != "inbound/a" {
+		t.Fatalf("matches = %#v", msg.matches)
+	}
+	preview := renderPurge(purgeRun{query: "from:@test.example", scanned: msg.scanned, matches: msg.matches})
+	if !strings.Contains(preview, "Dry run: 1 of 2 email(s)") || !strings.Contains(preview, "inbound/a") || strings.Contains(preview, "inbound/b") {
+		t.Errorf("preview = %q", preview)
+	}
+}
+
+func TestFindPurgeMatches_AgeUsesDeliveryTimeWithHeaderTerms(t *testing.T) {
+	now := time.Now()
+	bodies := map[string]string{
+		"inbound/backdated": buildMIMEEmail("news@test.example", "me@example.com", "Old?", "body", now.AddDate(0, 0, -60)),
+		"inbound/postdated": buildMIMEEmail("news@test.example", "me@example.com", "New?", "body", now.AddDate(0, 0, 30)),
+	}
+	mock := &mockS3{
+		listObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			return &s3.ListObjectsV2Output{Contents: []types.Object{
+				{Key: aws.String("inbound/backdated"), LastModified: aws.Time(now.Add(-time.Hour))},
+				{Key: aws.String("inbound/postdated"), LastModified: aws.Time(now.AddDate(0, 0, -60))},
+			}}, nil
+		},
+		getObjectFunc: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(bodies[aws.ToString(params.Key)]))}, nil
+		},
+	}
+	m := newMockTestModel(mock)
+
+	for _, query := range []string{"older:30d", "older:30d from:@test.example"} {
+		msg := m.findPurgeMatches(query, nil)().(purgeMatchedMsg)
+		if len(msg.matches) != 1 || msg.matches[0].Key != "inbound/postdated" {
+			t.Errorf("%s matched %#v", query, msg.matches)
+		}
+	}
+}
+
+func TestFindPurgeMatches_StateQueryReadsTags(t *testing.T) {
+	old := time.Now().AddDate(0, 0, -60)
+	tagging := &taggingMock{tags: map[string][]types.Tag{
+		"inbound/read":    {{Key: aws.String(readTag), Value: aws.String("true")}},
+		"inbound/flagged": {{Key: aws.String(readTag), Value: aws.String("true")}, {Key: aws.String(flaggedTag), Value: aws.String("true")}},
+	}}
+	mock := tagging.install(&mockS3{
+		listObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			return &s3.ListObjectsV2Output{Contents: []types.Object{
+				{Key: aws.String("inbound/read"), LastModified: aws.Time(old)},
+				{Key: aws.String("inbound/unread"), LastModified: aws.Time(old)},
+				{Key: aws.String("inbound/flagged"), LastModified: aws.Time(old)},
+			}}, nil
+		},
+		getObjectFunc: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			t.Errorf("state purge should not download %s", aws.ToString(params.Key))
+			return nil, errors.New("unexpected")
+		},
+	})
+	m := labelTestModel(t, mock)
+	if err := m.labels.set("test-bucket", "inbound/unread", []string{"keep"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"is:read older:30d":     "inbound/read,inbound/flagged",
+		"is:read is:unflagged":  "inbound/read",
+		"is:unread":             "inbound/unread",
+		"label:keep older:30d":  "inbound/unread",
+		"is:flagged label:keep": "",
+	}
+	for query, want := range cases {
+		msg := m.findPurgeMatches(query, nil)().(purgeMatchedMsg)
+		var got []string
+		for _, e := range msg.matches {
+			got = append(got, e.Key)
+		}
+		if msg.err != nil || strings.Join(got, ",") != want {
+			t.Errorf("%s matched %v (err %v), want %q", query, got, msg.err, want)
+		}
+	}
+
+	denied := newMockTestModel(&mockS3{listObjectsV2Func: mock.listObjectsV2Func})
+	msg := denied.findPurgeMatches("is:read older:30d", nil)().(purgeMatchedMsg)
+	if !errors.Is(msg.err, errPurgeTagsUnreadable) || len(msg.matches) != 0 {
+		t.Errorf("unreadable tags should refuse the purge: err = %v, matches = %d", msg.err, len(msg.matches))
+	}
+}
+
+func TestRunPurge_BatchesAndReportsPerKeyFailures(t *testing.T) {
+	var mu sync.Mutex
+	var batchSizes []int
+	mock := &mockS3{
+		deleteObjectsFunc: func(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+			mu.Lock()
+			batchSizes = append(batchSizes, len(params.Delete.Objects))
+			mu.Unlock()
+			if !aws.ToBool(params.Delete.Quiet) {
+				t.Error("DeleteObjects should run in quiet mode")
+			}
+			for _, obj := range params.Delete.Objects {
+				if aws.ToString(obj.Key) == "inbound/0007" {
+					return &s3.DeleteObjectsOutput{Errors: []types.Error{{
+						Key: obj.Key, Code: aws.String("AccessDenied"), Message: aws.String("Access Denied"),
+					}}}, nil
+				}
+			}
+			return &s3.DeleteObjectsOutput{}, nil
+		},
+	}
+	m := newMockTestModel(mock)
+	m.initComponents()
+	var matches []Email
+	for i := 0; i < 2500; i++ {
+		matches = append(matches, Email{Key: fmt.Sprintf("inbound/%04d", i)})
+	}
+	m.emails = append([]Email(nil), matches...)
+	m.updateTableRows()
+	m.state = purgeState
+	m.purge = purgeRun{query: "older:30d", scanned: 2500, matches: matches}
+
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")})
+	m = result.(model)
+	for _, msg := range runBatch(cmd) {
+		result, _ = m.Update(msg)
+		m = result.(model)
+	}
+
+	if len(batchSizes) != 3 {
+		t.Fatalf("batches = %v", batchSizes)
+	}
+	for _, n := range batchSizes {
+		if n > deleteBatchSize {
+			t.Errorf("batch of %d exceeds %d", n, deleteBatchSize)
+		}
+	}
+	if !m.purge.finished || m.purge.deleted != 2499 || len(m.purge.failures) != 1 {
+		t.Fatalf("purge = finished %v, deleted %d, failures %v", m.purge.finished, m.purge.deleted, m.purge.failures)
+	}
+	if len(m.emails) != 1 || m.emails[0].Key != "inbound/0007" {
+		t.Errorf("failed key should stay listed: %d emails left", len(m.emails))
+	}
+	if m.statusMessage != "Purged 2499 email(s), 1 failed" {
+		t.Errorf("status = %q", m.statusMessage)
+	}
+	if report := renderPurge(m.purge); !strings.Contains(report, "inbound/0007  AccessDenied: Access Denied") {
+		t.Errorf("report = %q", report)
+	}
+}
+
+func TestRunPurge_WholeBatchErrorFailsEveryKey(t *testing.T) {
+	mock := &mockS3{
+		deleteObjectsFunc: func(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+			return nil, errors.New("throttled")
+		},
+	}
+	m := newMockTestModel(mock)
+	m.purge = purgeRun{matches: []Email{{Key: "inbound/a"}, {Key: "inbound/b"}}}
+
+	msg := runBatch(m.runPurge())[0].(purgeBatchMsg)
+
+	if len(msg.deleted) != 0 || len(msg.failures) != 2 || msg.failures[1].message != "throttled" {
+		t.Fatalf("msg = %#v", msg)
+	}
+}
+
+func TestPurgePrompt_RequiresQuery(t *testing.T) {
+	m := newMockTestModel(&mockS3{})
+	m.initComponents()
+	m.state = listState
+
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("P")})
+	m = result.(model)
+	if !m.purgeActive {
+		t.Fatal("P should open the purge prompt")
+	}
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = result.(model)
+	if cmd != nil || m.state != listState || !m.purgeActive {
+		t.Errorf("empty query should not start a purge: state %v", m.state)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/jhillyerd/enmime"
+)
+
+const (
+	kindBounce    = "bounce"
+	kindComplaint = "complaint"
+	kindNormal    = "normal"
+)
+
+// DeliveryReport is a bounce or complaint, whether it arrived as a DSN
+// (RFC 3464), an ARF feedback report (RFC 5965) or an SES notification.
+type DeliveryReport struct {
+	Kind            string
+	Type            string
+	ReportingMTA    string
+	Recipients      []ReportRecipient
+	OriginalHeaders []HeaderField
+}
+
+type ReportRecipient struct {
+	Address        string
+	Action         string
+	Status         string
+	DiagnosticCode string
+}
+
+type HeaderField struct {
+	Name  string
+	Value string
+}
+
+var originalHeaderNames = []string{"From", "To", "Subject", "Date", "Message-Id"}
+
+// reportKind classifies a message from its top-level Content-Type so the list
+// can filter reports without downloading the full object.
+func reportKind(contentType string) string {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/report" {
+		return ""
+	}
+	switch strings.ToLower(params["report-type"]) {
+	case "delivery-status", "global-delivery-status":
+		return kindBounce
+	case "feedback-report":
+		return kindComplaint
+	}
+	return ""
+}
+
+func readFieldBlocks(data []byte) []textproto.MIMEHeader {
+	data = append(bytes.TrimRight(data, "\r\n"), '\n', '\n')
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+	var blocks []textproto.MIMEHeader
+	for {
+		h, err := reader.ReadMIMEHeader()
+		if len(h) > 0 {
+			blocks = append(blocks, h)
+		}
+		if err != nil {
+			return blocks
+		}
+	}
+}
+
+// stripTypePrefix removes the address or diagnostic type prefix, as in
+// "rfc822; bob@example.com" or "smtp; 550 5.1.1 User unknown".
+func stripTypePrefix(value string) string {
+	if _, rest, ok := strings.Cut(value, ";"); ok {
+		return strings.TrimSpace(rest)
+	}
+	return strings.TrimSpace(value)
+}
+
+func parseDeliveryReport(env *enmime.Envelope) *DeliveryReport {
+	if env.Root == nil {
+		return nil
+	}
+	kind := reportKind(env.Root.Header.Get("Content-Type"))
+	if kind == "" {
+		return nil
+	}
+	report := &DeliveryReport{Kind: kind}
+	parts := env.Root.DepthMatchAll(func(p *enmime.Part) bool { return true })
+	for _, part := range parts {
+		switch strings.ToLower(part.ContentType) {
+		case "message/delivery-status", "message/global-delivery-status":
+			parseDeliveryStatusPart(report, part.Content)
+		case "message/feedback-report":
+			parseFeedbackPart(report, part.Content)
+		case "message/rfc822", "text/rfc822-headers", "message/rfc822-headers":
+			if report.OriginalHeaders == nil {
+				report.OriginalHeaders = originalHeaders(part.Content)
+			}
+		}
+	}
+	return report
+}
+
+func parseDeliveryStatusPart(report *DeliveryReport, content []byte) {
+	blocks := readFieldBlocks(content)
+	for i, block := range blocks {
+		if i == 0 && block.Get("Reporting-Mta") != "" {
+			report.ReportingMTA = stripTypePrefix(block.Get("Reporting-Mta"))
+			continue
+		}
+		recipient := block.Get("Final-Recipient")
+		if recipient == "" {
+			recipient = block.Get("Original-Recipient")
+		}
+		if recipient == "" {
+			continue
+		}
+		report.Recipients = append(report.Recipients, ReportRecipient{
+			Address:        stripTypePrefix(recipient),
+			Action:         strings.ToLower(strings.TrimSpace(block.Get("Action"))),
+			Status:         strings.TrimSpace(block.Get("Status")),
+			DiagnosticCode: stripTypePrefix(block.Get("Diagnostic-Code")),
+		})
+	}
+	if report.Type == "" {
+		report.Type = dsnType(report.Recipients)
+	}
+}
+
+// dsnType mirrors the SES bounce types: a 5.x.x status is permanent and a
+// 4.x.x status is transient.
+func dsnType(recipients []ReportRecipient) string {
+	for _, r := range recipients {
+		if strings.HasPrefix(r.Status, "5") {
+			return "Permanent"
+		}
+	}
+	for _, r := range recipients {
+		if strings.HasPrefix(r.Status, "4") {
+			return "Transient"
+		}
+	}
+	return ""
+}
+
+func parseFeedbackPart(report *DeliveryReport, content []byte) {
+	blocks := readFieldBlocks(content)
+	if len(blocks) == 0 {
+		return
+	}
+	fields := blocks[0]
+	report.Type = strings.TrimSpace(fields.Get("Feedback-Type"))
+	report.ReportingMTA = strings.TrimSpace(fields.Get("Reporting-Mta"))
+	if report.ReportingMTA == "" {
+		report.ReportingMTA = strings.TrimSpace(fields.Get("User-Agent"))
+	}
+	for _, rcpt := range fields.Values("Original-Rcpt-To") {
+		report.Recipients = append(report.Recipients, ReportRecipient{Address: strings.TrimSpace(rcpt), Action: kindComplaint})
+	}
+}
+
+func originalHeaders(content []byte) []HeaderField {
+	msg, err := mail.ReadMessage(bytes.NewReader(append(append([]byte(nil), content...), '\r', '\n', '\r', '\n')))
+	if err != nil {
+		return nil
+	}
+	var fields []HeaderField
+	for _, name := range originalHeaderNames {
+		if value := msg.Header.Get(name); value != "" {
+			decoded, _ := decodeHeaderValue(name, value)
+			fields = append(fields, HeaderField{Name: name, Value: decoded})
+		}
+	}
+	return fields
+}
+
+func renderDeliveryReport(report *DeliveryReport) string {
+	if report == nil {
+		return ""
+	}
+	title := "Bounce:  "
+	if report.Kind == kindComplaint {
+		title = "Complaint:"
+	}
+	line := title
+	if report.Type != "" {
+		line += " " + report.Type
+	}
+	if report.ReportingMTA != "" {
+		line += " (reported by " + report.ReportingMTA + ")"
+	}
+	lines := []string{strings.TrimRight(line, " ")}
+	for _, r := range report.Recipients {
+		entry := "  " + r.Address
+		for _, detail := range []string{r.Action, r.Status} {
+			if detail != "" {
+				entry += "  " + detail
+			}
+		}
+		if r.DiagnosticCode != "" {
+			entry += "  " + r.DiagnosticCode
+		}
+		lines = append(lines, entry)
+	}
+	if len(report.OriginalHeaders) > 0 {
+		lines = append(lines, "Original message:")
+		for _, h := range report.OriginalHeaders {
+			lines = append(lines, fmt.Sprintf("  %s: %s", h.Name, h.Value))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func matchKindFilter(e Email, value string) bool {
+	kind := e.Kind
+	if kind == "" {
+		kind = kindNormal
+	}
+	return strings.EqualFold(strings.TrimSuffix(value, "s"), kind)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const dsnMessage = "From: Mail Delivery System <MAILER-DAEMON@mx.example>\r\n" +
+	"To: sender@acme.example\r\n" +
+	"Subject: Undelivered Mail Returned to Sender\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/report; report-type=delivery-status; boundary=\"b1\"\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Your message could not be delivered.\r\n" +
+	"--b1\r\n" +
+	"Content-Type: message/delivery-status\r\n" +
+	"\r\n" +
+	"Reporting-MTA: dns; mx.example\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; bob@example.com\r\n" +
+	"Action: failed\r\n" +
+	"Status: 5.1.1\r\n" +
+	"Diagnostic-Code: smtp; 550 5.1.1 User unknown\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/rfc822-headers\r\n" +
+	"\r\n" +
+	"From: sender@acme.example\r\n" +
+	"To: bob@example.com\r\n" +
+	"Subject: Quarterly numbers\r\n" +
+	"--b1--\r\n"
+
+const arfMessage = "From: abuse@isp.example\r\n" +
+	"To: feedback@acme.example\r\n" +
+	"Subject: Abuse report\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/report; report-type=feedback-report; boundary=\"b2\"\r\n" +
+	"\r\n" +
+	"--b2\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"This is an email abuse report.\r\n" +
+	"--b2\r\n" +
+	"Content-Type: message/feedback-report\r\n" +
+	"\r\n" +
+	"Feedback-Type: abuse\r\n" +
+	"User-Agent: ISP-FBL/1.0\r\n" +
+	"Version: 1\r\n" +
+	"Original-Rcpt-To: carol@isp.example\r\n" +
+	"--b2\r\n" +
+	"Content-Type: message/rfc822\r\n" +
+	"\r\n" +
+	"From: news@acme.example\r\n" +
+	"To: carol@isp.example\r\n" +
+	"Subject: Weekly digest\r\n" +
+	"\r\n" +
+	"Body\r\n" +
+	"--b2--\r\n"
+
+func TestParseDeliveryReport_DSN(t *testing.T) {
+	email, err := parseFullEmail([]byte(dsnMessage), "bounce.eml")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	report := email.Report
+	if report == nil || email.Kind != kindBounce {
+		t.Fatalf("kind = %q report = %#v", email.Kind, report)
+	}
+	if report.ReportingMTA != "mx.example" || report.Type != "Permanent" {
+		t.Fatalf("report = %#v", report)
+	}
+	if len(report.Recipients) != 1 {
+		t.Fatalf("recipients = %#v", report.Recipients)
+	}
+	r := report.Recipients[0]
+	if r.Address != "bob@example.com" || r.Action != "failed" || r.Status != "5.1.1" || r.DiagnosticCode != "550 5.1.1 User unknown" {
+		t.Fatalf("recipient = %#v", r)
+	}
+	card := renderDeliveryReport(report)
+	if !strings.Contains(card, "Subject: Quarterly numbers") || !strings.Contains(card, "bob@example.com") {
+		t.Fatalf("card = %q", card)
+	}
+}
+
+func TestParseDeliveryReport_ARF(t *testing.T) {
+	email, err := parseFullEmail([]byte(arfMessage), "complaint.eml")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	report := email.Report
+	if report == nil || report.Kind != kindComplaint || report.Type != "abuse" || report.ReportingMTA != "ISP-FBL/1.0" {
+		t.Fatalf("report = %#v", report)
+	}
+	if len(report.Recipients) != 1 || report.Recipients[0].Address != "carol@isp.example" {
+		t.Fatalf("recipients = %#v", report.Recipients)
+	}
+	if len(report.OriginalHeaders) == 0 || report.OriginalHeaders[2].Value != "Weekly digest" {
+		t.Fatalf("original headers = %#v", report.OriginalHeaders)
+	}
+}
+
+func TestParseDeliveryReport_IgnoresOrdinaryMail(t *testing.T) {
+	email, err := parseFullEmail([]byte(notificationMIME), "plain.eml")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if email.Report != nil || email.Kind != "" {
+		t.Fatalf("kind = %q report = %#v", email.Kind, email.Report)
+	}
+}
+
+func TestUnwrapMessage_SESBounce(t *testing.T) {
+	doc, err := json.Marshal(map[string]any{
+		"notificationType": "Bounce",
+		"bounce": map[string]any{
+			"bounceType":    "Permanent",
+			"bounceSubType": "General",
+			"reportingMTA":  "dsn; a1-2.smtp-out.amazonses.com",
+			"timestamp":     "2025-03-15T10:31:00.000Z",
+			"bouncedRecipients": []map[string]string{{
+				"emailAddress":   "bob@example.com",
+				"action":         "failed",
+				"status":         "5.1.1",
+				"diagnosticCode": "smtp; 550 5.1.1 user unknown",
+			}},
+		},
+		"mail": map[string]any{
+			"source": "sender@acme.example",
+			"commonHeaders": map[string]any{
+				"from":    []string{"sender@acme.example"},
+				"to":      []string{"bob@example.com"},
+				"subject": "Quarterly numbers",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	raw, info, err := unwrapMessage([]byte(wrapInSNS(t, string(doc))))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	email, err := parseFullEmail(raw, "ses-bounce.json")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	info.apply(email)
+
+	if email.Kind != kindBounce || !strings.Contains(email.Subject, "Quarterly numbers") {
+		t.Fatalf("kind = %q subject = %q", email.Kind, email.Subject)
+	}
+	report := email.Report
+	if report.Type != "Permanent/General" || report.ReportingMTA != "a1-2.smtp-out.amazonses.com" {
+		t.Fatalf("report = %#v", report)
+	}
+	if report.Recipients[0].DiagnosticCode != "550 5.1.1 user unknown" {
+		t.Fatalf("recipients = %#v", report.Recipients)
+	}
+}
+
+func TestMatchKindFilter(t *testing.T) {
+	bounce := Email{Kind: kindBounce}
+	complaint := Email{Kind: kindComplaint}
+	normal := Email{}
+
+	terms, _ := parseFilterQuery("type:bounce")
+	if !emailMatchesFilter(bounce, terms, "") || emailMatchesFilter(complaint, terms, "") || emailMatchesFilter(normal, terms, "") {
+		t.Fatal("type:bounce should only match bounces")
+	}
+	terms, _ = parseFilterQuery("type:complaints")
+	if !emailMatchesFilter(complaint, terms, "") {
+		t.Fatal("type:complaints should match complaints")
+	}
+	terms, _ = parseFilterQuery("type:normal")
+	if !emailMatchesFilter(normal, terms, "") || emailMatchesFilter(bounce, terms, "") {
+		t.Fatal("type:normal should only match ordinary mail")
+	}
+}
+
+func TestReportKind(t *testing.T) {
+	tests := map[string]string{
+		`multipart/report; report-type=delivery-status; boundary="x"`: kindBounce,
+		`multipart/report; report-type="feedback-report"`:             kindComplaint,
+		`multipart/mixed; boundary="x"`:                               "",
+		``:                                                            "",
+	}
+	for contentType, want := range tests {
+		if got := reportKind(contentType); got != want {
+			t.Errorf("reportKind(%q) = %q, want %q", contentType, got, want)
+		}
+	}
+}
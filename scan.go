@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	scanClean    = "clean"
+	scanInfected = "infected"
+	scanError    = "error"
+
+	scanPolicyBlock      = "block"
+	scanPolicyQuarantine = "quarantine"
+
+	actionSaved       = "saved"
+	actionBlocked     = "blocked"
+	actionQuarantined = "quarantined"
+)
+
+// clamdChunkSize is the INSTREAM chunk size; clamd rejects streams whose
+// chunks exceed its StreamMaxLength, so keep them modest.
+const clamdChunkSize = 64 * 1024
+
+// attachmentScanner checks an attachment before it is written to disk. The
+// content is streamed so that large attachments need not be held in memory.
+type attachmentScanner interface {
+	Scan(ctx context.Context, name string, content io.Reader) (status, signature string, err error)
+}
+
+// ScanResult is what happened to one attachment during a scanned save.
+type ScanResult struct {
+	Name      string
+	Status    string
+	Signature string
+	Action    string
+	Path      string
+	Detail    string
+}
+
+// commandScanner pipes each attachment to an external command such as
+// `clamdscan --stream -`. Exit status 0 means clean and 1 means infected,
+// following the ClamAV tools; anything else is a scanner error.
+type commandScanner struct {
+	args []string
+}
+
+func (s commandScanner) Scan(ctx context.Context, name string, content io.Reader) (string, string, error) {
+	cmd := exec.CommandContext(ctx, s.args[0], s.args[1:]...)
+	cmd.Stdin = content
+	cmd.Env = append(os.Environ(), "SMAILER_SCAN_FILENAME="+name)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return scanClean, "", nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return scanInfected, foundSignature(output.String()), nil
+	}
+	detail := strings.TrimSpace(output.String())
+	if detail == "" {
+		detail = err.Error()
+	}
+	return scanError, "", errors.New(firstLine(detail))
+}
+
+// clamdScanner streams attachments to a clamd daemon with the INSTREAM
+// command over a unix or TCP socket.
+type clamdScanner struct {
+	network string
+	address string
+}
+
+func (s clamdScanner) Scan(ctx context.Context, _ string, content io.Reader) (string, string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return scanError, "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return scanError, "", err
+	}
+	var size [4]byte
+	chunk := make([]byte, clamdChunkSize)
+	for {
+		n, err := io.ReadFull(content, chunk)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			if _, err := conn.Write(size[:]); err != nil {
+				return scanError, "", err
+			}
+			if _, err := conn.Write(chunk[:n]); err != nil {
+				return scanError, "", err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return scanError, "", err
+		}
+	}
+	binary.BigEndian.PutUint32(size[:], 0)
+	if _, err := conn.Write(size[:]); err != nil {
+		return scanError, "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return scanError, "", err
+	}
+	reply = strings.TrimRight(reply, "\x00\n")
+	switch {
+	case strings.HasSuffix(reply, " OK"):
+		return scanClean, "", nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return scanInfected, foundSignature(reply), nil
+	}
+	return scanError, "", fmt.Errorf("clamd: %s", reply)
+}
+
+// foundSignature extracts the signature name from ClamAV output such as
+// "stream: Eicar-Test-Signature FOUND".
+func foundSignature(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasSuffix(line, " FOUND") {
+			continue
+		}
+		line = strings.TrimSuffix(line, " FOUND")
+		if _, rest, ok := strings.Cut(line, ": "); ok {
+			return rest
+		}
+		return line
+	}
+	return ""
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+// scannerFromEnv builds the scanner named by SMAILER_SCAN_COMMAND or
+// SMAILER_SCAN_SOCKET ("unix:/run/clamd.sock" or "tcp:127.0.0.1:3310"), or
+// returns nil when no scanning is configured. The command is split like a
+// shell would, so paths and arguments may be quoted. A command that cannot
+// be split is reported, and every scan fails rather than files being saved
+// unchecked.
+func scannerFromEnv() (attachmentScanner, error) {
+	if value := os.Getenv("SMAILER_SCAN_COMMAND"); strings.TrimSpace(value) != "" {
+		command, err := splitShellWords(value)
+		if err != nil {
+			err = fmt.Errorf("SMAILER_SCAN_COMMAND: %w", err)
+			return failingScanner{err: err}, err
+		}
+		return commandScanner{args: command}, nil
+	}
+	if socket := os.Getenv("SMAILER_SCAN_SOCKET"); socket != "" {
+		network, address, ok := strings.Cut(socket, ":")
+		if !ok || (network != "unix" && network != "tcp") {
+			network, address = "tcp", socket
+			if strings.HasPrefix(socket, "/") {
+				network = "unix"
+			}
+		}
+		return clamdScanner{network: network, address: address}, nil
+	}
+	return nil, nil
+}
+
+// failingScanner stands in for a misconfigured scanner so that attachments
+// are blocked or quarantined instead of saved unscanned.
+type failingScanner struct {
+	err error
+}
+
+func (s failingScanner) Scan(context.Context, string, io.Reader) (string, string, error) {
+	return scanError, "", s.err
+}
+
+// splitShellWords splits s into words the way a POSIX shell does, without
+// expansions: whitespace separates words, single quotes keep everything
+// literally, and in double quotes or unquoted text a backslash escapes the
+// next character.
+func splitShellWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`\n", s[i+1]) >= 0 {
+					i++
+					if s[i] == '\n' {
+						continue
+					}
+				}
+				word.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, errors.New("unterminated double quote")
+			}
+			inWord = true
+		case c == '\\':
+			if i+1 == len(s) {
+				return nil, errors.New("trailing backslash")
+			}
+			i++
+			if s[i] != '\n' {
+				word.WriteByte(s[i])
+				inWord = true
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// scanPolicyFromEnv reads SMAILER_SCAN_POLICY: "block" (the default) drops
+// infected files, "quarantine" writes them to a quarantine folder instead.
+func scanPolicyFromEnv() string {
+	if strings.EqualFold(os.Getenv("SMAILER_SCAN_POLICY"), scanPolicyQuarantine) {
+		return scanPolicyQuarantine
+	}
+	return scanPolicyBlock
+}
+
+// saveScannedAttachments scans each attachment before writing it. Clean
+// files are saved as usual; infected files, and files the scanner could not
+// check, are blocked or quarantined according to policy.
+func saveScannedAttachments(ctx context.Context, dir string, email Email, scanner attachmentScanner, policy string) ([]string, []ScanResult, error) {
+	base := strings.TrimSuffix(emailFilename(email), ".eml") + "-attachments"
+	baseDir := filepath.Join(dir, base)
+	quarantineDir := filepath.Join(dir, "quarantine", base)
+
+	var paths []string
+	results := make([]ScanResult, 0, len(email.Attachments))
+	for i, attachment := range email.Attachments {
+		name := attachmentFilename(attachment, i)
+		result := ScanResult{Name: name, Status: scanClean}
+		if attachment.Omitted {
+			result.Status, result.Action = scanError, actionBlocked
+			result.Detail = "over the memory limit"
+			results = append(results, result)
+			continue
+		}
+		if scanner != nil {
+			status, signature, err := scanAttachment(ctx, scanner, name, attachment)
+			result.Status, result.Signature = status, signature
+			if err != nil {
+				result.Status = scanError
+				result.Detail = err.Error()
+			}
+		}
+
+		switch {
+		case result.Status == scanClean:
+			path, err := saveAttachment(baseDir, name, attachment, 0o644)
+			if err != nil {
+				return paths, results, err
+			}
+			result.Action, result.Path = actionSaved, path
+			paths = append(paths, path)
+		case policy == scanPolicyQuarantine:
+			path, err := saveAttachment(quarantineDir, name+".quarantine", attachment, 0o600)
+			if err != nil {
+				return paths, results, err
+			}
+			result.Action, result.Path = actionQuarantined, path
+		default:
+			result.Action = actionBlocked
+		}
+		results = append(results, result)
+	}
+	return paths, results, nil
+}
+
+func attachmentFilename(attachment Attachment, index int) string {
+	name := sanitizeFilename(attachment.Name)
+	if name == "" || name == "." {
+		name = fmt.Sprintf("attachment-%d", index+1)
+	}
+	return name
+}
+
+func scanAttachment(ctx context.Context, scanner attachmentScanner, name string, attachment Attachment) (string, string, error) {
+	content, err := attachment.open()
+	if err != nil {
+		return scanError, "", err
+	}
+	defer content.Close()
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	return scanner.Scan(ctx, name, content)
+}
+
+func saveAttachment(dir, name string, attachment Attachment, mode os.FileMode) (string, error) {
+	content, err := attachment.open()
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+	return writeAttachment(dir, name, content, mode)
+}
+
+// writeAttachment streams content to a new file named after name in dir,
+// removing the partial file if the copy fails.
+func writeAttachment(dir, name string, content io.Reader, mode os.FileMode) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path, err := uniquePath(filepath.Join(dir, name))
+	if err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+func scanStatus(results []ScanResult) string {
+	counts := make(map[string]int)
+	for _, r := range results {
+		counts[r.Action]++
+	}
+	status := fmt.Sprintf("Saved %d attachment(s)", counts[actionSaved])
+	if n := counts[actionBlocked]; n > 0 {
+		status += fmt.Sprintf(", blocked %d", n)
+	}
+	if n := counts[actionQuarantined]; n > 0 {
+		status += fmt.Sprintf(", quarantined %d", n)
+	}
+	return status
+}
+
+func renderScanResults(results []ScanResult) string {
+	if len(results) == 0 {
+		return ""
+	}
+	lines := make([]string, 0, len(results)+1)
+	lines = append(lines, "Scan:")
+	for _, r := range results {
+		var badge string
+		switch r.Status {
+		case scanClean:
+			badge = passBadgeStyle.Render("✓ clean")
+		case scanInfected:
+			label := "✗ infected"
+			if r.Signature != "" {
+				label += " (" + r.Signature + ")"
+			}
+			badge = failBadgeStyle.Render(label)
+		default:
+			badge = neutralBadgeStyle.Render("? not scanned: " + r.Detail)
+		}
+		lines = append(lines, fmt.Sprintf("  %s  %s, %s", r.Name, badge, r.Action))
+	}
+	return strings.Join(lines, "\n")
+}
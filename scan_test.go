@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeScanner map[string]string
+
+func (f fakeScanner) Scan(_ context.Context, name string, _ io.Reader) (string, string, error) {
+	switch f[name] {
+	case "":
+		return scanClean, "", nil
+	case scanError:
+		return scanError, "", errors.New("scanner unavailable")
+	default:
+		return scanInfected, f[name], nil
+	}
+}
+
+func scanTestEmail() Email {
+	return Email{
+		Key:     "scan",
+		Subject: "Scanned",
+		Date:    time.Date(2025, 3, 15, 10, 30, 0, 0, time.UTC),
+		Attachments: []Attachment{
+			{Name: "invoice.pdf", Data: []byte("pdf")},
+			{Name: "eicar.com", Data: []byte("virus")},
+			{Name: "notes.txt", Data: []byte("notes")},
+		},
+	}
+}
+
+func TestSaveScannedAttachments_BlocksInfected(t *testing.T) {
+	dir := t.TempDir()
+	scanner := fakeScanner{"eicar.com": "Eicar-Test-Signature", "notes.txt": scanError}
+
+	paths, results, err := saveScannedAttachments(context.Background(), dir, scanTestEmail(), scanner, scanPolicyBlock)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(paths) != 1 || filepath.Base(paths[0]) != "invoice.pdf" {
+		t.Fatalf("paths = %#v", paths)
+	}
+	if results[1].Status != scanInfected || results[1].Action != actionBlocked || results[1].Signature != "Eicar-Test-Signature" {
+		t.Fatalf("infected result = %#v", results[1])
+	}
+	if results[2].Status != scanError || results[2].Action != actionBlocked {
+		t.Fatalf("error result = %#v", results[2])
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(paths[0]), "eicar.com")); !os.IsNotExist(err) {
+		t.Fatal("infected attachment should not be written")
+	}
+	if got := scanStatus(results); got != "Saved 1 attachment(s), blocked 2" {
+		t.Fatalf("status = %q", got)
+	}
+}
+
+func TestSaveScannedAttachments_Quarantines(t *testing.T) {
+	dir := t.TempDir()
+	scanner := fakeScanner{"eicar.com": "Eicar-Test-Signature"}
+
+	_, results, err := saveScannedAttachments(context.Background(), dir, scanTestEmail(), scanner, scanPolicyQuarantine)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r := results[1]
+	if r.Action != actionQuarantined || !strings.Contains(r.Path, string(filepath.Separator)+"quarantine"+string(filepath.Separator)) {
+		t.Fatalf("result = %#v", r)
+	}
+	info, err := os.Stat(r.Path)
+	if err != nil {
+		t.Fatalf("stat quarantined file: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 || !strings.HasSuffix(r.Path, "eicar.com.quarantine") {
+		t.Fatalf("quarantined file %s mode %v", r.Path, info.Mode())
+	}
+}
+
+func TestCommandScanner_ExitCodes(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "scan.sh")
+	body := "#!/bin/sh\n" +
+		"if grep -q EICAR; then echo \"stream: Eicar-Test-Signature FOUND\"; exit 1; fi\n" +
+		"[ \"$SMAILER_SCAN_FILENAME\" = broken.bin ] && { echo 'cannot connect to clamd' >&2; exit 2; }\n" +
+		"echo 'stream: OK'\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatalf("write script: %v", err)
+	}
+	scanner := commandScanner{args: []string{"/bin/sh", script}}
+
+	status, _, err := scanner.Scan(context.Background(), "clean.txt", strings.NewReader("hello"))
+	if err != nil || status != scanClean {
+		t.Fatalf("clean: status = %q err = %v", status, err)
+	}
+	status, signature, err := scanner.Scan(context.Background(), "eicar.com", strings.NewReader("X5O EICAR"))
+	if err != nil || status != scanInfected || signature != "Eicar-Test-Signature" {
+		t.Fatalf("infected: status = %q signature = %q err = %v", status, signature, err)
+	}
+	status, _, err = scanner.Scan(context.Background(), "broken.bin", strings.NewReader("data"))
+	if status != scanError || err == nil || err.Error() != "cannot connect to clamd" {
+		t.Fatalf("error: status = %q err = %v", status, err)
+	}
+}
+
+func TestClamdScanner_Instream(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "clamd.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			reader := bufio.NewReader(conn)
+			command, _ := reader.ReadString(0)
+			var data []byte
+			for command == "zINSTREAM\x00" {
+				var size [4]byte
+				if _, err := io.ReadFull(reader, size[:]); err != nil {
+					break
+				}
+				n := binary.BigEndian.Uint32(size[:])
+				if n == 0 {
+					break
+				}
+				chunk := make([]byte, n)
+				if _, err := io.ReadFull(reader, chunk); err != nil {
+					break
+				}
+				data = append(data, chunk...)
+			}
+			if strings.Contains(string(data), "EICAR") {
+				conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+			} else {
+				conn.Write([]byte("stream: OK\x00"))
+			}
+			conn.Close()
+		}
+	}()
+
+	scanner := clamdScanner{network: "unix", address: socket}
+	big := strings.NewReader(strings.Repeat("a", clamdChunkSize*2+10))
+	if status, _, err := scanner.Scan(context.Background(), "big.bin", big); err != nil || status != scanClean {
+		t.Fatalf("clean: status = %q err = %v", status, err)
+	}
+	status, signature, err := scanner.Scan(context.Background(), "eicar.com", strings.NewReader("EICAR"))
+	if err != nil || status != scanInfected || signature != "Eicar-Test-Signature" {
+		t.Fatalf("infected: status = %q signature = %q err = %v", status, signature, err)
+	}
+}
+
+func TestScannerFromEnv(t *testing.T) {
+	t.Setenv("SMAILER_SCAN_COMMAND", "")
+	t.Setenv("SMAILER_SCAN_SOCKET", "")
+	if s, err := scannerFromEnv(); s != nil || err != nil {
+		t.Fatalf("expected no scanner by default: %#v %v", s, err)
+	}
+	t.Setenv("SMAILER_SCAN_SOCKET", "/run/clamd.ctl")
+	if s, _ := scannerFromEnv(); s != (clamdScanner{network: "unix", address: "/run/clamd.ctl"}) {
+		t.Fatalf("scanner = %#v", s)
+	}
+	t.Setenv("SMAILER_SCAN_SOCKET", "tcp:127.0.0.1:3310")
+	if s, _ := scannerFromEnv(); s != (clamdScanner{network: "tcp", address: "127.0.0.1:3310"}) {
+		t.Fatalf("scanner = %#v", s)
+	}
+	t.Setenv("SMAILER_SCAN_COMMAND", `"/opt/Clam AV/clamdscan" --config-file='/etc/clam d.conf' --stream -`)
+	s, err := scannerFromEnv()
+	want := []string{"/opt/Clam AV/clamdscan", "--config-file=/etc/clam d.conf", "--stream", "-"}
+	if c, ok := s.(commandScanner); !ok || err != nil || !reflect.DeepEqual(c.args, want) {
+		t.Fatalf("scanner = %#v, err = %v", s, err)
+	}
+
+	t.Setenv("SMAILER_SCAN_COMMAND", `clamdscan "--stream -`)
+	s, err = scannerFromEnv()
+	if err == nil || !strings.Contains(err.Error(), "unterminated") {
+		t.Fatalf("err = %v", err)
+	}
+	if status, _, _ := s.Scan(context.Background(), "a.pdf", strings.NewReader("x")); status != scanError {
+		t.Errorf("a broken command must fail every scan, got %q", status)
+	}
+}
+
+func TestSplitShellWords(t *testing.T) {
+	cases := map[string][]string{
+		"clamdscan --stream -":  {"clamdscan", "--stream", "-"},
+		`a\ b 'c "d' "e 'f" ""`: {"a b", `c "d`, "e 'f", ""},
+		`"x\"y\\z" 'no\escape'`: {`x"y\z`, `no\escape`},
+		"  spaced\tout \n ":     {"spaced", "out"},
+		`pre"mid"'post'`:        {"premidpost"},
+	}
+	for input, want := range cases {
+		got, err := splitShellWords(input)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("splitShellWords(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	for _, bad := range []string{`'open`, `"open`, `trailing\`} {
+		if _, err := splitShellWords(bad); err == nil {
+			t.Errorf("splitShellWords(%q) should fail", bad)
+		}
+	}
+}
+
+func TestUpdate_AttachmentsSavedStoresScanResults(t *testing.T) {
+	m := model{emails: []Email{{Key: "scan"}}}
+	m.selectedEmail = &m.emails[0]
+	results := []ScanResult{
+		{Name: "invoice.pdf", Status: scanClean, Action: actionSaved},
+		{Name: "eicar.com", Status: scanInfected, Signature: "Eicar", Action: actionBlocked},
+	}
+
+	result, _ := m.Update(attachmentsSavedMsg{key: "scan", paths: []string{"invoice.pdf"}, results: results})
+	updated := result.(model)
+	if updated.statusMessage != "Saved 1 attachment(s), blocked 1" {
+		t.Fatalf("status = %q", updated.statusMessage)
+	}
+	if len(updated.selectedEmail.ScanResults) != 2 {
+		t.Fatalf("scan results = %#v", updated.selectedEmail.ScanResults)
+	}
+	if panel := renderScanResults(updated.selectedEmail.ScanResults); !strings.Contains(panel, "eicar.com") || !strings.Contains(panel, "blocked") {
+		t.Fatalf("panel = %q", panel)
+	}
+}
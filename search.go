@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	matchOn         = "\x1b[7m"
+	matchOff        = "\x1b[27m"
+	currentMatchOn  = "\x1b[7;4m"
+	currentMatchOff = "\x1b[27;24m"
+)
+
+type searchMatch struct {
+	line  int
+	start int
+	end   int
+}
+
+// ansiSequenceEnd returns the index just past the escape sequence starting at
+// s[i]. CSI and OSC sequences come from glamour; APC and DCS carry inline
+// images.
+func ansiSequenceEnd(s string, i int) int {
+	i++
+	if i >= len(s) {
+		return i
+	}
+	switch s[i] {
+	case '[':
+		i++
+		for i < len(s) && (s[i] < 0x40 || s[i] > 0x7e) {
+			i++
+		}
+		return min(i+1, len(s))
+	case ']', '_', 'P':
+		for i < len(s) {
+			if s[i] == '\a' {
+				return i + 1
+			}
+			if s[i] == '\033' && i+1 < len(s) && s[i+1] == '\\' {
+				return i + 2
+			}
+			i++
+		}
+		return i
+	default:
+		return i + 1
+	}
+}
+
+func visibleRunes(line string) []rune {
+	var runes []rune
+	for i := 0; i < len(line); {
+		if line[i] == '\033' {
+			i = ansiSequenceEnd(line, i)
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(line[i:])
+		runes = append(runes, r)
+		i += size
+	}
+	return runes
+}
+
+func findMatches(content, query string) []searchMatch {
+	needle := []rune(strings.TrimSpace(query))
+	if len(needle) == 0 {
+		return nil
+	}
+	for i, r := range needle {
+		needle[i] = unicode.ToLower(r)
+	}
+
+	var matches []searchMatch
+	for lineNo, line := range strings.Split(content, "\n") {
+		text := visibleRunes(line)
+		for i := 0; i+len(needle) <= len(text); {
+			found := true
+			for j, r := range needle {
+				if unicode.ToLower(text[i+j]) != r {
+					found = false
+					break
+				}
+			}
+			if !found {
+				i++
+				continue
+			}
+			matches = append(matches, searchMatch{line: lineNo, start: i, end: i + len(needle)})
+			i += len(needle)
+		}
+	}
+	return matches
+}
+
+// highlightMatches wraps every match in reverse video without disturbing the
+// surrounding ANSI styling. Styles set inside a match are followed by the
+// highlight code again so glamour's resets cannot cancel it.
+func highlightMatches(content string, matches []searchMatch, current int) string {
+	if len(matches) == 0 {
+		return content
+	}
+	byLine := make(map[int][]int)
+	for i, match := range matches {
+		byLine[match.line] = append(byLine[match.line], i)
+	}
+
+	lines := strings.Split(content, "\n")
+	for lineNo, indexes := range byLine {
+		if lineNo >= len(lines) {
+			continue
+		}
+		lines[lineNo] = highlightLine(lines[lineNo], matches, indexes, current)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func highlightLine(line string, matches []searchMatch, indexes []int, current int) string {
+	var buf strings.Builder
+	pos := 0
+	next := 0
+	active := -1
+	on := func(idx int) string {
+		if idx == current {
+			return currentMatchOn
+		}
+		return matchOn
+	}
+	off := func(idx int) string {
+		if idx == current {
+			return currentMatchOff
+		}
+		return matchOff
+	}
+
+	for i := 0; i <= len(line); {
+		if active >= 0 && pos == matches[active].end {
+			buf.WriteString(off(active))
+			active = -1
+		}
+		if i < len(line) && line[i] == '\033' {
+			end := ansiSequenceEnd(line, i)
+			buf.WriteString(line[i:end])
+			if active >= 0 {
+				buf.WriteString(on(active))
+			}
+			i = end
+			continue
+		}
+		if active < 0 && next < len(indexes) && pos == matches[indexes[next]].start {
+			active = indexes[next]
+			next++
+			buf.WriteString(on(active))
+		}
+		if i == len(line) {
+			break
+		}
+		_, size := utf8.DecodeRuneInString(line[i:])
+		buf.WriteString(line[i : i+size])
+		i += size
+		pos++
+	}
+	if active >= 0 {
+		buf.WriteString(off(active))
+	}
+	return buf.String()
+}
+
+func (m *model) setViewerContent(content string) {
+	m.viewerContent = content
+	m.searchMatches = findMatches(content, m.searchQuery)
+	if m.searchIndex >= len(m.searchMatches) {
+		m.searchIndex = 0
+	}
+	m.viewport.SetContent(highlightMatches(content, m.searchMatches, m.searchIndex))
+}
+
+func (m *model) applySearch(query string) {
+	m.searchQuery = strings.TrimSpace(query)
+	m.searchIndex = 0
+	m.setViewerContent(m.viewerContent)
+	m.scrollToMatch()
+}
+
+func (m *model) clearSearch() {
+	m.searchQuery = ""
+	m.searchInput.SetValue("")
+	m.searchIndex = 0
+	m.setViewerContent(m.viewerContent)
+}
+
+func (m *model) stepMatch(delta int) {
+	if len(m.searchMatches) == 0 {
+		return
+	}
+	m.searchIndex = (m.searchIndex + delta + len(m.searchMatches)) % len(m.searchMatches)
+	m.viewport.SetContent(highlightMatches(m.viewerContent, m.searchMatches, m.searchIndex))
+	m.scrollToMatch()
+}
+
+func (m *model) scrollToMatch() {
+	if len(m.searchMatches) == 0 {
+		return
+	}
+	line := m.searchMatches[m.searchIndex].line
+	if line >= m.viewport.YOffset && line < m.viewport.YOffset+m.viewport.Height {
+		return
+	}
+	m.viewport.SetYOffset(max(0, line-m.viewport.Height/3))
+}
+
+func (m model) searchCounter() string {
+	if m.searchQuery == "" {
+		return ""
+	}
+	if len(m.searchMatches) == 0 {
+		return fmt.Sprintf("'%s': no matches", m.searchQuery)
+	}
+	return fmt.Sprintf("'%s': match %d/%d", m.searchQuery, m.searchIndex+1, len(m.searchMatches))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestFindMatches_IgnoresANSIAndCase(t *testing.T) {
+	content := "plain line\n\x1b[1mHel\x1b[0mlo hello\nnothing"
+
+	matches := findMatches(content, "HELLO")
+
+	if len(matches) != 2 {
+		t.Fatalf("expected 2 matches, got %#v", matches)
+	}
+	if matches[0] != (searchMatch{line: 1, start: 0, end: 5}) {
+		t.Fatalf("unexpected first match %#v", matches[0])
+	}
+	if matches[1] != (searchMatch{line: 1, start: 6, end: 11}) {
+		t.Fatalf("unexpected second match %#v", matches[1])
+	}
+}
+
+func TestFindMatches_EmptyQuery(t *testing.T) {
+	if got := findMatches("anything", "  "); got != nil {
+		t.Fatalf("expected no matches, got %#v", got)
+	}
+}
+
+func TestHighlightMatches_ReappliesAfterStyleReset(t *testing.T) {
+	content := "\x1b[1mHel\x1b[0mlo"
+	matches := findMatches(content, "hello")
+
+	got := highlightMatches(content, matches, 0)
+
+	want := "\x1b[1m" + currentMatchOn + "Hel\x1b[0m" + currentMatchOn + "lo" + currentMatchOff
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if stripped := string(visibleRunes(got)); stripped != "Hello" {
+		t.Fatalf("highlight changed visible text: %q", stripped)
+	}
+}
+
+func TestHighlightMatches_DistinguishesCurrentMatch(t *testing.T) {
+	content := "foo bar foo"
+	matches := findMatches(content, "foo")
+
+	got := highlightMatches(content, matches, 1)
+
+	want := matchOn + "foo" + matchOff + " bar " + currentMatchOn + "foo" + currentMatchOff
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestUpdate_ViewSearchNavigatesMatches(t *testing.T) {
+	m := newReadyTestModel()
+	m.state = viewState
+	m.selectedEmail = &Email{BodyLoaded: true}
+	m.viewport.Height = 5
+	lines := make([]string, 50)
+	for i := range lines {
+		lines[i] = "filler"
+	}
+	lines[10] = "needle one"
+	lines[40] = "needle two"
+	m.setViewerContent(strings.Join(lines, "\n"))
+
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'/'}})
+	m = result.(model)
+	if !m.searchActive {
+		t.Fatal("/ should open search input")
+	}
+	for _, r := range "needle" {
+		result, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+		m = result.(model)
+	}
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = result.(model)
+
+	if m.searchActive || m.searchQuery != "needle" || len(m.searchMatches) != 2 {
+		t.Fatalf("unexpected search state: active=%v query=%q matches=%d", m.searchActive, m.searchQuery, len(m.searchMatches))
+	}
+	if m.viewport.YOffset > 10 || m.viewport.YOffset+m.viewport.Height <= 10 {
+		t.Fatalf("first match not visible, YOffset=%d", m.viewport.YOffset)
+	}
+
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'n'}})
+	m = result.(model)
+	if m.searchIndex != 1 || m.viewport.YOffset > 40 || m.viewport.YOffset+m.viewport.Height <= 40 {
+		t.Fatalf("n should move to second match, index=%d YOffset=%d", m.searchIndex, m.viewport.YOffset)
+	}
+	if !strings.Contains(m.renderEmailView(), "match 2/2") {
+		t.Fatal("expected match counter in help line")
+	}
+
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'N'}})
+	m = result.(model)
+	if m.searchIndex != 0 {
+		t.Fatalf("N should wrap back, index=%d", m.searchIndex)
+	}
+
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m = result.(model)
+	if m.state != viewState || m.searchQuery != "" {
+		t.Fatal("esc should clear the search before leaving the view")
+	}
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/smallstep/pkcs7"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const (
+	schemeSMIME = "S/MIME"
+	schemePGP   = "PGP"
+
+	trustTrusted   = "trusted"
+	trustUntrusted = "untrusted"
+	trustInvalid   = "invalid"
+	trustUnknown   = "unknown key"
+)
+
+// maxSecureLayers bounds how many signed or encrypted wrappers are opened, so
+// a message nesting them without end cannot stall the viewer.
+const maxSecureLayers = 4
+
+// Security records what was found while opening signed or encrypted mail.
+type Security struct {
+	Scheme    string
+	Encrypted bool
+	Decrypted bool
+	Signed    bool
+	Signer    string
+	Trust     string
+	Detail    string
+}
+
+// securityKeys holds the locally configured certificates and keyrings used
+// to verify and decrypt mail. Problems loading them are kept so the viewer
+// can explain why a message could not be opened.
+type securityKeys struct {
+	smimeCert  *x509.Certificate
+	smimeKey   crypto.PrivateKey
+	roots      *x509.CertPool
+	pgpKeyring openpgp.EntityList
+	problems   []string
+}
+
+// loadSecurityKeysFromEnv reads SMAILER_SMIME_CERT (PEM or PKCS#12, with
+// SMAILER_SMIME_PASSWORD), SMAILER_SMIME_CA (PEM roots, defaulting to the
+// system pool) and SMAILER_PGP_KEYRING (armored, with SMAILER_PGP_PASSPHRASE
+// for protected secret keys).
+func loadSecurityKeysFromEnv() *securityKeys {
+	keys := &securityKeys{}
+	if path := os.Getenv("SMAILER_SMIME_CERT"); path != "" {
+		if err := keys.loadSMIME(path, os.Getenv("SMAILER_SMIME_PASSWORD")); err != nil {
+			keys.problems = append(keys.problems, "S/MIME certificate: "+err.Error())
+		}
+	}
+	if path := os.Getenv("SMAILER_SMIME_CA"); path != "" {
+		if err := keys.loadRoots(path); err != nil {
+			keys.problems = append(keys.problems, "S/MIME CA bundle: "+err.Error())
+		}
+	}
+	if path := os.Getenv("SMAILER_PGP_KEYRING"); path != "" {
+		if err := keys.loadPGP(path, os.Getenv("SMAILER_PGP_PASSPHRASE")); err != nil {
+			keys.problems = append(keys.problems, "PGP keyring: "+err.Error())
+		}
+	}
+	return keys
+}
+
+func (k *securityKeys) loadSMIME(path, password string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		key, cert, _, err := pkcs12.DecodeChain(data, password)
+		if err != nil {
+			return err
+		}
+		k.smimeCert, k.smimeKey = cert, key
+		return nil
+	}
+
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		switch block.Type {
+		case "CERTIFICATE":
+			if k.smimeCert == nil {
+				if k.smimeCert, err = x509.ParseCertificate(block.Bytes); err != nil {
+					return err
+				}
+			}
+		case "PRIVATE KEY":
+			if k.smimeKey, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+				return err
+			}
+		case "RSA PRIVATE KEY":
+			if k.smimeKey, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+				return err
+			}
+		case "EC PRIVATE KEY":
+			if k.smimeKey, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+				return err
+			}
+		}
+	}
+	if k.smimeCert == nil || k.smimeKey == nil {
+		return errors.New("expected a certificate and private key")
+	}
+	return nil
+}
+
+func (k *securityKeys) loadRoots(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return errors.New("no certificates found")
+	}
+	k.roots = pool
+	return nil
+}
+
+func (k *securityKeys) loadPGP(path, passphrase string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	keyring, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return err
+	}
+	for _, entity := range keyring {
+		if entity.PrivateKey != nil && entity.PrivateKey.Encrypted && passphrase != "" {
+			if err := entity.DecryptPrivateKeys([]byte(passphrase)); err != nil {
+				return err
+			}
+		}
+	}
+	k.pgpKeyring = keyring
+	return nil
+}
+
+func (k *securityKeys) trustRoots() *x509.CertPool {
+	if k != nil && k.roots != nil {
+		return k.roots
+	}
+	if pool, err := x509.SystemCertPool(); err == nil {
+		return pool
+	}
+	return x509.NewCertPool()
+}
+
+// openSecureMessage unwraps S/MIME and PGP/MIME layers, returning a message
+// whose body is the signed or decrypted content so the usual parser can
+// render it. Messages without such layers are returned unchanged with a nil
+// Security.
+func openSecureMessage(raw []byte, keys *securityKeys) ([]byte, *Security) {
+	var security *Security
+	for layer := 0; layer < maxSecureLayers; layer++ {
+		msg, err := mail.ReadMessage(bytes.NewReader(raw))
+		if err != nil {
+			return raw, security
+		}
+		mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		if err != nil {
+			return raw, security
+		}
+		body, err := io.ReadAll(msg.Body)
+		if err != nil {
+			return raw, security
+		}
+
+		var inner []byte
+		switch {
+		case mediaType == "multipart/signed":
+			if security == nil {
+				security = &Security{}
+			}
+			inner = openMultipartSigned(normaliseLineEndings(body), params, keys, security)
+		case mediaType == "application/pkcs7-mime" || mediaType == "application/x-pkcs7-mime":
+			if security == nil {
+				security = &Security{}
+			}
+			inner = openPKCS7Mime(body, msg.Header.Get("Content-Transfer-Encoding"), params, keys, security)
+		case mediaType == "multipart/encrypted" && strings.EqualFold(params["protocol"], "application/pgp-encrypted"):
+			if security == nil {
+				security = &Security{}
+			}
+			inner = openPGPEncrypted(normaliseLineEndings(body), params["boundary"], keys, security)
+		default:
+			return raw, security
+		}
+		if inner == nil {
+			keys.explain(security)
+			return raw, security
+		}
+		raw = replaceEntity(raw, inner)
+	}
+	return raw, security
+}
+
+func (k *securityKeys) explain(security *Security) {
+	if k == nil || security == nil || security.Detail == "" || len(k.problems) == 0 {
+		return
+	}
+	security.Detail += " (" + strings.Join(k.problems, "; ") + ")"
+}
+
+// replaceEntity swaps the content of a message for inner, a MIME entity with
+// its own Content-* headers, while keeping the outer From, To, Subject and
+// other envelope headers.
+func replaceEntity(raw, inner []byte) []byte {
+	header, _ := splitRawMessage(normaliseLineEndings(raw))
+	var buf bytes.Buffer
+	for _, field := range splitHeaderFields(header) {
+		name := strings.ToLower(field.name)
+		if strings.HasPrefix(name, "content-") || name == "mime-version" {
+			continue
+		}
+		buf.WriteString(field.raw)
+	}
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	inner = normaliseLineEndings(inner)
+	if bytes.HasPrefix(inner, []byte("\r\n")) {
+		buf.WriteString("Content-Type: text/plain\r\n")
+	}
+	buf.Write(inner)
+	return buf.Bytes()
+}
+
+// splitMultipart returns the raw bytes of each part, headers included, as
+// signatures cover the exact bytes between the boundaries.
+func splitMultipart(body []byte, boundary string) [][]byte {
+	if boundary == "" {
+		return nil
+	}
+	delimiter := []byte("--" + boundary)
+	start := bytes.Index(body, delimiter)
+	if start < 0 {
+		return nil
+	}
+	var parts [][]byte
+	rest := body[start:]
+	for {
+		rest = rest[len(delimiter):]
+		if bytes.HasPrefix(rest, []byte("--")) {
+			break
+		}
+		lineEnd := bytes.Index(rest, []byte("\r\n"))
+		if lineEnd < 0 {
+			break
+		}
+		rest = rest[lineEnd+2:]
+		next := bytes.Index(rest, append([]byte("\r\n"), delimiter...))
+		if next < 0 {
+			break
+		}
+		parts = append(parts, rest[:next])
+		rest = rest[next+2:]
+	}
+	return parts
+}
+
+func decodePartBody(part []byte) (mediaType string, content []byte) {
+	msg, err := mail.ReadMessage(bytes.NewReader(part))
+	if err != nil {
+		return "", nil
+	}
+	mediaType, _, _ = mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	content, _ = io.ReadAll(msg.Body)
+	if strings.EqualFold(strings.TrimSpace(msg.Header.Get("Content-Transfer-Encoding")), "base64") {
+		if decoded, err := base64.StdEncoding.DecodeString(stripWhitespace(string(content))); err == nil {
+			content = decoded
+		}
+	}
+	return mediaType, content
+}
+
+func openMultipartSigned(body []byte, params map[string]string, keys *securityKeys, security *Security) []byte {
+	parts := splitMultipart(body, params["boundary"])
+	if len(parts) < 2 {
+		security.Detail = "malformed multipart/signed message"
+		return nil
+	}
+	signed := parts[0]
+	sigType, signature := decodePartBody(parts[1])
+	security.Signed = true
+
+	switch sigType {
+	case "application/pkcs7-signature", "application/x-pkcs7-signature":
+		security.Scheme = schemeSMIME
+		verifySMIME(signature, signed, keys, security)
+	case "application/pgp-signature":
+		security.Scheme = schemePGP
+		verifyPGPDetached(signature, signed, keys, security)
+	default:
+		security.Detail = "unsupported signature type " + sigType
+	}
+	return signed
+}
+
+func verifySMIME(signature, content []byte, keys *securityKeys, security *Security) {
+	p7, err := pkcs7.Parse(signature)
+	if err != nil {
+		security.Trust = trustInvalid
+		security.Detail = err.Error()
+		return
+	}
+	if content != nil {
+		p7.Content = content
+	}
+	applySMIMESignature(p7, keys, security)
+}
+
+func applySMIMESignature(p7 *pkcs7.PKCS7, keys *securityKeys, security *Security) {
+	if signer := p7.GetOnlySigner(); signer != nil {
+		security.Signer = certificateIdentity(signer)
+	}
+	if err := p7.Verify(); err != nil {
+		security.Trust = trustInvalid
+		security.Detail = err.Error()
+		return
+	}
+	if err := p7.VerifyWithChain(keys.trustRoots()); err != nil {
+		security.Trust = trustUntrusted
+		security.Detail = err.Error()
+		return
+	}
+	security.Trust = trustTrusted
+}
+
+func certificateIdentity(cert *x509.Certificate) string {
+	name := cert.Subject.CommonName
+	email := ""
+	if len(cert.EmailAddresses) > 0 {
+		email = cert.EmailAddresses[0]
+	}
+	switch {
+	case name != "" && email != "" && name != email:
+		return name + " <" + email + ">"
+	case email != "":
+		return email
+	}
+	return name
+}
+
+func openPKCS7Mime(body []byte, encoding string, params map[string]string, keys *securityKeys, security *Security) []byte {
+	security.Scheme = schemeSMIME
+	if strings.EqualFold(strings.TrimSpace(encoding), "base64") {
+		decoded, err := base64.StdEncoding.DecodeString(stripWhitespace(string(body)))
+		if err != nil {
+			security.Detail = "invalid base64 content"
+			return nil
+		}
+		body = decoded
+	}
+	p7, err := pkcs7.Parse(body)
+	if err != nil {
+		security.Detail = err.Error()
+		return nil
+	}
+
+	smimeType := strings.ToLower(params["smime-type"])
+	if smimeType == "signed-data" || (smimeType == "" && len(p7.Signers) > 0) {
+		security.Signed = true
+		applySMIMESignature(p7, keys, security)
+		return p7.Content
+	}
+
+	security.Encrypted = true
+	if keys == nil || keys.smimeCert == nil {
+		security.Detail = "no S/MIME certificate configured to decrypt"
+		return nil
+	}
+	content, err := p7.Decrypt(keys.smimeCert, keys.smimeKey)
+	if err != nil {
+		security.Detail = "decrypt: " + err.Error()
+		return nil
+	}
+	security.Decrypted = true
+	return content
+}
+
+func verifyPGPDetached(signature, content []byte, keys *securityKeys, security *Security) {
+	var keyring openpgp.EntityList
+	if keys != nil {
+		keyring = keys.pgpKeyring
+	}
+	signer, err := openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(content), bytes.NewReader(signature), nil)
+	applyPGPSignature(signer, err, security)
+}
+
+func applyPGPSignature(signer *openpgp.Entity, err error, security *Security) {
+	if signer != nil {
+		security.Signer = entityIdentity(signer)
+	}
+	switch {
+	case errors.Is(err, pgperrors.ErrUnknownIssuer):
+		security.Trust = trustUnknown
+		security.Detail = "signing key is not in the configured keyring"
+	case err != nil:
+		security.Trust = trustInvalid
+		security.Detail = err.Error()
+	default:
+		security.Trust = trustTrusted
+	}
+}
+
+func entityIdentity(entity *openpgp.Entity) string {
+	if id := entity.PrimaryIdentity(); id != nil {
+		return id.Name
+	}
+	return fmt.Sprintf("%X", entity.PrimaryKey.KeyId)
+}
+
+func openPGPEncrypted(body []byte, boundary string, keys *securityKeys, security *Security) []byte {
+	security.Scheme = schemePGP
+	security.Encrypted = true
+	parts := splitMultipart(body, boundary)
+	if len(parts) < 2 {
+		security.Detail = "malformed multipart/encrypted message"
+		return nil
+	}
+	if keys == nil || len(keys.pgpKeyring.DecryptionKeys()) == 0 {
+		security.Detail = "no PGP secret key configured to decrypt"
+		return nil
+	}
+	_, armored := decodePartBody(parts[1])
+	block, err := armor.Decode(bytes.NewReader(armored))
+	if err != nil {
+		security.Detail = "read encrypted part: " + err.Error()
+		return nil
+	}
+	md, err := openpgp.ReadMessage(block.Body, keys.pgpKeyring, nil, nil)
+	if err != nil {
+		security.Detail = "decrypt: " + err.Error()
+		return nil
+	}
+	content, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		security.Detail = "decrypt: " + err.Error()
+		return nil
+	}
+	security.Decrypted = true
+	if md.IsSigned {
+		security.Signed = true
+		var signer *openpgp.Entity
+		if md.SignedBy != nil {
+			signer = md.SignedBy.Entity
+		}
+		sigErr := md.SignatureError
+		if md.SignedBy == nil {
+			sigErr = pgperrors.ErrUnknownIssuer
+		}
+		applyPGPSignature(signer, sigErr, security)
+	}
+	return content
+}
+
+func renderSecurity(s *Security) string {
+	if s == nil {
+		return ""
+	}
+	var badges []string
+	if s.Encrypted {
+		if s.Decrypted {
+			badges = append(badges, passBadgeStyle.Render("✓ decrypted"))
+		} else {
+			badges = append(badges, failBadgeStyle.Render("✗ encrypted, not decrypted"))
+		}
+	}
+	if s.Signed {
+		label := "signed"
+		if s.Signer != "" {
+			label += " by " + s.Signer
+		}
+		if s.Trust != "" {
+			label += " (" + s.Trust + ")"
+		}
+		switch s.Trust {
+		case trustTrusted:
+			badges = append(badges, passBadgeStyle.Render("✓ "+label))
+		case trustUntrusted, trustUnknown:
+			badges = append(badges, neutralBadgeStyle.Render("? "+label))
+		default:
+			badges = append(badges, failBadgeStyle.Render("✗ "+label))
+		}
+	}
+	line := strings.TrimRight("Secure:  "+s.Scheme+" "+strings.Join(badges, "  "), " ")
+	if s.Detail != "" {
+		line += "\n         " + s.Detail
+	}
+	return line
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/smallstep/pkcs7"
+)
+
+const secureHeader = "From: Alice <alice@acme.example>\r\n" +
+	"To: inbox@example.com\r\n" +
+	"Subject: Secure hello\r\n" +
+	"MIME-Version: 1.0\r\n"
+
+const secureContent = "Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"Top secret greetings\r\n"
+
+func smimeIdentity(t *testing.T) (*x509.Certificate, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Alice"},
+		EmailAddresses:        []string{"alice@acme.example"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	return cert, key
+}
+
+func wrapBase64(data []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var b strings.Builder
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\r\n")
+	return b.String()
+}
+
+func smimeSignedMessage(t *testing.T, cert *x509.Certificate, key *rsa.PrivateKey) string {
+	t.Helper()
+	sd, err := pkcs7.NewSignedData([]byte(secureContent))
+	if err != nil {
+		t.Fatalf("signed data: %v", err)
+	}
+	if err := sd.AddSigner(cert, key, pkcs7.SignerInfoConfig{}); err != nil {
+		t.Fatalf("add signer: %v", err)
+	}
+	sd.Detach()
+	signature, err := sd.Finish()
+	if err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	return secureHeader +
+		"Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\"; micalg=sha-256; boundary=\"sig\"\r\n" +
+		"\r\n" +
+		"--sig\r\n" + secureContent + "\r\n" +
+		"--sig\r\n" +
+		"Content-Type: application/pkcs7-signature; name=smime.p7s\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" + wrapBase64(signature) +
+		"--sig--\r\n"
+}
+
+func TestOpenSecureMessage_SMIMESignedTrusted(t *testing.T) {
+	cert, key := smimeIdentity(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	raw := smimeSignedMessage(t, cert, key)
+	opened, security := openSecureMessage([]byte(raw), &securityKeys{roots: roots})
+	if security == nil || !security.Signed || security.Trust != trustTrusted || security.Scheme != schemeSMIME {
+		t.Fatalf("security = %#v", security)
+	}
+	if security.Signer != "Alice <alice@acme.example>" {
+		t.Fatalf("signer = %q", security.Signer)
+	}
+
+	email, err := parseFullEmail(opened, "signed.eml")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !strings.Contains(email.Body, "Top secret greetings") || len(email.Attachments) != 0 {
+		t.Fatalf("body = %q attachments = %d", email.Body, len(email.Attachments))
+	}
+	if email.Subject != "Secure hello" {
+		t.Fatalf("subject = %q", email.Subject)
+	}
+}
+
+func TestOpenSecureMessage_SMIMEUntrustedAndTampered(t *testing.T) {
+	cert, key := smimeIdentity(t)
+	raw := smimeSignedMessage(t, cert, key)
+
+	_, security := openSecureMessage([]byte(raw), &securityKeys{roots: x509.NewCertPool()})
+	if security.Trust != trustUntrusted {
+		t.Fatalf("untrusted security = %#v", security)
+	}
+
+	tampered := strings.Replace(raw, "Top secret greetings", "Top secret greetingz", 1)
+	_, security = openSecureMessage([]byte(tampered), &securityKeys{})
+	if security.Trust != trustInvalid {
+		t.Fatalf("tampered security = %#v", security)
+	}
+}
+
+func TestOpenSecureMessage_SMIMEEncrypted(t *testing.T) {
+	cert, key := smimeIdentity(t)
+	encrypted, err := pkcs7.Encrypt([]byte(secureContent), []*x509.Certificate{cert})
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	raw := secureHeader +
+		"Content-Type: application/pkcs7-mime; smime-type=enveloped-data; name=smime.p7m\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" + wrapBase64(encrypted)
+
+	_, security := openSecureMessage([]byte(raw), &securityKeys{})
+	if !security.Encrypted || security.Decrypted || !strings.Contains(security.Detail, "no S/MIME certificate") {
+		t.Fatalf("without key security = %#v", security)
+	}
+
+	opened, security := openSecureMessage([]byte(raw), &securityKeys{smimeCert: cert, smimeKey: key})
+	if !security.Decrypted {
+		t.Fatalf("with key security = %#v", security)
+	}
+	email, err := parseFullEmail(opened, "encrypted.eml")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !strings.Contains(email.Body, "Top secret greetings") {
+		t.Fatalf("body = %q", email.Body)
+	}
+}
+
+func pgpEntity(t *testing.T) *openpgp.Entity {
+	t.Helper()
+	entity, err := openpgp.NewEntity("Alice", "", "alice@acme.example", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatalf("new entity: %v", err)
+	}
+	return entity
+}
+
+func TestOpenSecureMessage_PGPSigned(t *testing.T) {
+	entity := pgpEntity(t)
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, entity, strings.NewReader(secureContent), nil); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	raw := secureHeader +
+		"Content-Type: multipart/signed; protocol=\"application/pgp-signature\"; micalg=pgp-sha256; boundary=\"sig\"\r\n" +
+		"\r\n" +
+		"--sig\r\n" + secureContent + "\r\n" +
+		"--sig\r\n" +
+		"Content-Type: application/pgp-signature\r\n" +
+		"\r\n" + signature.String() + "\r\n" +
+		"--sig--\r\n"
+
+	_, security := openSecureMessage([]byte(raw), &securityKeys{pgpKeyring: openpgp.EntityList{entity}})
+	if security.Scheme != schemePGP || security.Trust != trustTrusted || !strings.Contains(security.Signer, "alice@acme.example") {
+		t.Fatalf("security = %#v", security)
+	}
+
+	_, security = openSecureMessage([]byte(raw), &securityKeys{})
+	if security.Trust != trustUnknown {
+		t.Fatalf("unknown key security = %#v", security)
+	}
+}
+
+func TestOpenSecureMessage_PGPEncrypted(t *testing.T) {
+	entity := pgpEntity(t)
+	var encrypted bytes.Buffer
+	armored, err := armor.Encode(&encrypted, "PGP MESSAGE", nil)
+	if err != nil {
+		t.Fatalf("armor: %v", err)
+	}
+	plaintext, err := openpgp.Encrypt(armored, openpgp.EntityList{entity}, entity, nil, nil)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	plaintext.Write([]byte(secureContent))
+	plaintext.Close()
+	armored.Close()
+
+	raw := secureHeader +
+		"Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\"; boundary=\"enc\"\r\n" +
+		"\r\n" +
+		"--enc\r\n" +
+		"Content-Type: application/pgp-encrypted\r\n" +
+		"\r\n" +
+		"Version: 1\r\n" +
+		"--enc\r\n" +
+		"Content-Type: application/octet-stream; name=encrypted.asc\r\n" +
+		"\r\n" + encrypted.String() + "\r\n" +
+		"--enc--\r\n"
+
+	opened, security := openSecureMessage([]byte(raw), &securityKeys{pgpKeyring: openpgp.EntityList{entity}})
+	if !security.Decrypted || !security.Signed || security.Trust != trustTrusted {
+		t.Fatalf("security = %#v", security)
+	}
+	email, err := parseFullEmail(opened, "pgp.eml")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !strings.Contains(email.Body, "Top secret greetings") {
+		t.Fatalf("body = %q", email.Body)
+	}
+
+	_, security = openSecureMessage([]byte(raw), &securityKeys{})
+	if security.Decrypted || !strings.Contains(security.Detail, "no PGP secret key") {
+		t.Fatalf("without key security = %#v", security)
+	}
+}
+
+func TestOpenSecureMessage_PlainMessageUntouched(t *testing.T) {
+	opened, security := openSecureMessage([]byte(notificationMIME), &securityKeys{})
+	if security != nil || string(opened) != notificationMIME {
+		t.Fatalf("security = %#v opened = %q", security, opened)
+	}
+}
+
+func TestRenderSecurity(t *testing.T) {
+	line := renderSecurity(&Security{Scheme: schemeSMIME, Signed: true, Signer: "Alice", Trust: trustUntrusted, Detail: "x509: unknown authority"})
+	if !strings.Contains(line, "signed by Alice (untrusted)") || !strings.Contains(line, "x509: unknown authority") {
+		t.Fatalf("line = %q", line)
+	}
+	if renderSecurity(nil) != "" {
+		t.Fatal("nil security should render nothing")
+	}
+}
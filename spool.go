@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/jhillyerd/enmime"
+)
+
+const (
+	// spoolThresholdBytes is the size above which a downloaded message stays
+	// on disk instead of being read into memory.
+	spoolThresholdBytes = 8 << 20
+	// spoolPartThreshold is the encoded size above which an attachment of a
+	// spooled message is read from the spool file on demand.
+	spoolPartThreshold = 1 << 20
+	// progressThresholdBytes is the download size from which the viewer
+	// shows a progress bar rather than a plain loading message.
+	progressThresholdBytes = 1 << 20
+
+	maxMIMEDepth = 20
+)
+
+// errNeedsMemory marks spooled messages that must be parsed in memory, such
+// as SES notifications and signed or encrypted mail.
+var errNeedsMemory = errors.New("message must be parsed in memory")
+
+var progressFilledStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
+
+type downloadProgressMsg struct {
+	key   string
+	done  int64
+	total int64
+}
+
+// partSource locates an encoded MIME body inside a spool file.
+type partSource struct {
+	path     string
+	offset   int64
+	length   int64
+	encoding string
+}
+
+// mimePart is a leaf part found by indexMIMEParts, with the byte range of its
+// encoded body.
+type mimePart struct {
+	header    textproto.MIMEHeader
+	bodyStart int64
+	bodyEnd   int64
+}
+
+// progressWriter counts bytes written and reports roughly every percent.
+type progressWriter struct {
+	done     int64
+	total    int64
+	reported int64
+	report   func(done, total int64)
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.done += int64(len(p))
+	step := max(w.total/100, 64<<10)
+	if w.report != nil && w.done-w.reported >= step {
+		w.reported = w.done
+		w.report(w.done, w.total)
+	}
+	return len(p), nil
+}
+
+// spoolObject downloads key into a temporary file in the spool folder and
+// returns its path and size. Objects over maxMessageBytes are rejected.
+func (m model) spoolObject(ctx context.Context, key string, progress func(done, total int64)) (string, int64, error) {
+	result, err := m.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(m.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	defer result.Body.Close()
+
+	f, err := os.CreateTemp(m.spoolDir, "message-*.eml")
+	if err != nil {
+		return "", 0, err
+	}
+	var src io.Reader = result.Body
+	if m.maxMessageBytes > 0 {
+		src = io.LimitReader(src, m.maxMessageBytes+1)
+	}
+	counter := &progressWriter{total: aws.ToInt64(result.ContentLength), report: progress}
+	n, err := io.Copy(io.MultiWriter(f, counter), src)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && m.maxMessageBytes > 0 && n > m.maxMessageBytes {
+		err = fmt.Errorf("message is larger than the %s limit (SMAILER_MAX_MESSAGE_BYTES)", formatBytes(m.maxMessageBytes))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", 0, err
+	}
+	return f.Name(), n, nil
+}
+
+// reportDownload returns a progress callback that forwards to the model's
+// download channel without ever blocking the fetch.
+func (m model) reportDownload(key string, size int64) func(done, total int64) {
+	if m.downloads == nil {
+		return nil
+	}
+	return func(done, total int64) {
+		if total <= 0 {
+			total = size
+		}
+		select {
+		case m.downloads <- downloadProgressMsg{key: key, done: done, total: total}:
+		default:
+		}
+	}
+}
+
+func waitForDownload(downloads <-chan downloadProgressMsg) tea.Cmd {
+	if downloads == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		return <-downloads
+	}
+}
+
+// parseSpooledEmail parses a message kept on disk. Large attachment bodies
+// are swapped for short placeholders before enmime sees the message, so only
+// the text parts are held in memory; the attachments point back into the
+// spool file instead.
+func parseSpooledEmail(path, key string) (*Email, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, 64)
+	n, _ := f.ReadAt(prefix, 0)
+	if looksLikeJSON(prefix[:n]) {
+		return nil, errNeedsMemory
+	}
+	header, parts, err := indexMIMEParts(f, 0, info.Size(), 0)
+	if err != nil {
+		return nil, err
+	}
+	if needsMemory(header) {
+		return nil, errNeedsMemory
+	}
+
+	var pieces []io.Reader
+	sources := make(map[string]partSource)
+	offset := int64(0)
+	for _, part := range parts {
+		if part.bodyEnd-part.bodyStart <= spoolPartThreshold || !spoolablePart(part.header) {
+			continue
+		}
+		token := fmt.Sprintf("smailer-spool-part-%d", len(sources)+1)
+		encoding := strings.ToLower(strings.TrimSpace(part.header.Get("Content-Transfer-Encoding")))
+		placeholder := token
+		if encoding == "base64" {
+			placeholder = base64.StdEncoding.EncodeToString([]byte(token))
+		}
+		pieces = append(pieces, io.NewSectionReader(f, offset, part.bodyStart-offset), strings.NewReader(placeholder))
+		offset = part.bodyEnd
+		sources[token] = partSource{path: path, offset: part.bodyStart, length: part.bodyEnd - part.bodyStart, encoding: encoding}
+	}
+	pieces = append(pieces, io.NewSectionReader(f, offset, info.Size()-offset))
+
+	env, err := enmime.ReadEnvelope(io.MultiReader(pieces...))
+	if err != nil {
+		return nil, err
+	}
+	email := emailFromEnvelope(env, key, sources)
+	email.Spool = path
+	return email, nil
+}
+
+// needsMemory reports whether a message's top-level type has to be opened
+// by openSecureMessage, which works on the whole message in memory.
+func needsMemory(header textproto.MIMEHeader) bool {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/signed", "multipart/encrypted", "application/pkcs7-mime", "application/x-pkcs7-mime":
+		return true
+	}
+	return false
+}
+
+func spoolablePart(header textproto.MIMEHeader) bool {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return !strings.HasPrefix(mediaType, "text/") && mediaType != "message/delivery-status" && mediaType != "message/feedback-report"
+}
+
+// indexMIMEParts walks the MIME entity between start and end without
+// loading bodies. It returns the entity's header and its leaf parts in order.
+func indexMIMEParts(r io.ReaderAt, start, end int64, depth int) (textproto.MIMEHeader, []mimePart, error) {
+	header, bodyStart, err := readPartHeader(r, start, end)
+	if err != nil {
+		return nil, nil, err
+	}
+	mediaType, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	boundary := params["boundary"]
+	if !strings.HasPrefix(mediaType, "multipart/") || boundary == "" || depth >= maxMIMEDepth {
+		return header, []mimePart{{header: header, bodyStart: bodyStart, bodyEnd: end}}, nil
+	}
+
+	ranges, err := partRanges(r, bodyStart, end, boundary)
+	if err != nil {
+		return nil, nil, err
+	}
+	var parts []mimePart
+	for _, span := range ranges {
+		_, children, err := indexMIMEParts(r, span[0], span[1], depth+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		parts = append(parts, children...)
+	}
+	return header, parts, nil
+}
+
+// readPartHeader reads the header block at start and returns it with the
+// offset of the first body byte.
+func readPartHeader(r io.ReaderAt, start, end int64) (textproto.MIMEHeader, int64, error) {
+	br := bufio.NewReader(io.NewSectionReader(r, start, end-start))
+	var block bytes.Buffer
+	offset := start
+	for {
+		line, err := br.ReadBytes('\n')
+		offset += int64(len(line))
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			break
+		}
+		block.Write(line)
+		if err != nil {
+			break
+		}
+	}
+	block.WriteString("\r\n")
+	header, err := textproto.NewReader(bufio.NewReader(&block)).ReadMIMEHeader()
+	if err != nil && len(header) == 0 {
+		return nil, 0, err
+	}
+	return header, offset, nil
+}
+
+// partRanges returns the byte range of each body part between boundary
+// delimiters. The line break before a delimiter belongs to the delimiter, as
+// RFC 2046 specifies, so it is left out of the preceding part.
+func partRanges(r io.ReaderAt, start, end int64, boundary string) ([][2]int64, error) {
+	delimiter := []byte("--" + boundary)
+	br := bufio.NewReaderSize(io.NewSectionReader(r, start, end-start), 64<<10)
+	var ranges [][2]int64
+	partStart := int64(-1)
+	offset := start
+	prevBreak := int64(0)
+	for {
+		line, err := br.ReadSlice('\n')
+		lineStart := offset
+		offset += int64(len(line))
+		long := false
+		for err == bufio.ErrBufferFull {
+			long = true
+			line, err = br.ReadSlice('\n')
+			offset += int64(len(line))
+		}
+		if !long && bytes.HasPrefix(line, delimiter) {
+			rest := bytes.TrimRight(line[len(delimiter):], " \t\r\n")
+			if len(rest) == 0 || bytes.Equal(rest, []byte("--")) {
+				if partStart >= 0 {
+					ranges = append(ranges, [2]int64{partStart, max(partStart, lineStart-prevBreak)})
+				}
+				if len(rest) > 0 {
+					return ranges, nil
+				}
+				partStart = offset
+			}
+		}
+		prevBreak = 0
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			prevBreak = 2
+		} else if bytes.HasSuffix(line, []byte("\n")) {
+			prevBreak = 1
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if partStart >= 0 && partStart < end {
+		ranges = append(ranges, [2]int64{partStart, end})
+	}
+	return ranges, nil
+}
+
+// open returns the decoded body of the part.
+func (s partSource) open() (io.ReadCloser, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	var body io.Reader = io.NewSectionReader(f, s.offset, s.length)
+	switch s.encoding {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{body, f}, nil
+}
+
+// inspect sniffs the decoded content type and measures the decoded size
+// without holding the body in memory.
+func (s partSource) inspect() (string, int64) {
+	rc, err := s.open()
+	if err != nil {
+		return "", s.length
+	}
+	defer rc.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(rc, head)
+	rest, _ := io.Copy(io.Discard, rc)
+	return sniffContentType(head[:n]), int64(n) + rest
+}
+
+// open returns the attachment content from memory or from the spool file.
+func (a Attachment) open() (io.ReadCloser, error) {
+	if a.Source != nil {
+		return a.Source.open()
+	}
+	return io.NopCloser(bytes.NewReader(a.Data)), nil
+}
+
+// spoolUsers counts the commands still reading each spool file, so that the
+// file of a body evicted meanwhile is removed only once they finish.
+var spoolUsers = struct {
+	sync.Mutex
+	refs    map[string]int
+	removed map[string]bool
+}{refs: make(map[string]int), removed: make(map[string]bool)}
+
+// holdSpool keeps the email's spool file on disk until release is called.
+// It must be called before the command that reads the file is returned.
+func holdSpool(e Email) (release func()) {
+	path := e.Spool
+	if path == "" {
+		return func() {}
+	}
+	spoolUsers.Lock()
+	spoolUsers.refs[path]++
+	spoolUsers.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			spoolUsers.Lock()
+			defer spoolUsers.Unlock()
+			spoolUsers.refs[path]--
+			if spoolUsers.refs[path] > 0 {
+				return
+			}
+			delete(spoolUsers.refs, path)
+			if spoolUsers.removed[path] {
+				delete(spoolUsers.removed, path)
+				os.Remove(path)
+			}
+		})
+	}
+}
+
+// removeSpool deletes the spool file of an email that is being replaced or
+// deleted, or marks it for deletion while a command still reads it.
+func removeSpool(e Email) {
+	if e.Spool == "" {
+		return
+	}
+	spoolUsers.Lock()
+	defer spoolUsers.Unlock()
+	if spoolUsers.refs[e.Spool] > 0 {
+		spoolUsers.removed[e.Spool] = true
+		return
+	}
+	os.Remove(e.Spool)
+}
+
+func renderDownloadProgress(done, total int64, width int) string {
+	barWidth := min(max(width-20, 10), 60)
+	filled := 0
+	if total > 0 {
+		filled = int(min(done, total) * int64(barWidth) / total)
+	}
+	bar := progressFilledStyle.Render(strings.Repeat("█", filled)) + helpStyle.Render(strings.Repeat("░", barWidth-filled))
+	label := fmt.Sprintf("Downloading %s", formatBytes(done))
+	if total > 0 {
+		label = fmt.Sprintf("Downloading %s of %s (%d%%)", formatBytes(done), formatBytes(total), min(done, total)*100/total)
+	}
+	return label + "\n" + bar
+}
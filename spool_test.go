@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// largeAttachmentMessage builds a multipart message with a text body, a
+// small attachment and a PDF attachment of the given size.
+func largeAttachmentMessage(pdf []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(pdf)
+	var lines []string
+	for len(encoded) > 76 {
+		lines = append(lines, encoded[:76])
+		encoded = encoded[76:]
+	}
+	lines = append(lines, encoded)
+	return "From: Alice <alice@example.com>\r\nTo: bob@example.com\r\nSubject: Big report\r\n" +
+		"MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=\"outer\"\r\n\r\n" +
+		"--outer\r\nContent-Type: multipart/alternative; boundary=\"inner\"\r\n\r\n" +
+		"--inner\r\nContent-Type: text/plain\r\n\r\nReport attached.\r\n" +
+		"--inner\r\nContent-Type: text/html\r\n\r\n<p>Report attached.</p>\r\n" +
+		"--inner--\r\n" +
+		"--outer\r\nContent-Type: text/csv\r\nContent-Disposition: attachment; filename=summary.csv\r\n\r\na,b\r\n1,2\r\n" +
+		"--outer\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=report.pdf\r\nContent-Transfer-Encoding: base64\r\n\r\n" +
+		strings.Join(lines, "\r\n") + "\r\n" +
+		"--outer--\r\n"
+}
+
+func testPDF(size int) []byte {
+	return append([]byte("%PDF-1.7\n"), bytes.Repeat([]byte("0123456789abcdef"), size/16)...)
+}
+
+func TestIndexMIMEParts_FindsLeafRanges(t *testing.T) {
+	raw := largeAttachmentMessage([]byte("%PDF-1.7 small"))
+	header, parts, err := indexMIMEParts(strings.NewReader(raw), 0, int64(len(raw)), 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(header.Get("Content-Type"), "multipart/mixed") {
+		t.Errorf("top-level Content-Type = %q", header.Get("Content-Type"))
+	}
+	if len(parts) != 4 {
+		t.Fatalf("got %d leaf parts, want 4", len(parts))
+	}
+	if body := raw[parts[0].bodyStart:parts[0].bodyEnd]; body != "Report attached." {
+		t.Errorf("text body = %q", body)
+	}
+	if body := raw[parts[2].bodyStart:parts[2].bodyEnd]; body != "a,b\r\n1,2" {
+		t.Errorf("csv body = %q", body)
+	}
+}
+
+func TestParseSpooledEmail_StreamsLargeAttachments(t *testing.T) {
+	pdf := testPDF(2 << 20)
+	path := filepath.Join(t.TempDir(), "message.eml")
+	if err := os.WriteFile(path, []byte(largeAttachmentMessage(pdf)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	email, err := parseSpooledEmail(path, "inbound/big")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if email.Spool != path || email.RawLoaded {
+		t.Errorf("spool = %q, rawLoaded = %v", email.Spool, email.RawLoaded)
+	}
+	if !strings.Contains(email.Body, "Report attached.") {
+		t.Errorf("body = %q", email.Body)
+	}
+	if len(email.Attachments) != 2 {
+		t.Fatalf("attachments = %#v", email.Attachments)
+	}
+	csv, report := email.Attachments[0], email.Attachments[1]
+	if csv.Source != nil || string(csv.Data) != "a,b\r\n1,2" {
+		t.Errorf("small attachment should stay in memory: %#v", csv)
+	}
+	if report.Source == nil || report.Data != nil {
+		t.Fatalf("large attachment should be read from the spool: %#v", report)
+	}
+	if report.Size != int64(len(pdf)) || report.Sniffed != "application/pdf" {
+		t.Errorf("size, sniffed = %d, %q", report.Size, report.Sniffed)
+	}
+
+	saved, err := saveAttachment(t.TempDir(), "report.pdf", report, 0o644)
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	content, _ := os.ReadFile(saved)
+	if !bytes.Equal(content, pdf) {
+		t.Errorf("saved %d bytes, want the original %d", len(content), len(pdf))
+	}
+}
+
+func TestParseSpooledEmail_SignedMessagesNeedMemory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signed.eml")
+	raw := "Content-Type: multipart/signed; boundary=x; protocol=\"application/pkcs7-signature\"\r\n\r\n--x\r\n\r\nhi\r\n--x--\r\n"
+	if err := os.WriteFile(path, []byte(raw), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseSpooledEmail(path, "k"); err != errNeedsMemory {
+		t.Fatalf("err = %v, want errNeedsMemory", err)
+	}
+}
+
+func spoolTestModel(t *testing.T, raw string) model {
+	mock := &mockS3{
+		getObjectFunc: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			return &s3.GetObjectOutput{
+				// Hide WriterTo so the body is copied in chunks, as it is from S3.
+				Body:          io.NopCloser(struct{ io.Reader }{strings.NewReader(raw)}),
+				ContentLength: aws.Int64(int64(len(raw))),
+			}, nil
+		},
+	}
+	m := newMockTestModel(mock)
+	m.spoolDir = t.TempDir()
+	return m
+}
+
+func TestDownloadAndParseEmail_SpoolsLargeMessages(t *testing.T) {
+	pdf := testPDF(spoolThresholdBytes)
+	raw := largeAttachmentMessage(pdf)
+	m := spoolTestModel(t, raw)
+
+	var reports []int64
+	email, err := m.downloadAndParseEmail(context.Background(), "inbound/big", func(done, total int64) {
+		if total != int64(len(raw)) {
+			t.Errorf("total = %d, want %d", total, len(raw))
+		}
+		reports = append(reports, done)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(reports) < 10 || reports[len(reports)-1] > int64(len(raw)) {
+		t.Errorf("progress reports = %d, last %v", len(reports), reports[len(reports)-1:])
+	}
+	if email.Spool == "" || email.Raw != nil {
+		t.Fatalf("large message should stay on disk: spool %q, %d raw bytes", email.Spool, len(email.Raw))
+	}
+
+	rc, err := m.openRawEmail(context.Background(), *email)
+	if err != nil {
+		t.Fatalf("openRawEmail: %v", err)
+	}
+	defer rc.Close()
+	streamed, _ := io.ReadAll(rc)
+	if string(streamed) != raw {
+		t.Errorf("raw stream differs from the original message")
+	}
+}
+
+func TestDownloadAndParseEmail_RemovesSpoolForSmallMessages(t *testing.T) {
+	m := spoolTestModel(t, buildMIMEEmail("a@example.com", "b@example.com", "Small", "hello", time.Now()))
+
+	email, err := m.downloadAndParseEmail(context.Background(), "inbound/small", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !email.RawLoaded || email.Spool != "" {
+		t.Errorf("small message should be held in memory: %#v", email)
+	}
+	if entries, _ := os.ReadDir(m.spoolDir); len(entries) != 0 {
+		t.Errorf("spool folder should be empty, found %d file(s)", len(entries))
+	}
+}
+
+func TestExports_RemoveSpoolOfUnloadedMessages(t *testing.T) {
+	raw := largeAttachmentMessage(testPDF(spoolThresholdBytes))
+	m := spoolTestModel(t, raw)
+	m.saveDir = t.TempDir()
+	e := Email{Key: "inbound/big", Subject: "Big"}
+	m.selectedEmail = &e
+
+	for name, cmd := range map[string]tea.Cmd{
+		"zip":         m.zipAttachments([]Email{e}),
+		"attachments": m.saveSelectedAttachments(),
+		"invite":      m.saveSelectedInvite(),
+	} {
+		cmd()
+		if entries, _ := os.ReadDir(m.spoolDir); len(entries) != 0 {
+			t.Errorf("%s left %d spool file(s)", name, len(entries))
+		}
+	}
+}
+
+func TestSpoolObject_RejectsOversizedMessages(t *testing.T) {
+	m := spoolTestModel(t, strings.Repeat("x", 100))
+	m.maxMessageBytes = 10
+
+	if _, _, err := m.spoolObject(context.Background(), "big", nil); err == nil {
+		t.Fatal("expected limit error")
+	}
+	if entries, _ := os.ReadDir(m.spoolDir); len(entries) != 0 {
+		t.Errorf("partial spool file should be removed, found %d file(s)", len(entries))
+	}
+}
+
+func TestRenderDownloadProgress(t *testing.T) {
+	got := renderDownloadProgress(5<<20, 10<<20, 80)
+	if !strings.Contains(got, "5.0 MB of 10.0 MB (50%)") {
+		t.Errorf("progress = %q", got)
+	}
+}
+
+func TestUpdate_DownloadProgressShowsBarWhileLoading(t *testing.T) {
+	m := newReadyTestModel()
+	m.state = viewState
+	m.selectedEmail = &Email{Key: "inbound/big"}
+	m.downloads = make(chan downloadProgressMsg, 1)
+
+	result, cmd := m.Update(downloadProgressMsg{key: "inbound/big", done: 2 << 20, total: 8 << 20})
+	if !strings.Contains(result.(model).viewerContent, "(25%)") {
+		t.Errorf("viewer = %q", result.(model).viewerContent)
+	}
+	if cmd == nil {
+		t.Error("expected the progress listener to be re-armed")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"math"
+	"strings"
 
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/table"
@@ -34,12 +35,28 @@ var (
 			Background(lipgloss.Color("235"))
 )
 
+// labelsColumn is the index of the Labels column, whose cells are coloured
+// separately from the rest of the row.
+const labelsColumn = 4
+
+var listColumns = []struct {
+	title      string
+	width      int
+	proportion float64
+	min        int
+}{
+	{"From", 40, 0.24, 20},
+	{"Subject", 50, 0.32, 26},
+	{"Date", 20, 0.16, 16},
+	{"Checks", 12, 0.08, 8},
+	{"Labels", 16, 0.12, 8},
+	{"Key", 18, 0.12, 12},
+}
+
 func (m *model) initComponents() {
-	columns := []table.Column{
-		{Title: "From", Width: 40},
-		{Title: "Subject", Width: 50},
-		{Title: "Date", Width: 20},
-		{Title: "Key", Width: 18},
+	columns := make([]table.Column, 0, len(listColumns))
+	for _, c := range listColumns {
+		columns = append(columns, table.Column{Title: c.title, Width: c.width})
 	}
 
 	t := table.New(
@@ -69,10 +86,41 @@ func (m *model) initComponents() {
 	m.bucketsList.SetShowHelp(false)
 
 	ti := textinput.New()
-	ti.Placeholder = "Filter by from, to, or subject"
+	ti.Placeholder = "Filter text or from:, to:, subject:, dmarc:fail, checks:fail, type:bounce"
 	ti.CharLimit = 256
 	ti.Width = max(20, m.width-20)
 	m.filterInput = ti
+
+	si := textinput.New()
+	si.Placeholder = "Search message"
+	si.CharLimit = 256
+	si.Width = max(20, m.width-20)
+	m.searchInput = si
+
+	mi := textinput.New()
+	mi.Placeholder = "Destination prefix, e.g. archive/{yyyy}/ or s3://bucket/prefix/"
+	mi.CharLimit = 256
+	mi.ShowSuggestions = true
+	mi.Width = max(20, m.width-20)
+	m.moveInput = mi
+
+	pi := textinput.New()
+	pi.Placeholder = "Purge query, e.g. older:30d from:@test.example"
+	pi.CharLimit = 256
+	pi.Width = max(20, m.width-20)
+	m.purgeInput = pi
+
+	ai := textinput.New()
+	ai.Placeholder = "Name, or empty to unassign"
+	ai.CharLimit = 128
+	ai.Width = max(20, m.width-20)
+	m.assignInput = ai
+
+	li := textinput.New()
+	li.Placeholder = "Labels, or +add -remove"
+	li.CharLimit = 256
+	li.Width = max(20, m.width-20)
+	m.labelInput = li
 }
 
 func (m *model) updateComponents() {
@@ -82,16 +130,14 @@ func (m *model) updateComponents() {
 	m.table.SetWidth(m.width - 4)
 	m.table.SetHeight(m.height - 6)
 
-	numColumns := 4
+	numColumns := len(listColumns)
 	borderWidth := numColumns + 1
 	availableContent := max(0, m.width-4-borderWidth)
-	proportions := []float64{0.28, 0.38, 0.18, 0.16}
-	mins := []int{22, 28, 16, 14}
 
 	var colWidths []int
 	sumWidth := 0
-	for i, p := range proportions {
-		w := max(mins[i], int(math.Round(p*float64(availableContent))))
+	for _, c := range listColumns {
+		w := max(c.min, int(math.Round(c.proportion*float64(availableContent))))
 		colWidths = append(colWidths, w)
 		sumWidth += w
 	}
@@ -103,29 +149,58 @@ func (m *model) updateComponents() {
 		}
 	}
 
-	newColumns := []table.Column{
-		{Title: "From", Width: colWidths[0]},
-		{Title: "Subject", Width: colWidths[1]},
-		{Title: "Date", Width: colWidths[2]},
-		{Title: "Key", Width: colWidths[3]},
+	newColumns := make([]table.Column, 0, numColumns)
+	for i, c := range listColumns {
+		newColumns = append(newColumns, table.Column{Title: c.title, Width: colWidths[i]})
 	}
 	m.table.SetColumns(newColumns)
 
 	m.bucketsList.SetWidth(m.width - 4)
 	m.bucketsList.SetHeight(m.height - 6)
+	switch m.state {
+	case trashState:
+		m.trashList.SetSize(m.width-4, m.height-6)
+	case versionsState:
+		m.versionsList.SetSize(m.width-4, m.height-6)
+	}
 	m.filterInput.Width = max(20, m.width-20)
+	m.searchInput.Width = max(20, m.width-20)
 }
 
 func (m *model) updateTableRows() {
 	rows := []table.Row{}
+	for i := range m.emails {
+		m.emails[i].Labels = m.emailLabels(m.emails[i])
+	}
 	m.visibleEmails = m.filteredEmails()
+	columns := m.table.Columns()
 	for _, e := range m.visibleEmails {
-		rows = append(rows, table.Row{
-			e.From,
+		from := e.fromDisplay()
+		if e.isFlagged() {
+			from = "⚑ " + from
+		}
+		if m.marked[e.Key] {
+			from = "● " + from
+		}
+		row := table.Row{
+			from,
 			e.Subject,
 			e.Date.Format("2006-01-02 15:04"),
+			e.Verdicts.summary(),
+			"",
 			shortKey(e.Key),
-		})
+		}
+		if len(columns) == len(row) {
+			if e.isUnread() {
+				for i := range row {
+					row[i] = boldCell(row[i], columns[i].Width)
+				}
+			}
+			row[labelsColumn] = labelCell(e.Labels, columns[labelsColumn].Width)
+		} else {
+			row[labelsColumn] = strings.Join(e.Labels, " ")
+		}
+		rows = append(rows, row)
 	}
 	m.table.SetRows(rows)
 	if len(rows) == 0 {
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/reflow/truncate"
+)
+
+// Object tags holding the shared triage state of a message.
+const (
+	readTag     = "smailer-read"
+	flaggedTag  = "smailer-flagged"
+	assigneeTag = "smailer-assignee"
+)
+
+type tagsUpdatedMsg struct {
+	key  string
+	tags map[string]string
+	err  error
+}
+
+// isUnread reports whether the message has not been opened by anyone. Emails
+// whose tags could not be read are treated as read so they are not all shown
+// as new.
+func (e Email) isUnread() bool {
+	return e.Tags != nil && e.Tags[readTag] != "true"
+}
+
+func (e Email) isFlagged() bool {
+	return e.Tags[flaggedTag] == "true"
+}
+
+func (e Email) assignee() string {
+	return e.Tags[assigneeTag]
+}
+
+// assigneeFromEnv is the name "@" offers by default: SMAILER_USER, or else
+// the login name.
+func assigneeFromEnv() string {
+	if name := strings.TrimSpace(os.Getenv("SMAILER_USER")); name != "" {
+		return name
+	}
+	return os.Getenv("USER")
+}
+
+// tagAccess remembers that the credentials may not read or write object
+// tags, so a bucket without tagging permission costs one denied call rather
+// than one per email. It is shared by the model's copies and the commands
+// they start.
+type tagAccess struct {
+	readDenied  atomic.Bool
+	writeDenied atomic.Bool
+}
+
+func (a *tagAccess) canRead() bool  { return a == nil || !a.readDenied.Load() }
+func (a *tagAccess) canWrite() bool { return a == nil || !a.writeDenied.Load() }
+
+// isAccessDenied reports whether S3 refused the call for lack of permission.
+func isAccessDenied(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "AccessDenied"
+}
+
+// isNotFound reports whether S3 found no object at the key.
+func isNotFound(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.ErrorCode() == "NotFound" || apiErr.ErrorCode() == "NoSuchKey"
+}
+
+// fetchTags reads the object's tags. It returns nil when they cannot be read,
+// for example without s3:GetObjectTagging permission, after which no more
+// reads are attempted.
+func (m model) fetchTags(ctx context.Context, key string) map[string]string {
+	if !m.tagAccess.canRead() {
+		return nil
+	}
+	output, err := m.s3Client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(m.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isAccessDenied(err) && m.tagAccess != nil {
+			m.tagAccess.readDenied.Store(true)
+		}
+		return nil
+	}
+	tags := make(map[string]string, len(output.TagSet))
+	for _, tag := range output.TagSet {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return tags
+}
+
+// fetchAllTags reads the tags of a page of emails concurrently, sharing
+// bulkSlots with the other operations that fan out over S3.
+func (m model) fetchAllTags(ctx context.Context, emails []Email) {
+	var wg sync.WaitGroup
+	for i := range emails {
+		wg.Add(1)
+		go func(e *Email) {
+			defer wg.Done()
+			if m.bulkSlots != nil {
+				m.bulkSlots <- struct{}{}
+				defer func() { <-m.bulkSlots }()
+			}
+			e.Tags = m.fetchTags(ctx, e.Key)
+		}(&emails[i])
+	}
+	wg.Wait()
+}
+
+// updateTags applies changes to the emails' tags, where an empty value
+// removes the tag. The list is updated straight away. The object's current
+// tags are re-read before writing, because PutObjectTagging replaces the
+// whole set and a teammate may have changed other tags since they loaded.
+func (m *model) updateTags(emails []Email, changes map[string]string) tea.Cmd {
+	var cmds []tea.Cmd
+	for _, e := range emails {
+		if current := m.findEmailByKey(e.Key); current != nil {
+			current.Tags = applyTagChanges(current.Tags, changes)
+		}
+		if m.selectedEmail != nil && m.selectedEmail.Key == e.Key {
+			m.selectedEmail.Tags = applyTagChanges(m.selectedEmail.Tags, changes)
+		}
+		cmds = append(cmds, m.writeTags(e.Key, changes))
+	}
+	m.updateTableRows()
+	return tea.Batch(cmds...)
+}
+
+func (m model) writeTags(key string, changes map[string]string) tea.Cmd {
+	return func() tea.Msg {
+		ctx := context.Background()
+		output, err := m.s3Client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+			Bucket: aws.String(m.bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return tagsUpdatedMsg{key: key, err: err}
+		}
+		tags := make(map[string]string, len(output.TagSet))
+		for _, tag := range output.TagSet {
+			tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+		tags = applyTagChanges(tags, changes)
+		_, err = m.s3Client.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+			Bucket:  aws.String(m.bucket),
+			Key:     aws.String(key),
+			Tagging: &types.Tagging{TagSet: tagSet(tags)},
+		})
+		if err != nil {
+			return tagsUpdatedMsg{key: key, err: err}
+		}
+		return tagsUpdatedMsg{key: key, tags: tags}
+	}
+}
+
+func applyTagChanges(tags, changes map[string]string) map[string]string {
+	updated := make(map[string]string, len(tags)+len(changes))
+	for k, v := range tags {
+		updated[k] = v
+	}
+	for k, v := range changes {
+		if v == "" {
+			delete(updated, k)
+		} else {
+			updated[k] = v
+		}
+	}
+	return updated
+}
+
+// tagSet orders tags by key so that writes are deterministic.
+func tagSet(tags map[string]string) []types.Tag {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	set := make([]types.Tag, 0, len(keys))
+	for _, k := range keys {
+		set = append(set, types.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+	return set
+}
+
+func (m *model) recordTags(msg tagsUpdatedMsg) {
+	if msg.err != nil {
+		if isAccessDenied(msg.err) && m.tagAccess != nil {
+			m.tagAccess.writeDenied.Store(true)
+		}
+		m.setStatus(fmt.Sprintf("Could not update tags on %s: %v", shortKey(msg.key), msg.err))
+		return
+	}
+	if e := m.findEmailByKey(msg.key); e != nil {
+		e.Tags = msg.tags
+		if m.selectedEmail != nil && m.selectedEmail.Key == msg.key {
+			m.selectedEmail.Tags = msg.tags
+		}
+		m.updateTableRows()
+	}
+}
+
+// toggleRead marks the targets read, or unread when they are all read.
+func (m *model) toggleRead(emails []Email) tea.Cmd {
+	value := ""
+	for _, e := range emails {
+		if e.isUnread() || e.Tags == nil {
+			value = "true"
+		}
+	}
+	if value == "" {
+		m.setStatus(fmt.Sprintf("Marked %d email(s) unread", len(emails)))
+	} else {
+		m.setStatus(fmt.Sprintf("Marked %d email(s) read", len(emails)))
+	}
+	return m.updateTags(emails, map[string]string{readTag: value})
+}
+
+// toggleFlag flags the targets, or clears the flag when they are all flagged.
+func (m *model) toggleFlag(emails []Email) tea.Cmd {
+	value := ""
+	for _, e := range emails {
+		if !e.isFlagged() {
+			value = "true"
+		}
+	}
+	if value == "" {
+		m.setStatus(fmt.Sprintf("Unflagged %d email(s)", len(emails)))
+	} else {
+		m.setStatus(fmt.Sprintf("Flagged %d email(s)", len(emails)))
+	}
+	return m.updateTags(emails, map[string]string{flaggedTag: value})
+}
+
+// tagTargets is the open email in the viewer, or the bulk targets in the
+// list.
+func (m model) tagTargets() []Email {
+	if m.state == viewState && m.selectedEmail != nil {
+		return []Email{*m.selectedEmail}
+	}
+	return m.bulkTargets()
+}
+
+// openAssign shows the "Assign to:" prompt, filled with the current assignee
+// or else the user's own name.
+func (m *model) openAssign() {
+	targets := m.tagTargets()
+	if len(targets) == 0 {
+		return
+	}
+	value := targets[0].assignee()
+	if value == "" {
+		value = m.userName
+	}
+	m.assignActive = true
+	m.assignInput.SetValue(value)
+	m.assignInput.CursorEnd()
+	m.assignInput.Focus()
+}
+
+func (m *model) assign(name string) tea.Cmd {
+	targets := m.tagTargets()
+	if name == "" {
+		m.setStatus(fmt.Sprintf("Unassigned %d email(s)", len(targets)))
+	} else {
+		m.setStatus(fmt.Sprintf("Assigned %d email(s) to %s", len(targets), name))
+	}
+	return m.updateTags(targets, map[string]string{assigneeTag: name})
+}
+
+// markOpenedRead tags an email read the first time it is opened. Once a
+// write has been denied it stops trying, rather than reporting the same
+// error on every email opened.
+func (m *model) markOpenedRead(e Email) tea.Cmd {
+	if !e.isUnread() || !m.tagAccess.canWrite() {
+		return nil
+	}
+	return m.updateTags([]Email{e}, map[string]string{readTag: "true"})
+}
+
+// renderTriage summarises the flag and assignee for the email header.
+func renderTriage(e Email) string {
+	var parts []string
+	if e.isFlagged() {
+		parts = append(parts, "⚑ Flagged")
+	}
+	if name := e.assignee(); name != "" {
+		parts = append(parts, "Assigned to "+name)
+	}
+	return strings.Join(parts, " | ")
+}
+
+// boldCell emboldens a table cell. The table truncates cells by counting
+// every byte of an escape sequence except ESC as a column, so the text is
+// shortened to leave room for them.
+func boldCell(value string, width int) string {
+	const on, off = "\x1b[1m", "\x1b[22m"
+	if lipgloss.NewStyle().Bold(true).Render("x") == "x" {
+		return value
+	}
+	room := width - (len(on) - 1) - (len(off) - 1)
+	if room < 1 {
+		return value
+	}
+	if lipgloss.Width(value) > room {
+		value = truncate.StringWithTail(value, uint(room), "…")
+	}
+	return on + value + off
+}
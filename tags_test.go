@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// taggingMock stores tags per key the way S3 does, replacing the whole set on
+// every put.
+type taggingMock struct {
+	mu   sync.Mutex
+	tags map[string][]types.Tag
+	puts int
+}
+
+func (t *taggingMock) install(mock *mockS3) *mockS3 {
+	mock.getTaggingFunc = func(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		return &s3.GetObjectTaggingOutput{TagSet: t.tags[aws.ToString(params.Key)]}, nil
+	}
+	mock.putTaggingFunc = func(ctx context.Context, params *s3.PutObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.PutObjectTaggingOutput, error) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.puts++
+		t.tags[aws.ToString(params.Key)] = params.Tagging.TagSet
+		return &s3.PutObjectTaggingOutput{}, nil
+	}
+	return mock
+}
+
+func (t *taggingMock) get(key, tag string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, set := range t.tags[key] {
+		if aws.ToString(set.Key) == tag {
+			return aws.ToString(set.Value)
+		}
+	}
+	return ""
+}
+
+func TestLoadEmails_ReadsTriageTags(t *testing.T) {
+	date := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tagging := &taggingMock{tags: map[string][]types.Tag{
+		"inbound/seen": {{Key: aws.String(readTag), Value: aws.String("true")}, {Key: aws.String(flaggedTag), Value: aws.String("true")}},
+	}}
+	mock := tagging.install(&mockS3{
+		listObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			return &s3.ListObjectsV2Output{Contents: []types.Object{
+				{Key: aws.String("inbound/seen"), LastModified: aws.Time(date)},
+				{Key: aws.String("inbound/new"), LastModified: aws.Time(date)},
+			}}, nil
+		},
+		getObjectFunc: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			return nil, context.Canceled
+		},
+	})
+	m := newMockTestModel(mock)
+
+	msg := m.loadEmails()().(emailsLoadedMsg)
+	if len(msg.emails) != 2 {
+		t.Fatalf("emails = %#v", msg.emails)
+	}
+	seen, fresh := msg.emails[0], msg.emails[1]
+	if seen.isUnread() || !seen.isFlagged() || !fresh.isUnread() || fresh.isFlagged() {
+		t.Fatalf("seen = %#v, fresh = %#v", seen.Tags, fresh.Tags)
+	}
+}
+
+func pageOfObjects(n int) func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	return func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+		var objects []types.Object
+		for i := 0; i < n; i++ {
+			objects = append(objects, types.Object{Key: aws.String(fmt.Sprintf("inbound/%d", i)), LastModified: aws.Time(time.Now())})
+		}
+		return &s3.ListObjectsV2Output{Contents: objects}, nil
+	}
+}
+
+func TestLoadEmails_FetchesTagsConcurrentlyWithinSlots(t *testing.T) {
+	var inFlight, most atomic.Int32
+	mock := &mockS3{
+		listObjectsV2Func: pageOfObjects(6),
+		getObjectFunc: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			return nil, context.Canceled
+		},
+		getTaggingFunc: func(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for old := most.Load(); n > old && !most.CompareAndSwap(old, n); old = most.Load() {
+			}
+			time.Sleep(20 * time.Millisecond)
+			return &s3.GetObjectTaggingOutput{}, nil
+		},
+	}
+	m := newMockTestModel(mock)
+	m.bulkSlots = make(chan struct{}, 2)
+
+	msg := m.loadEmails()().(emailsLoadedMsg)
+	if len(msg.emails) != 6 || msg.emails[5].Tags == nil {
+		t.Fatalf("emails = %#v", msg.emails)
+	}
+	if got := most.Load(); got != 2 {
+		t.Errorf("%d tag reads in flight, want the 2 slots used", got)
+	}
+}
+
+func TestLoadEmails_StopsReadingTagsAfterAccessDenied(t *testing.T) {
+	var reads atomic.Int32
+	mock := &mockS3{
+		listObjectsV2Func: pageOfObjects(4),
+		getObjectFunc: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			return nil, context.Canceled
+		},
+		getTaggingFunc: func(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+			reads.Add(1)
+			return nil, &smithy.GenericAPIError{Code: "AccessDenied", Message: "Access Denied"}
+		},
+	}
+	m := newMockTestModel(mock)
+	m.bulkSlots = make(chan struct{}, 1)
+	m.tagAccess = &tagAccess{}
+
+	m.loadEmails()()
+	m.loadEmails()()
+	if got := reads.Load(); got != 1 {
+		t.Errorf("GetObjectTagging called %d times, want 1", got)
+	}
+}
+
+func TestIsUnread_UnknownTagsCountAsRead(t *testing.T) {
+	if (Email{}).isUnread() {
+		t.Error("an email whose tags were not read should not be shown as unread")
+	}
+	if !(Email{Tags: map[string]string{}}).isUnread() {
+		t.Error("an untagged email should be unread")
+	}
+}
+
+func TestStateFilters(t *testing.T) {
+	emails := []Email{
+		{Key: "a", Tags: map[string]string{}},
+		{Key: "b", Tags: map[string]string{readTag: "true", flaggedTag: "true", assigneeTag: "Sam"}},
+	}
+	cases := map[string]string{
+		"is:unread":            "a",
+		"is:read":              "b",
+		"is:flagged":           "b",
+		"is:unassigned":        "a",
+		"assignee:sam":         "b",
+		"is:unread is:flagged": "",
+	}
+	for query, want := range cases {
+		terms, text := parseFilterQuery(query)
+		var got []string
+		for _, e := range emails {
+			if emailMatchesFilter(e, terms, text) {
+				got = append(got, e.Key)
+			}
+		}
+		if strings.Join(got, ",") != want {
+			t.Errorf("%s matched %v, want %q", query, got, want)
+		}
+	}
+}
+
+func TestOpenEmail_MarksReadKeepingOtherTags(t *testing.T) {
+	tagging := &taggingMock{tags: map[string][]types.Tag{
+		"inbound/a": {{Key: aws.String("team"), Value: aws.String("billing")}},
+	}}
+	m := bulkTestModel(tagging.install(&mockS3{}))
+	m.emails[0].Tags = map[string]string{}
+	m.emails[0].BodyLoaded = true
+	m.updateTableRows()
+
+	m, cmd := m.openEmail(0)
+	if m.findEmailByKey("inbound/a").isUnread() {
+		t.Fatal("opening should mark the email read straight away")
+	}
+	for _, msg := range runBatch(cmd) {
+		if _, ok := msg.(tagsUpdatedMsg); ok {
+			result, _ := m.Update(msg)
+			m = result.(model)
+		}
+	}
+	if tagging.get("inbound/a", readTag) != "true" || tagging.get("inbound/a", "team") != "billing" {
+		t.Fatalf("tags = %#v", tagging.tags["inbound/a"])
+	}
+
+	m.state = listState
+	_, again := m.openEmail(0)
+	runBatch(again)
+	if tagging.puts != 1 {
+		t.Errorf("a read email should not be tagged again: %d puts", tagging.puts)
+	}
+}
+
+func TestMarkOpenedRead_StopsAfterPermissionError(t *testing.T) {
+	var puts int
+	mock := &mockS3{
+		getTaggingFunc: func(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+			return &s3.GetObjectTaggingOutput{}, nil
+		},
+		putTaggingFunc: func(ctx context.Context, params *s3.PutObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.PutObjectTaggingOutput, error) {
+			puts++
+			return nil, &smithy.GenericAPIError{Code: "AccessDenied", Message: "Access Denied"}
+		},
+	}
+	m := bulkTestModel(mock)
+	m.tagAccess = &tagAccess{}
+	for i := range m.emails {
+		m.emails[i].Tags = map[string]string{}
+		m.emails[i].BodyLoaded = true
+	}
+	m.updateTableRows()
+
+	m, cmd := m.openEmail(0)
+	for _, msg := range runBatch(cmd) {
+		if _, ok := msg.(tagsUpdatedMsg); ok {
+			result, _ := m.Update(msg)
+			m = result.(model)
+		}
+	}
+	if puts != 1 || !strings.Contains(m.statusMessage, "Could not update tags") {
+		t.Fatalf("puts = %d, status = %q", puts, m.statusMessage)
+	}
+
+	m.state = listState
+	_, again := m.openEmail(1)
+	runBatch(again)
+	if puts != 1 {
+		t.Errorf("opening another email should not retry a denied write: %d puts", puts)
+	}
+}
+
+func TestFlagAndAssign_ApplyToMarkedEmails(t *testing.T) {
+	tagging := &taggingMock{tags: map[string][]types.Tag{}}
+	m := bulkTestModel(tagging.install(&mockS3{}))
+	m.userName = "sam"
+	m.marked = map[string]bool{"inbound/a": true, "inbound/c": true}
+
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("f")})
+	m = result.(model)
+	for _, msg := range runBatch(cmd) {
+		result, _ = m.Update(msg)
+		m = result.(model)
+	}
+	if m.statusMessage != "Flagged 2 email(s)" || tagging.get("inbound/c", flaggedTag) != "true" || tagging.get("inbound/b", flaggedTag) != "" {
+		t.Fatalf("status = %q, tags = %#v", m.statusMessage, tagging.tags)
+	}
+	if !strings.HasPrefix(m.table.Rows()[0][0], "● ⚑ ") {
+		t.Errorf("row = %q", m.table.Rows()[0][0])
+	}
+
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("@")})
+	m = result.(model)
+	if !m.assignActive || m.assignInput.Value() != "sam" {
+		t.Fatalf("assign prompt = %v %q", m.assignActive, m.assignInput.Value())
+	}
+	result, cmd = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = result.(model)
+	for _, msg := range runBatch(cmd) {
+		result, _ = m.Update(msg)
+		m = result.(model)
+	}
+	if tagging.get("inbound/a", assigneeTag) != "sam" || tagging.get("inbound/a", flaggedTag) != "true" {
+		t.Fatalf("tags = %#v", tagging.tags["inbound/a"])
+	}
+
+	result, cmd = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("f")})
+	m = result.(model)
+	runBatch(cmd)
+	if m.statusMessage != "Unflagged 2 email(s)" || tagging.get("inbound/a", flaggedTag) != "" {
+		t.Errorf("status = %q, tags = %#v", m.statusMessage, tagging.tags["inbound/a"])
+	}
+}
+
+func TestTagUpdateFailureSetsStatus(t *testing.T) {
+	m := bulkTestModel(&mockS3{})
+
+	msg := runBatch(m.toggleRead(m.bulkTargets()))[0]
+	result, _ := m.Update(msg)
+	if !strings.Contains(result.(model).statusMessage, "Could not update tags") {
+		t.Errorf("status = %q", result.(model).statusMessage)
+	}
+}
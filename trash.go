@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	defaultTrashPrefix = "trash/"
+	undoWindow         = 8 * time.Second
+)
+
+// undoAction is the most recent single delete, which "u" reverses until the
+// undo window closes.
+type undoAction struct {
+	id            int
+	key           string
+	trashKey      string
+	markerVersion string
+	email         Email
+	status        string
+}
+
+type undoExpiredMsg struct {
+	id int
+}
+
+// emailRestoredMsg reports a message brought back from the trash, from behind
+// a delete marker or from an earlier version.
+type emailRestoredMsg struct {
+	trashKey string
+	email    Email
+	err      error
+}
+
+type trashLoadedMsg struct {
+	items []list.Item
+	err   error
+}
+
+type trashDeletedMsg struct {
+	trashKey     string
+	versionsKept bool
+	err          error
+}
+
+// trashItem is a message waiting in the trash. Its date is when it was
+// deleted, since the copy into the trash sets LastModified.
+type trashItem struct {
+	trashKey string
+	key      string
+	deleted  time.Time
+	size     int64
+}
+
+func (i trashItem) Title() string { return i.key }
+func (i trashItem) Description() string {
+	return fmt.Sprintf("deleted %s, %s", i.deleted.Local().Format("2006-01-02 15:04"), formatBytes(i.size))
+}
+func (i trashItem) FilterValue() string { return i.key }
+
+// trashPrefixFromEnv reads SMAILER_TRASH_PREFIX, where deleted messages are
+// kept until they are restored or deleted for good. "none" deletes straight
+// away, which still leaves an undoable delete marker on versioned buckets.
+func trashPrefixFromEnv() string {
+	raw, ok := os.LookupEnv("SMAILER_TRASH_PREFIX")
+	if !ok {
+		return defaultTrashPrefix
+	}
+	raw = strings.TrimLeft(strings.TrimSpace(raw), "/")
+	if raw == "" || strings.EqualFold(raw, "none") {
+		return ""
+	}
+	return strings.TrimRight(raw, "/") + "/"
+}
+
+// inTrash reports whether key is itself a trashed copy, so that a prefix
+// containing the trash does not list it as mail.
+func (m model) inTrash(key string) bool {
+	return m.trashPrefix != "" && strings.HasPrefix(key, m.trashPrefix)
+}
+
+// removeEmail soft deletes key by moving it into the trash. Without a trash
+// prefix the object is deleted; on a versioned bucket the delete marker's
+// version is returned so the delete can still be undone.
+func (m model) removeEmail(ctx context.Context, key string) (trashKey, markerVersion string, err error) {
+	if m.trashPrefix != "" && !m.inTrash(key) {
+		trashKey = m.trashPrefix + key
+		return trashKey, "", m.moveObject(ctx, key, trashKey)
+	}
+	output, err := m.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(m.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	if output != nil && aws.ToBool(output.DeleteMarker) {
+		markerVersion = aws.ToString(output.VersionId)
+	}
+	return "", markerVersion, nil
+}
+
+// offerUndo records a completed delete and starts the undo window.
+func (m *model) offerUndo(msg emailDeletedMsg, email Email) tea.Cmd {
+	if msg.trashKey == "" && msg.markerVersion == "" {
+		m.undo = nil
+		m.setStatus("Email deleted")
+		return nil
+	}
+	evictBody(&email)
+	status := "Email deleted (u: undo)"
+	if msg.trashKey != "" {
+		status = "Moved to trash (u: undo, T: open trash)"
+	}
+	m.undoSeq++
+	m.undo = &undoAction{
+		id:            m.undoSeq,
+		key:           msg.key,
+		trashKey:      msg.trashKey,
+		markerVersion: msg.markerVersion,
+		email:         email,
+		status:        status,
+	}
+	m.setStatus(status)
+	id := m.undoSeq
+	return tea.Tick(undoWindow, func(time.Time) tea.Msg { return undoExpiredMsg{id: id} })
+}
+
+func (m *model) expireUndo(msg undoExpiredMsg) {
+	if m.undo == nil || m.undo.id != msg.id {
+		return
+	}
+	if m.statusMessage == m.undo.status {
+		m.setStatus("Email deleted")
+	}
+	m.undo = nil
+}
+
+// undoDelete reverses the last delete: a trashed message is moved back and a
+// delete marker is removed to expose the previous version.
+func (m *model) undoDelete() tea.Cmd {
+	if m.undo == nil {
+		m.setStatus("Nothing to undo")
+		return nil
+	}
+	undo := *m.undo
+	m.undo = nil
+	m.setStatus("Restoring " + shortKey(undo.key) + "...")
+	return func() tea.Msg {
+		ctx := context.Background()
+		var err error
+		if undo.trashKey != "" {
+			err = m.moveObject(ctx, undo.trashKey, undo.key)
+		} else {
+			_, err = m.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket:    aws.String(m.bucket),
+				Key:       aws.String(undo.key),
+				VersionId: aws.String(undo.markerVersion),
+			})
+		}
+		return emailRestoredMsg{trashKey: undo.trashKey, email: undo.email, err: err}
+	}
+}
+
+// openTrash switches to the trash view and lists what is in it.
+func (m *model) openTrash() tea.Cmd {
+	if m.trashPrefix == "" {
+		m.setStatus("Trash is off; set SMAILER_TRASH_PREFIX to keep deleted mail")
+		return nil
+	}
+	m.state = trashState
+	m.trashConfirm = false
+	m.loading = true
+	m.trashList = list.New(nil, list.NewDefaultDelegate(), m.width-4, m.height-6)
+	m.trashList.Title = "Trash: " + m.trashPrefix + m.prefix
+	m.trashList.SetShowHelp(false)
+	m.trashList.SetFilteringEnabled(false)
+	return tea.Batch(m.loadTrash(), m.spinner.Tick)
+}
+
+// loadTrash lists every trashed copy of a message under the current prefix,
+// most recently deleted first.
+func (m model) loadTrash() tea.Cmd {
+	return func() tea.Msg {
+		ctx := context.Background()
+		var trashed []trashItem
+		var continuation *string
+		for {
+			page, err := m.s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+				Bucket:            aws.String(m.bucket),
+				Prefix:            aws.String(m.trashPrefix + m.prefix),
+				ContinuationToken: continuation,
+			})
+			if err != nil {
+				return trashLoadedMsg{err: err}
+			}
+			for _, obj := range page.Contents {
+				trashKey := aws.ToString(obj.Key)
+				trashed = append(trashed, trashItem{
+					trashKey: trashKey,
+					key:      strings.TrimPrefix(trashKey, m.trashPrefix),
+					deleted:  aws.ToTime(obj.LastModified),
+					size:     aws.ToInt64(obj.Size),
+				})
+			}
+			if !aws.ToBool(page.IsTruncated) || page.NextContinuationToken == nil {
+				break
+			}
+			continuation = page.NextContinuationToken
+		}
+		sort.SliceStable(trashed, func(i, j int) bool {
+			return trashed[i].deleted.After(trashed[j].deleted)
+		})
+		items := make([]list.Item, 0, len(trashed))
+		for _, t := range trashed {
+			items = append(items, t)
+		}
+		return trashLoadedMsg{items: items}
+	}
+}
+
+// restoreTrashed moves a trashed message back to its original key and reads
+// its summary so it can rejoin the list. It refuses when something has since
+// been written to that key rather than overwrite it.
+func (m model) restoreTrashed(t trashItem) tea.Cmd {
+	return func() tea.Msg {
+		ctx := context.Background()
+		_, err := m.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(m.bucket),
+			Key:    aws.String(t.key),
+		})
+		if err == nil {
+			return emailRestoredMsg{trashKey: t.trashKey, err: fmt.Errorf("%s already exists; the trashed copy was kept", shortKey(t.key))}
+		}
+		if !isNotFound(err) {
+			return emailRestoredMsg{trashKey: t.trashKey, err: err}
+		}
+		if err := m.moveObject(ctx, t.trashKey, t.key); err != nil {
+			return emailRestoredMsg{trashKey: t.trashKey, err: err}
+		}
+		obj := types.Object{Key: aws.String(t.key), LastModified: aws.Time(t.deleted), Size: aws.Int64(t.size)}
+		email, err := m.fetchEmailSummary(ctx, obj)
+		if err != nil {
+			email = fallbackEmailSummary(obj)
+		}
+		return emailRestoredMsg{trashKey: t.trashKey, email: *email}
+	}
+}
+
+// deleteTrashed removes a trashed message for good. On a versioned bucket a
+// plain delete only hides it behind a delete marker, so every version and
+// marker of the trash key is deleted. Without permission to list versions it
+// falls back to a plain delete and reports that older versions may remain.
+func (m model) deleteTrashed(t trashItem) tea.Cmd {
+	return func() tea.Msg {
+		ctx := context.Background()
+		versions, err := m.keyVersions(ctx, t.trashKey)
+		if isAccessDenied(err) {
+			_, err = m.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(m.bucket),
+				Key:    aws.String(t.trashKey),
+			})
+			return trashDeletedMsg{trashKey: t.trashKey, versionsKept: true, err: err}
+		}
+		if err != nil {
+			return trashDeletedMsg{trashKey: t.trashKey, err: err}
+		}
+		for _, versionID := range versions {
+			if _, err := m.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket:    aws.String(m.bucket),
+				Key:       aws.String(t.trashKey),
+				VersionId: aws.String(versionID),
+			}); err != nil {
+				return trashDeletedMsg{trashKey: t.trashKey, err: err}
+			}
+		}
+		return trashDeletedMsg{trashKey: t.trashKey}
+	}
+}
+
+// keyVersions lists the version IDs of every version and delete marker of
+// key. An unversioned bucket reports the single "null" version.
+func (m model) keyVersions(ctx context.Context, key string) ([]string, error) {
+	var ids []string
+	input := &s3.ListObjectVersionsInput{
+		Bucket: aws.String(m.bucket),
+		Prefix: aws.String(key),
+	}
+	for {
+		page, err := m.s3Client.ListObjectVersions(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range page.Versions {
+			if aws.ToString(v.Key) == key {
+				ids = append(ids, aws.ToString(v.VersionId))
+			}
+		}
+		for _, marker := range page.DeleteMarkers {
+			if aws.ToString(marker.Key) == key {
+				ids = append(ids, aws.ToString(marker.VersionId))
+			}
+		}
+		if !aws.ToBool(page.IsTruncated) {
+			return ids, nil
+		}
+		input.KeyMarker = page.NextKeyMarker
+		input.VersionIdMarker = page.NextVersionIdMarker
+	}
+}
+
+func (m *model) recordRestored(msg emailRestoredMsg) {
+	if msg.err != nil {
+		m.setStatus("Restore failed: " + msg.err.Error())
+		return
+	}
+	var labelErr error
+	if msg.trashKey != "" {
+		labelErr = m.labels.move(m.bucket, msg.trashKey, m.bucket, msg.email.Key)
+	}
+	if current := m.findEmailByKey(msg.email.Key); current != nil {
+		evictBody(current)
+	}
+	m.emails = mergeEmailsByKey(m.emails, []Email{msg.email})
+	sort.SliceStable(m.emails, func(i, j int) bool {
+		return m.emails[i].Date.After(m.emails[j].Date)
+	})
+	m.updateTableRows()
+	m.removeTrashItem(msg.trashKey)
+	m.setStatus("Restored " + shortKey(msg.email.Key))
+	if labelErr != nil {
+		m.setStatus("Labels not moved: " + labelErr.Error())
+	}
+}
+
+func (m *model) removeTrashItem(trashKey string) {
+	if trashKey == "" {
+		return
+	}
+	for i, it := range m.trashList.Items() {
+		if t, ok := it.(trashItem); ok && t.trashKey == trashKey {
+			m.trashList.RemoveItem(i)
+			return
+		}
+	}
+}
+
+func (m model) selectedTrashItem() (trashItem, bool) {
+	t, ok := m.trashList.SelectedItem().(trashItem)
+	return t, ok
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestTrashPrefixFromEnv(t *testing.T) {
+	cases := map[string]string{"": "", "none": "", "deleted": "deleted/", "/bin/": "bin/"}
+	for raw, want := range cases {
+		t.Setenv("SMAILER_TRASH_PREFIX", raw)
+		if got := trashPrefixFromEnv(); got != want {
+			t.Errorf("%q: got %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestDeleteEmail_MovesToTrashAndUndoRestores(t *testing.T) {
+	var copies, deletes []string
+	mock := &mockS3{
+		copyObjectFunc: func(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+			copies = append(copies, aws.ToString(params.CopySource)+" -> "+aws.ToString(params.Key))
+			return copiedETag(`"e1"`), nil
+		},
+		headObjectFunc: headETag(`"e1"`),
+		deleteObjectFunc: func(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+			deletes = append(deletes, aws.ToString(params.Key))
+			return &s3.DeleteObjectOutput{}, nil
+		},
+	}
+	m := bulkTestModel(mock)
+	m.trashPrefix = "trash/"
+	m.state = confirmDeleteState
+	m.previousState = listState
+	m.selectedEmail = &m.emails[1]
+
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")})
+	m = result.(model)
+	result, tick := m.Update(cmd())
+	m = result.(model)
+
+	if len(copies) != 1 || copies[0] != "test-bucket/inbound/b -> trash/inbound/b" || deletes[0] != "inbound/b" {
+		t.Fatalf("copies = %v, deletes = %v", copies, deletes)
+	}
+	if len(m.emails) != 2 || m.undo == nil || tick == nil {
+		t.Fatalf("emails = %d, undo = %#v", len(m.emails), m.undo)
+	}
+	if !strings.Contains(m.statusMessage, "u: undo") {
+		t.Errorf("status = %q", m.statusMessage)
+	}
+
+	result, cmd = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("u")})
+	m = result.(model)
+	result, _ = m.Update(cmd())
+	m = result.(model)
+
+	if copies[1] != "test-bucket/trash/inbound/b -> inbound/b" || deletes[1] != "trash/inbound/b" {
+		t.Fatalf("copies = %v, deletes = %v", copies, deletes)
+	}
+	if len(m.emails) != 3 || m.emails[1].Key != "inbound/b" || m.emails[1].RawLoaded {
+		t.Fatalf("restored emails = %#v", m.emails)
+	}
+	if m.statusMessage != "Restored "+shortKey("inbound/b") {
+		t.Errorf("status = %q", m.statusMessage)
+	}
+}
+
+func TestUndoDelete_RemovesDeleteMarkerOnVersionedBucket(t *testing.T) {
+	var undone *s3.DeleteObjectInput
+	mock := &mockS3{
+		deleteObjectFunc: func(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+			if params.VersionId != nil {
+				undone = params
+				return &s3.DeleteObjectOutput{}, nil
+			}
+			return &s3.DeleteObjectOutput{DeleteMarker: aws.Bool(true), VersionId: aws.String("marker-1")}, nil
+		},
+	}
+	m := bulkTestModel(mock)
+	m.state = confirmDeleteState
+	m.previousState = listState
+	m.selectedEmail = &m.emails[0]
+
+	msg := m.deleteEmail()().(emailDeletedMsg)
+	if msg.markerVersion != "marker-1" || msg.trashKey != "" {
+		t.Fatalf("msg = %#v", msg)
+	}
+	result, _ := m.Update(msg)
+	m = result.(model)
+	m.Update(m.undoDelete()())
+
+	if undone == nil || aws.ToString(undone.Key) != "inbound/a" || aws.ToString(undone.VersionId) != "marker-1" {
+		t.Fatalf("undo delete = %#v", undone)
+	}
+}
+
+func TestUndoExpired_ClosesWindow(t *testing.T) {
+	m := newReadyTestModel()
+	m.undo = &undoAction{id: 2, key: "k", trashKey: "trash/k", status: "Moved to trash (u: undo, T: open trash)"}
+	m.statusMessage = m.undo.status
+
+	result, _ := m.Update(undoExpiredMsg{id: 1})
+	if result.(model).undo == nil {
+		t.Fatal("a stale expiry should not close a newer undo window")
+	}
+	result, _ = m.Update(undoExpiredMsg{id: 2})
+	m = result.(model)
+	if m.undo != nil || m.statusMessage != "Email deleted" {
+		t.Fatalf("undo = %#v, status = %q", m.undo, m.statusMessage)
+	}
+	if m.undoDelete() != nil || m.statusMessage != "Nothing to undo" {
+		t.Errorf("status = %q", m.statusMessage)
+	}
+}
+
+func TestTrashView_ListsRestoresAndDeletes(t *testing.T) {
+	deletedAt := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	var removed []string
+	mock := &mockS3{
+		listObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			if aws.ToString(params.Prefix) != "trash/inbound/" {
+				t.Errorf("prefix = %q", aws.ToString(params.Prefix))
+			}
+			return &s3.ListObjectsV2Output{Contents: []types.Object{
+				{Key: aws.String("trash/inbound/old"), LastModified: aws.Time(deletedAt)},
+				{Key: aws.String("trash/inbound/new"), LastModified: aws.Time(deletedAt.Add(time.Hour))},
+			}}, nil
+		},
+		copyObjectFunc: func(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+			return copiedETag(`"e1"`), nil
+		},
+		headObjectFunc: trashedOnly(`"e1"`),
+		listVersionsFunc: func(ctx context.Context, params *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+			return &s3.ListObjectVersionsOutput{
+				Versions: []types.ObjectVersion{
+					{Key: aws.String("trash/inbound/old"), VersionId: aws.String("v2")},
+					{Key: aws.String("trash/inbound/old"), VersionId: aws.String("v1")},
+					{Key: aws.String("trash/inbound/older"), VersionId: aws.String("v9")},
+				},
+				DeleteMarkers: []types.DeleteMarkerEntry{{Key: aws.String("trash/inbound/old"), VersionId: aws.String("m1")}},
+			}, nil
+		},
+		deleteObjectFunc: func(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+			removed = append(removed, aws.ToString(params.Key)+"@"+aws.ToString(params.VersionId))
+			return &s3.DeleteObjectOutput{}, nil
+		},
+		getObjectFunc: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			body := buildMIMEEmail("a@example.com", "b@example.com", "Back again", "hi", deletedAt)
+			return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(body))}, nil
+		},
+	}
+	m := bulkTestModel(mock)
+	m.trashPrefix = "trash/"
+
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("T")})
+	m = result.(model)
+	if m.state != trashState {
+		t.Fatalf("state = %v", m.state)
+	}
+	result, _ = m.Update(m.loadTrash()())
+	m = result.(model)
+	first, _ := m.selectedTrashItem()
+	if len(m.trashList.Items()) != 2 || first.key != "inbound/new" {
+		t.Fatalf("items = %#v", m.trashList.Items())
+	}
+
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")})
+	m = result.(model)
+	result, _ = m.Update(cmd())
+	m = result.(model)
+	if len(m.trashList.Items()) != 1 || m.findEmailByKey("inbound/new") == nil || m.findEmailByKey("inbound/new").Subject != "Back again" {
+		t.Fatalf("restore left items = %d, emails = %#v", len(m.trashList.Items()), m.emails)
+	}
+
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("d")})
+	m = result.(model)
+	result, cmd = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")})
+	m = result.(model)
+	result, _ = m.Update(cmd())
+	m = result.(model)
+	if got := strings.Join(removed[len(removed)-3:], " "); len(m.trashList.Items()) != 0 || got != "trash/inbound/old@v2 trash/inbound/old@v1 trash/inbound/old@m1" {
+		t.Fatalf("items = %d, removed = %v", len(m.trashList.Items()), removed)
+	}
+}
+
+// trashedOnly reports objects under trash/ with the given ETag and every
+// other key as missing, so restores find their destination free.
+func trashedOnly(etag string) func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	return func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+		if !strings.HasPrefix(aws.ToString(params.Key), "trash/") {
+			return nil, &types.NotFound{}
+		}
+		return &s3.HeadObjectOutput{ETag: aws.String(etag), ContentLength: aws.Int64(7)}, nil
+	}
+}
+
+func TestRestoreTrashed_RefusesToOverwrite(t *testing.T) {
+	mock := &mockS3{
+		headObjectFunc: headETag(`"e1"`),
+		copyObjectFunc: func(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+			t.Errorf("restore copied over %s", aws.ToString(params.Key))
+			return copiedETag(`"e1"`), nil
+		},
+	}
+	m := newMockTestModel(mock)
+	m.trashPrefix = "trash/"
+
+	msg := m.restoreTrashed(trashItem{trashKey: "trash/inbound/a", key: "inbound/a"})().(emailRestoredMsg)
+	if msg.err == nil || !strings.Contains(msg.err.Error(), "already exists") {
+		t.Fatalf("err = %v", msg.err)
+	}
+}
+
+func TestDeleteTrashed_FallsBackWhenVersionsCannotBeListed(t *testing.T) {
+	var removed []string
+	mock := &mockS3{
+		listVersionsFunc: func(ctx context.Context, params *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+			return nil, &smithy.GenericAPIError{Code: "AccessDenied", Message: "Access Denied"}
+		},
+		deleteObjectFunc: func(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+			removed = append(removed, aws.ToString(params.Key))
+			return &s3.DeleteObjectOutput{}, nil
+		},
+	}
+	m := newMockTestModel(mock)
+	m.trashPrefix = "trash/"
+
+	result, _ := m.Update(m.deleteTrashed(trashItem{trashKey: "trash/inbound/a", key: "inbound/a"})())
+	m = result.(model)
+	if len(removed) != 1 || !strings.Contains(m.statusMessage, "older versions may remain") {
+		t.Fatalf("removed = %v, status = %q", removed, m.statusMessage)
+	}
+}
+
+func TestLoadEmails_SkipsTrashUnderPrefix(t *testing.T) {
+	mock := &mockS3{
+		listObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			return &s3.ListObjectsV2Output{Contents: []types.Object{{Key: aws.String("trash/msg")}}}, nil
+		},
+	}
+	m := newMockTestModel(mock)
+	m.prefix = ""
+	m.trashPrefix = "trash/"
+
+	msg := m.loadEmails()().(emailsLoadedMsg)
+	if len(msg.emails) != 0 {
+		t.Fatalf("emails = %#v", msg.emails)
+	}
+}
@@ -12,9 +12,9 @@ import (
 
 func (m model) Init() tea.Cmd {
 	if m.state == bucketSelectionState {
-		return tea.Batch(m.loadBuckets(), m.spinner.Tick)
+		return tea.Batch(m.loadBuckets(), m.spinner.Tick, waitForDownload(m.downloads))
 	}
-	return tea.Batch(m.loadEmails(), m.spinner.Tick)
+	return tea.Batch(m.loadEmails(), m.spinner.Tick, waitForDownload(m.downloads))
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -33,6 +33,51 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 			return m, tea.Batch(cmds...)
 		}
+		if m.assignActive {
+			switch msg.String() {
+			case "esc":
+				m.assignActive = false
+				m.assignInput.Blur()
+			case "enter":
+				m.assignActive = false
+				m.assignInput.Blur()
+				return m, m.assign(strings.TrimSpace(m.assignInput.Value()))
+			default:
+				m.assignInput, cmd = m.assignInput.Update(msg)
+				cmds = append(cmds, cmd)
+			}
+			return m, tea.Batch(cmds...)
+		}
+		if m.labelActive {
+			switch msg.String() {
+			case "esc":
+				m.labelActive = false
+				m.labelInput.Blur()
+			case "enter":
+				m.labelActive = false
+				m.labelInput.Blur()
+				return m, m.applyLabels(m.labelInput.Value())
+			default:
+				m.labelInput, cmd = m.labelInput.Update(msg)
+				cmds = append(cmds, cmd)
+			}
+			return m, tea.Batch(cmds...)
+		}
+		if m.state == confirmBulkState {
+			switch msg.String() {
+			case "y":
+				m.state = listState
+				if m.pendingBulk.op == bulkZip {
+					targets := m.bulkTargets()
+					m.setStatus(fmt.Sprintf("Zipping attachments of %d marked email(s)...", len(targets)))
+					return m, m.zipAttachments(targets)
+				}
+				return m, m.startBulk(m.pendingBulk.op, m.pendingBulk.target)
+			case "n", "esc":
+				m.state = listState
+			}
+			return m, nil
+		}
 
 		switch m.state {
 		case bucketSelectionState:
@@ -70,6 +115,46 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 				return m, tea.Batch(cmds...)
 			}
+			if m.moveActive {
+				switch msg.String() {
+				case "esc":
+					m.moveActive = false
+					m.moveInput.Blur()
+				case "enter":
+					m.moveActive = false
+					m.moveInput.Blur()
+					if target := strings.TrimSpace(m.moveInput.Value()); target != "" {
+						m.pendingBulk = bulkJob{op: bulkMove, target: target}
+						m.state = confirmBulkState
+					}
+				default:
+					m.moveInput, cmd = m.moveInput.Update(msg)
+					cmds = append(cmds, cmd, m.suggestPrefixes())
+				}
+				return m, tea.Batch(cmds...)
+			}
+			if m.purgeActive {
+				switch msg.String() {
+				case "esc":
+					m.purgeActive = false
+					m.purgeInput.Blur()
+				case "enter":
+					query := strings.TrimSpace(m.purgeInput.Value())
+					if query == "" {
+						m.setStatus("Enter a query to choose what to purge")
+						return m, nil
+					}
+					m.purgeActive = false
+					m.purgeInput.Blur()
+					return m, m.startPurge(query)
+				default:
+					m.purgeInput, cmd = m.purgeInput.Update(msg)
+					cmds = append(cmds, cmd)
+				}
+				return m, tea.Batch(cmds...)
+			}
+			bulkKey := msg.String() == "d" || msg.String() == "s"
+			moveKey := msg.String() == "m" || msg.String() == "a"
 			switch {
 			case msg.String() == "ctrl+c" || msg.String() == "q":
 				return m, tea.Quit
@@ -82,20 +167,37 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.loading = true
 				m.filterQuery = ""
 				m.filterInput.SetValue("")
+				m.marked = nil
 				return m, tea.Batch(m.loadBuckets(), m.spinner.Tick)
 			case msg.String() == "enter":
 				if len(m.visibleEmails) > 0 {
-					m.selectedIndex = m.table.Cursor()
-					selected := m.visibleEmails[m.selectedIndex]
-					m.selectedEmail = &selected
-					m.state = viewState
-					m.viewport.SetContent("Loading email...")
-					if selected.BodyLoaded {
-						m.viewport.SetContent(m.getEmailBody(m.selectedEmail))
-						return m, nil
-					}
-					return m, tea.Batch(m.loadSelectedEmail(), m.spinner.Tick)
+					return m.openEmail(m.table.Cursor())
 				}
+			case msg.String() == " ":
+				m.toggleMark()
+			case msg.String() == "*":
+				m.markAllVisible()
+			case (bulkKey && len(m.marked) > 0 || moveKey) && m.bulk.done < m.bulk.total:
+				m.setStatus("Wait for the current bulk operation to finish")
+			case msg.String() == "d" && len(m.marked) > 0:
+				m.pendingBulk = bulkJob{op: bulkDelete}
+				m.state = confirmBulkState
+			case msg.String() == "s" && len(m.marked) > 0:
+				m.pendingBulk = bulkJob{op: bulkSave}
+				m.state = confirmBulkState
+			case msg.String() == "m" && len(m.bulkTargets()) > 0:
+				m.moveActive = true
+				m.moveInput.SetValue(m.prefix)
+				m.moveInput.CursorEnd()
+				m.moveInput.Focus()
+				m.suggestParent = ""
+				return m, m.suggestPrefixes()
+			case msg.String() == "a" && len(m.bulkTargets()) > 0:
+				m.pendingBulk = bulkJob{op: bulkMove, target: m.archiveTemplate}
+				m.state = confirmBulkState
+			case msg.String() == "A" && len(m.marked) > 0:
+				m.pendingBulk = bulkJob{op: bulkZip}
+				m.state = confirmBulkState
 			case msg.String() == "d":
 				if len(m.visibleEmails) > 0 {
 					m.previousState = listState
@@ -112,6 +214,29 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.loading = true
 				m.updateTableRows()
 				return m, tea.Batch(m.loadEmails(), m.spinner.Tick)
+			case msg.String() == "f" && len(m.bulkTargets()) > 0:
+				return m, m.toggleFlag(m.bulkTargets())
+			case msg.String() == "U" && len(m.bulkTargets()) > 0:
+				return m, m.toggleRead(m.bulkTargets())
+			case msg.String() == "@" && len(m.bulkTargets()) > 0:
+				m.openAssign()
+			case msg.String() == "L" && len(m.bulkTargets()) > 0:
+				m.openLabels()
+			case msg.String() == "u":
+				return m, m.undoDelete()
+			case msg.String() == "T":
+				return m, m.openTrash()
+			case msg.String() == "V":
+				return m, m.openVersions()
+			case msg.String() == "P":
+				if m.purge.running {
+					m.setStatus(purgeStatus(m.purge))
+					return m, nil
+				}
+				m.purgeActive = true
+				m.purgeInput.SetValue(m.filterQuery)
+				m.purgeInput.CursorEnd()
+				m.purgeInput.Focus()
 			case msg.String() == "/":
 				m.filterActive = true
 				m.filterInput.SetValue(m.filterQuery)
@@ -123,6 +248,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.selectedEmail = &selected
 					return m, m.saveSelectedEmail()
 				}
+			case msg.String() == "A" && len(m.bulkTargets()) > 0:
+				m.setStatus("Zipping attachments...")
+				return m, m.zipAttachments(m.bulkTargets())
 			case msg.String() == "down" || msg.String() == "j":
 				m.table, cmd = m.table.Update(msg)
 				cmds = append(cmds, cmd)
@@ -134,10 +262,124 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.table, cmd = m.table.Update(msg)
 				cmds = append(cmds, cmd)
 			}
+		case trashState:
+			if m.trashConfirm {
+				switch msg.String() {
+				case "y":
+					m.trashConfirm = false
+					if t, ok := m.selectedTrashItem(); ok {
+						return m, m.deleteTrashed(t)
+					}
+				case "n", "esc":
+					m.trashConfirm = false
+				}
+				return m, nil
+			}
+			switch msg.String() {
+			case "r":
+				if t, ok := m.selectedTrashItem(); ok {
+					m.setStatus("Restoring " + shortKey(t.key) + "...")
+					return m, m.restoreTrashed(t)
+				}
+			case "d":
+				if _, ok := m.selectedTrashItem(); ok {
+					m.trashConfirm = true
+				}
+			case "esc", "q":
+				m.state = listState
+			default:
+				m.trashList, cmd = m.trashList.Update(msg)
+				cmds = append(cmds, cmd)
+			}
+		case versionsState:
+			switch msg.String() {
+			case "enter":
+				if v, ok := m.versionsList.SelectedItem().(versionItem); ok && m.openVersion == nil {
+					m.setStatus("Loading version...")
+					return m, m.loadVersion(v)
+				}
+			case "r":
+				return m, m.restoreSelectedVersion()
+			case "esc", "q":
+				if m.openVersion != nil {
+					m.openVersion = nil
+					m.versionEmail = nil
+					return m, nil
+				}
+				m.state = listState
+			default:
+				if m.openVersion != nil {
+					m.viewport, cmd = m.viewport.Update(msg)
+				} else {
+					m.versionsList, cmd = m.versionsList.Update(msg)
+				}
+				cmds = append(cmds, cmd)
+			}
+		case composeState:
+			switch msg.String() {
+			case "y":
+				return m, m.sendDraft()
+			case "e":
+				if !m.draft.sending {
+					return m, m.editDraft()
+				}
+			case "a":
+				if !m.draft.sending && m.draft.kind == composeForward && len(m.draft.original.Attachments) > 0 {
+					m.draft.attach = !m.draft.attach
+				}
+			case "n", "esc":
+				if !m.draft.sending {
+					m.discardDraft("Draft discarded")
+				}
+			}
+		case purgeState:
+			switch msg.String() {
+			case "y":
+				return m, m.runPurge()
+			case "esc", "q":
+				m.state = listState
+			default:
+				m.viewport, cmd = m.viewport.Update(msg)
+				cmds = append(cmds, cmd)
+			}
 		case viewState:
+			if m.searchActive {
+				switch msg.String() {
+				case "esc":
+					m.searchActive = false
+					m.searchInput.Blur()
+				case "enter":
+					m.searchActive = false
+					m.searchInput.Blur()
+					m.applySearch(m.searchInput.Value())
+				default:
+					m.searchInput, cmd = m.searchInput.Update(msg)
+					m.applySearch(m.searchInput.Value())
+					cmds = append(cmds, cmd)
+				}
+				return m, tea.Batch(cmds...)
+			}
 			switch msg.String() {
 			case "esc", "q":
+				if msg.String() == "esc" && m.searchQuery != "" {
+					m.clearSearch()
+					return m, nil
+				}
 				m.state = listState
+			case "/":
+				m.searchActive = true
+				m.searchInput.SetValue(m.searchQuery)
+				m.searchInput.Focus()
+			case "n":
+				if m.searchQuery != "" {
+					m.stepMatch(1)
+					return m, nil
+				}
+				return m.stepEmail(1)
+			case "N":
+				m.stepMatch(-1)
+			case "p":
+				return m.stepEmail(-1)
 			case "d":
 				m.previousState = viewState
 				m.state = confirmDeleteState
@@ -145,6 +387,33 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, m.saveSelectedEmail()
 			case "a":
 				return m, m.saveSelectedAttachments()
+			case "A":
+				m.setStatus("Zipping attachments...")
+				return m, m.zipSelectedAttachments()
+			case "i":
+				return m, m.saveSelectedInvite()
+			case "w":
+				m.showDiagnostics = !m.showDiagnostics
+			case "v":
+				m.setStatus("Verifying DKIM signatures...")
+				return m, m.verifySelectedDKIM()
+			case "f":
+				return m, m.toggleFlag(m.tagTargets())
+			case "U":
+				return m, m.toggleRead(m.tagTargets())
+			case "@":
+				m.openAssign()
+			case "L":
+				m.openLabels()
+			case "r":
+				return m, m.compose(composeReply)
+			case "R":
+				return m, m.compose(composeReplyAll)
+			case "F":
+				return m, m.compose(composeForward)
+			case "z":
+				m.toggleQuoted()
+				m.setStatus(foldStatus(m.showQuoted))
 			default:
 				m.viewport, cmd = m.viewport.Update(msg)
 				cmds = append(cmds, cmd)
@@ -192,16 +461,43 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.setStatus(fmt.Sprintf("Skipped %d unparseable email(s)", msg.skipped))
 		}
 	case emailLoadedMsg:
+		// A slow load can land after the user has moved on to another
+		// email or view, so only the email still open takes over the viewer.
 		m.replaceEmail(msg.email)
-		m.selectedEmail = m.findEmailByKey(msg.email.Key)
-		if m.selectedEmail != nil {
-			m.viewport.SetContent(m.getEmailBody(m.selectedEmail))
+		m.touchBody(msg.email.Key)
+		if m.state == viewState && m.selectedEmail != nil && m.selectedEmail.Key == msg.email.Key {
+			m.selectedEmail = m.findEmailByKey(msg.email.Key)
+			m.setViewerContent(m.getEmailBody(m.selectedEmail))
+		}
+	case downloadProgressMsg:
+		if m.state == viewState && m.selectedEmail != nil && m.selectedEmail.Key == msg.key &&
+			!m.selectedEmail.BodyLoaded && msg.total >= progressThresholdBytes {
+			m.setViewerContent(renderDownloadProgress(msg.done, msg.total, m.width))
 		}
+		cmds = append(cmds, waitForDownload(m.downloads))
+	case bulkItemMsg:
+		m.recordBulkItem(msg)
+	case purgeScanMsg:
+		cmds = append(cmds, m.recordPurgeScan(msg))
+	case purgeMatchedMsg:
+		m.recordPurgeMatches(msg)
+	case purgeBatchMsg:
+		m.recordPurgeBatch(msg)
+	case draftEditedMsg:
+		m.recordDraft(msg)
+	case draftSentMsg:
+		m.recordSent(msg)
+	case emailPrefetchedMsg:
+		m.storePrefetched(msg)
 	case emailDeletedMsg:
 		if msg.err != nil {
 			m.setStatus("Delete failed: " + msg.err.Error())
 		} else {
-			m.deleteEmailByKey(m.selectedEmail.Key)
+			deleted := *m.selectedEmail
+			if msg.key == "" {
+				msg.key = deleted.Key
+			}
+			m.deleteEmailByKey(deleted.Key)
 			m.updateTableRows()
 			if len(m.visibleEmails) > 0 {
 				if m.selectedIndex >= len(m.visibleEmails) {
@@ -210,8 +506,54 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.table.SetCursor(m.selectedIndex)
 			}
 			m.state = m.previousState
-			m.setStatus("Email deleted")
+			cmds = append(cmds, m.offerUndo(msg, deleted))
+			if msg.trashKey != "" {
+				if err := m.labels.move(m.bucket, deleted.Key, m.bucket, msg.trashKey); err != nil {
+					m.setStatus("Labels not moved: " + err.Error())
+				}
+			}
+		}
+	case undoExpiredMsg:
+		m.expireUndo(msg)
+	case emailRestoredMsg:
+		m.recordRestored(msg)
+		if msg.err == nil && m.state == versionsState {
+			m.openVersion = nil
+			m.versionEmail = nil
+			m.loading = true
+			cmds = append(cmds, m.loadVersions())
+		}
+	case tagsUpdatedMsg:
+		m.recordTags(msg)
+	case prefixesLoadedMsg:
+		m.storePrefixes(msg)
+	case versionsLoadedMsg:
+		m.loading = false
+		if msg.err != nil {
+			m.setStatus("Could not list versions: " + msg.err.Error())
+			break
+		}
+		m.versionsList.SetItems(msg.items)
+	case versionLoadedMsg:
+		m.showVersion(msg)
+	case trashLoadedMsg:
+		m.loading = false
+		if msg.err != nil {
+			m.setStatus("Could not list trash: " + msg.err.Error())
+			break
+		}
+		m.trashList.SetItems(msg.items)
+	case trashDeletedMsg:
+		if msg.err != nil {
+			m.setStatus("Delete failed: " + msg.err.Error())
+			break
+		}
+		m.removeTrashItem(msg.trashKey)
+		status := "Deleted " + shortKey(strings.TrimPrefix(msg.trashKey, m.trashPrefix)) + " permanently"
+		if msg.versionsKept {
+			status += "; older versions may remain (listing versions was denied)"
 		}
+		m.setStatus(status)
 	case emailSavedMsg:
 		if msg.err != nil {
 			m.setStatus("Save failed: " + msg.err.Error())
@@ -219,13 +561,49 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.setStatus("Saved .eml to " + msg.path)
 		}
 	case attachmentsSavedMsg:
+		if msg.results != nil {
+			if email := m.findEmailByKey(msg.key); email != nil {
+				email.ScanResults = msg.results
+				if m.selectedEmail != nil && m.selectedEmail.Key == msg.key {
+					m.selectedEmail = email
+				}
+			}
+		}
 		if msg.err != nil {
 			m.setStatus("Attachment save failed: " + msg.err.Error())
+		} else if msg.results != nil {
+			m.setStatus(scanStatus(msg.results))
 		} else if len(msg.paths) == 0 {
 			m.setStatus("No attachments to save")
 		} else {
 			m.setStatus(fmt.Sprintf("Saved %d attachment(s)", len(msg.paths)))
 		}
+	case attachmentsZippedMsg:
+		if msg.err != nil {
+			m.setStatus("Zip failed: " + msg.err.Error())
+		} else {
+			m.setStatus(zipStatus(msg))
+		}
+	case inviteSavedMsg:
+		if msg.err != nil {
+			m.setStatus("Invite save failed: " + msg.err.Error())
+		} else if msg.path == "" {
+			m.setStatus("No calendar invite to save")
+		} else {
+			m.setStatus("Saved .ics to " + msg.path)
+		}
+	case dkimVerifiedMsg:
+		if msg.err != nil {
+			m.setStatus("DKIM verify failed: " + msg.err.Error())
+			break
+		}
+		if email := m.findEmailByKey(msg.key); email != nil {
+			email.DKIMResults = msg.results
+			if m.selectedEmail != nil && m.selectedEmail.Key == msg.key {
+				m.selectedEmail = email
+			}
+		}
+		m.setStatus(dkimStatus(msg.results))
 	case clearStatusMsg:
 		m.statusMessage = ""
 	case errorMsg:
@@ -263,6 +641,24 @@ func mergeEmailData(current, incoming Email) Email {
 	if incoming.To != "" {
 		current.To = incoming.To
 	}
+	if incoming.FromAddrs != nil {
+		current.FromAddrs = incoming.FromAddrs
+	}
+	if incoming.ToAddrs != nil {
+		current.ToAddrs = incoming.ToAddrs
+	}
+	if incoming.CcAddrs != nil {
+		current.CcAddrs = incoming.CcAddrs
+	}
+	if incoming.ReplyTo != nil {
+		current.ReplyTo = incoming.ReplyTo
+	}
+	if incoming.DeliveredTo != nil {
+		current.DeliveredTo = incoming.DeliveredTo
+	}
+	if incoming.Verdicts != (Verdicts{}) {
+		current.Verdicts = incoming.Verdicts
+	}
 	if incoming.Subject != "" {
 		current.Subject = incoming.Subject
 	}
@@ -275,15 +671,40 @@ func mergeEmailData(current, incoming Email) Email {
 	if incoming.Size != 0 {
 		current.Size = incoming.Size
 	}
+	if incoming.Tags != nil {
+		current.Tags = incoming.Tags
+	}
+	if incoming.MessageID != "" {
+		current.MessageID = incoming.MessageID
+		current.References = incoming.References
+	}
 	if incoming.BodyLoaded {
 		current.Body = incoming.Body
 		current.BodyLoaded = true
 		current.Attachments = incoming.Attachments
+		current.Calendar = incoming.Calendar
+		current.Images = incoming.Images
+		current.Diagnostics = incoming.Diagnostics
+		if incoming.Receipt != nil {
+			current.Receipt = incoming.Receipt
+		}
+		current.Security = incoming.Security
+		current.Warnings = incoming.Warnings
+		current.Report = incoming.Report
+		current.Kind = incoming.Kind
+	} else if !current.BodyLoaded && incoming.Diagnostics != nil {
+		current.Diagnostics = incoming.Diagnostics
 	}
 	if incoming.RawLoaded {
 		current.Raw = incoming.Raw
 		current.RawLoaded = true
 	}
+	if incoming.Spool != "" {
+		if current.Spool != incoming.Spool {
+			removeSpool(current)
+		}
+		current.Spool = incoming.Spool
+	}
 	return current
 }
 
@@ -313,6 +734,8 @@ func (m *model) deleteEmailByKey(key string) {
 	for _, email := range m.emails {
 		if email.Key != key {
 			filtered = append(filtered, email)
+		} else {
+			removeSpool(email)
 		}
 	}
 	m.emails = filtered
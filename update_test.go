@@ -668,6 +668,8 @@ func TestUpdate_WindowSizeInitialisesComponents(t *testing.T) {
 func TestUpdate_EmailLoadedMsgSetsViewportContent(t *testing.T) {
 	m := newReadyTestModel()
 	m.emails = []Email{{Key: "one", Subject: "a"}}
+	m.state = viewState
+	m.selectedEmail = &m.emails[0]
 
 	result, _ := m.Update(emailLoadedMsg{email: Email{Key: "one", Subject: "a", Body: "loaded", BodyLoaded: true}})
 	if !strings.Contains(result.(model).viewport.View(), "loaded") {
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// sesAuthServID is the authserv-id of the Authentication-Results header SES
+// adds on receipt. Headers from any other host may come from the sender.
+const sesAuthServID = "amazonses.com"
+
+const (
+	verdictPass = "pass"
+	verdictFail = "fail"
+	verdictGray = "gray"
+)
+
+// Verdicts holds the results SES records on inbound mail. Each check is the
+// lowercased result ("pass", "fail", "gray", "none", ...) or empty when the
+// message carried no such header.
+type Verdicts struct {
+	Spam    string
+	Virus   string
+	DKIM    string
+	SPF     string
+	DMARC   string
+	Receipt string
+}
+
+type verdictCheck struct {
+	name   string
+	result string
+}
+
+var (
+	passBadgeStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+	failBadgeStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("196")).Bold(true)
+	neutralBadgeStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+)
+
+func parseVerdicts(header map[string][]string) Verdicts {
+	v := Verdicts{
+		Spam:    normaliseVerdict(firstHeader(header, "X-Ses-Spam-Verdict")),
+		Virus:   normaliseVerdict(firstHeader(header, "X-Ses-Virus-Verdict")),
+		Receipt: strings.TrimSpace(firstHeader(header, "X-Ses-Receipt")),
+	}
+	for _, r := range header["Authentication-Results"] {
+		if authServID(r) != sesAuthServID {
+			continue
+		}
+		methods := parseAuthenticationResults(r, fromDomain(firstHeader(header, "From")))
+		v.DKIM = methods["dkim"]
+		v.SPF = methods["spf"]
+		v.DMARC = methods["dmarc"]
+		break
+	}
+	return v
+}
+
+// authServID is the host that added an Authentication-Results header, the
+// first token before the optional version and the first ";".
+func authServID(value string) string {
+	id, _, _ := strings.Cut(value, ";")
+	if fields := strings.Fields(id); len(fields) > 0 {
+		return strings.ToLower(fields[0])
+	}
+	return ""
+}
+
+// fromDomain is the lowercased domain of the From address, or empty.
+func fromDomain(from string) string {
+	if addr, err := mail.ParseAddress(from); err == nil {
+		from = addr.Address
+	}
+	at := strings.LastIndex(from, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.Trim(from[at+1:], " >"))
+}
+
+// firstHeader reads a header from either a net/mail or textproto map. Both
+// canonicalise keys the same way, so X-SES-RECEIPT is stored as X-Ses-Receipt.
+func firstHeader(header map[string][]string, key string) string {
+	if values := header[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func normaliseVerdict(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// parseAuthenticationResults reads the method=result pairs of an RFC 8601
+// Authentication-Results header. DKIM only counts signatures whose
+// header.d or header.i domain aligns with the From domain, so a pass for
+// the relay's own signature cannot hide a failing one for the sender; any
+// aligned pass wins, and "none" means no signature was for the From domain.
+func parseAuthenticationResults(value, from string) map[string]string {
+	results := make(map[string]string)
+	for i, clause := range strings.Split(value, ";") {
+		if i == 0 {
+			continue
+		}
+		clause = strings.TrimSpace(clause)
+		method, rest, ok := strings.Cut(clause, "=")
+		if !ok {
+			continue
+		}
+		method = strings.ToLower(strings.TrimSpace(method))
+		result := strings.ToLower(strings.Fields(rest + " ")[0])
+		if method == "dkim" && !alignedSignature(rest, from) {
+			if _, seen := results[method]; !seen {
+				results[method] = "none"
+			}
+			continue
+		}
+		if existing, seen := results[method]; seen && existing == verdictPass {
+			continue
+		}
+		results[method] = result
+	}
+	return results
+}
+
+// alignedSignature reports whether a dkim clause's header.d or header.i
+// domain is the From domain or one of its parents.
+func alignedSignature(clause, from string) bool {
+	if from == "" {
+		return false
+	}
+	for _, field := range strings.Fields(clause) {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(name) {
+		case "header.d", "header.i":
+			domain := strings.ToLower(value[strings.LastIndex(value, "@")+1:])
+			if domain != "" && (from == domain || strings.HasSuffix(from, "."+domain)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (v Verdicts) checks() []verdictCheck {
+	all := []verdictCheck{
+		{"spam", v.Spam},
+		{"virus", v.Virus},
+		{"dkim", v.DKIM},
+		{"spf", v.SPF},
+		{"dmarc", v.DMARC},
+	}
+	checks := all[:0]
+	for _, c := range all {
+		if c.result != "" {
+			checks = append(checks, c)
+		}
+	}
+	return checks
+}
+
+func (v Verdicts) result(name string) string {
+	for _, c := range v.checks() {
+		if c.name == name {
+			return c.result
+		}
+	}
+	return ""
+}
+
+func isFailingVerdict(result string) bool {
+	switch result {
+	case "", verdictPass, "none", "neutral", verdictGray:
+		return false
+	}
+	return true
+}
+
+func (v Verdicts) failures() []string {
+	var failed []string
+	for _, c := range v.checks() {
+		if isFailingVerdict(c.result) {
+			failed = append(failed, c.name)
+		}
+	}
+	return failed
+}
+
+// summary is the compact form used in the list's Checks column.
+func (v Verdicts) summary() string {
+	if len(v.checks()) == 0 {
+		return ""
+	}
+	if failed := v.failures(); len(failed) > 0 {
+		return "✗ " + strings.Join(failed, ",")
+	}
+	return "✓"
+}
+
+func verdictBadge(c verdictCheck) string {
+	label := fmt.Sprintf("%s %s", c.name, strings.ToUpper(c.result))
+	switch {
+	case c.result == verdictPass:
+		return passBadgeStyle.Render("✓ " + label)
+	case isFailingVerdict(c.result):
+		return failBadgeStyle.Render("✗ " + label)
+	default:
+		return neutralBadgeStyle.Render("? " + label)
+	}
+}
+
+func renderVerdictBadges(v Verdicts) string {
+	checks := v.checks()
+	if len(checks) == 0 {
+		return ""
+	}
+	badges := make([]string, 0, len(checks))
+	for _, c := range checks {
+		badges = append(badges, verdictBadge(c))
+	}
+	return "Checks:  " + strings.Join(badges, "  ")
+}
+
+// matchVerdictFilter handles spam:, virus:, dkim:, spf: and dmarc: terms.
+// The value "fail" matches any non-passing result, so dmarc:fail also finds
+// quarantine or permerror outcomes.
+func matchVerdictFilter(name string) func(e Email, value string) bool {
+	return func(e Email, value string) bool {
+		result := e.Verdicts.result(name)
+		value = strings.ToLower(value)
+		if value == verdictFail {
+			return isFailingVerdict(result)
+		}
+		return result == value
+	}
+}
+
+func matchChecksFilter(e Email, value string) bool {
+	switch strings.ToLower(value) {
+	case verdictFail:
+		return len(e.Verdicts.failures()) > 0
+	case verdictPass:
+		return len(e.Verdicts.checks()) > 0 && len(e.Verdicts.failures()) == 0
+	case "none":
+		return len(e.Verdicts.checks()) == 0
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const sesHeaders = "X-SES-Spam-Verdict: PASS\r\n" +
+	"X-SES-Virus-Verdict: PASS\r\n" +
+	"X-SES-RECEIPT: AEFBQUFBQUFBQUFH\r\n" +
+	"Authentication-Results: mx.other.example; dkim=pass\r\n" +
+	"Authentication-Results: amazonses.com;\r\n spf=pass (spfCheck: domain of acme.example designates 1.2.3.4 as permitted sender) smtp.mailfrom=a@acme.example;\r\n dkim=fail header.i=@acme.example;\r\n dkim=pass header.i=@amazonses.com;\r\n dmarc=fail header.from=acme.example;\r\n"
+
+func TestParseAuthenticationResults_DKIMKeyedOnFromDomain(t *testing.T) {
+	got := parseAuthenticationResults("amazonses.com; dkim=fail header.i=@a.example; dkim=pass header.i=@amazonses.com; spf=softfail; dmarc=none", "a.example")
+	if got["dkim"] != "fail" || got["spf"] != "softfail" || got["dmarc"] != "none" {
+		t.Fatalf("got %#v", got)
+	}
+
+	got = parseAuthenticationResults("amazonses.com; dkim=fail header.d=a.example; dkim=pass header.d=a.example", "mail.a.example")
+	if got["dkim"] != "pass" {
+		t.Errorf("an aligned pass should win: %#v", got)
+	}
+
+	got = parseAuthenticationResults("amazonses.com; dkim=pass header.i=@amazonses.com", "a.example")
+	if got["dkim"] != "none" {
+		t.Errorf("only a relay signature: %#v", got)
+	}
+}
+
+func TestParseVerdicts_IgnoresForeignAuthenticationResults(t *testing.T) {
+	header := map[string][]string{
+		"From":                   {"a@acme.example"},
+		"Authentication-Results": {"amazonses.com.evil.example; dkim=pass header.d=acme.example; spf=pass; dmarc=pass", "mx.acme.example; dkim=pass header.d=acme.example; spf=pass; dmarc=pass"},
+	}
+	if v := parseVerdicts(header); v.DKIM != "" || v.SPF != "" || v.DMARC != "" {
+		t.Fatalf("forged results trusted: %#v", v)
+	}
+}
+
+func TestFetchEmailSummary_ParsesSESVerdicts(t *testing.T) {
+	raw := "From: a@acme.example\r\nTo: b@example.com\r\nSubject: hi\r\n" + sesHeaders + "\r\nbody"
+	mock := &mockS3{
+		getObjectFunc: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(raw))}, nil
+		},
+	}
+	m := model{s3Client: mock, bucket: "b"}
+
+	email, err := m.fetchEmailSummary(context.Background(), types.Object{Key: aws.String("k")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := Verdicts{Spam: "pass", Virus: "pass", DKIM: "fail", SPF: "pass", DMARC: "fail", Receipt: "AEFBQUFBQUFBQUFH"}
+	if email.Verdicts != want {
+		t.Fatalf("Verdicts = %#v, want %#v", email.Verdicts, want)
+	}
+}
+
+func TestParseFullEmail_ParsesSESVerdicts(t *testing.T) {
+	raw := "From: a@acme.example\r\nSubject: hi\r\n" + sesHeaders + "\r\nbody"
+
+	email, err := parseFullEmail([]byte(raw), "k")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if email.Verdicts.DMARC != "fail" || email.Verdicts.Spam != "pass" {
+		t.Fatalf("Verdicts = %#v", email.Verdicts)
+	}
+}
+
+func TestVerdicts_SummaryAndBadges(t *testing.T) {
+	if got := (Verdicts{}).summary(); got != "" {
+		t.Fatalf("empty summary = %q", got)
+	}
+	if got := (Verdicts{Spam: "pass", DKIM: "pass"}).summary(); got != "✓" {
+		t.Fatalf("pass summary = %q", got)
+	}
+	v := Verdicts{Spam: "pass", Virus: "fail", DKIM: "pass", SPF: "none", DMARC: "fail"}
+	if got := v.summary(); got != "✗ virus,dmarc" {
+		t.Fatalf("fail summary = %q", got)
+	}
+
+	badges := renderVerdictBadges(v)
+	for _, want := range []string{"spam PASS", "virus FAIL", "spf NONE", "dmarc FAIL"} {
+		if !strings.Contains(badges, want) {
+			t.Errorf("badges missing %q: %q", want, badges)
+		}
+	}
+}
+
+func TestFilteredEmails_VerdictQualifiers(t *testing.T) {
+	m := newTestModel()
+	m.emails = []Email{
+		{Key: "clean", Verdicts: Verdicts{Spam: "pass", DMARC: "pass"}},
+		{Key: "dmarc", Verdicts: Verdicts{Spam: "pass", DMARC: "quarantine"}},
+		{Key: "spam", Verdicts: Verdicts{Spam: "fail", DMARC: "pass"}},
+		{Key: "none"},
+	}
+
+	cases := map[string][]string{
+		"dmarc:fail":  {"dmarc"},
+		"dmarc:pass":  {"clean", "spam"},
+		"spam:fail":   {"spam"},
+		"checks:fail": {"dmarc", "spam"},
+		"checks:pass": {"clean"},
+		"checks:none": {"none"},
+	}
+	for query, want := range cases {
+		m.filterQuery = query
+		got := m.filteredEmails()
+		if len(got) != len(want) {
+			t.Errorf("%s: got %d emails, want %v", query, len(got), want)
+			continue
+		}
+		for i := range want {
+			if got[i].Key != want[i] {
+				t.Errorf("%s: got %q, want %q", query, got[i].Key, want[i])
+			}
+		}
+	}
+}
+
+func TestUpdateTableRows_ShowsChecksColumn(t *testing.T) {
+	m := newReadyTestModel()
+	m.emails = []Email{{Key: "k", Date: time.Now(), Verdicts: Verdicts{DMARC: "fail"}}}
+
+	m.updateTableRows()
+
+	row := m.table.Rows()[0]
+	if len(row) != len(m.table.Columns()) || row[3] != "✗ dmarc" {
+		t.Fatalf("row = %#v", row)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// versionItem is one stored version of a message. Keys whose newest entry is
+// a delete marker are shown as deleted; their versions are still readable.
+type versionItem struct {
+	key       string
+	versionID string
+	modified  time.Time
+	size      int64
+	latest    bool
+	deleted   bool
+}
+
+func (v versionItem) Title() string { return v.key }
+func (v versionItem) Description() string {
+	state := "previous version"
+	switch {
+	case v.deleted && v.latest:
+		state = "deleted, last version"
+	case v.deleted:
+		state = "deleted, older version"
+	case v.latest:
+		state = "current"
+	}
+	return fmt.Sprintf("%s, %s, %s", v.modified.Local().Format("2006-01-02 15:04"), formatBytes(v.size), state)
+}
+func (v versionItem) FilterValue() string { return v.key }
+
+type versionsLoadedMsg struct {
+	items []list.Item
+	err   error
+}
+
+type versionLoadedMsg struct {
+	item  versionItem
+	email *Email
+	err   error
+}
+
+// openVersions switches to the versions view for the current prefix.
+func (m *model) openVersions() tea.Cmd {
+	m.state = versionsState
+	m.openVersion = nil
+	m.versionEmail = nil
+	m.loading = true
+	m.versionsList = list.New(nil, list.NewDefaultDelegate(), m.width-4, m.height-6)
+	m.versionsList.Title = "Versions: " + m.prefix
+	m.versionsList.SetShowHelp(false)
+	m.versionsList.SetFilteringEnabled(false)
+	return tea.Batch(m.loadVersions(), m.spinner.Tick)
+}
+
+// loadVersions lists every version and delete marker under the prefix and
+// keeps the keys that have history: a delete marker or more than one version.
+// Keys are ordered by their most recent change.
+func (m model) loadVersions() tea.Cmd {
+	return func() tea.Msg {
+		ctx := context.Background()
+		byKey := make(map[string][]versionItem)
+		deleted := make(map[string]bool)
+		changed := make(map[string]time.Time)
+		touch := func(key string, at time.Time) {
+			if at.After(changed[key]) {
+				changed[key] = at
+			}
+		}
+
+		input := &s3.ListObjectVersionsInput{
+			Bucket: aws.String(m.bucket),
+			Prefix: aws.String(m.prefix),
+		}
+		for {
+			page, err := m.s3Client.ListObjectVersions(ctx, input)
+			if err != nil {
+				return versionsLoadedMsg{err: err}
+			}
+			for _, v := range page.Versions {
+				key := aws.ToString(v.Key)
+				if m.inTrash(key) {
+					continue
+				}
+				item := versionItem{
+					key:       key,
+					versionID: aws.ToString(v.VersionId),
+					modified:  aws.ToTime(v.LastModified),
+					size:      aws.ToInt64(v.Size),
+					latest:    aws.ToBool(v.IsLatest),
+				}
+				byKey[key] = append(byKey[key], item)
+				touch(key, item.modified)
+			}
+			for _, marker := range page.DeleteMarkers {
+				key := aws.ToString(marker.Key)
+				if aws.ToBool(marker.IsLatest) {
+					deleted[key] = true
+				}
+				touch(key, aws.ToTime(marker.LastModified))
+			}
+			if !aws.ToBool(page.IsTruncated) {
+				break
+			}
+			input.KeyMarker = page.NextKeyMarker
+			input.VersionIdMarker = page.NextVersionIdMarker
+		}
+
+		var keys []string
+		for key, versions := range byKey {
+			if deleted[key] || len(versions) > 1 {
+				keys = append(keys, key)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if !changed[keys[i]].Equal(changed[keys[j]]) {
+				return changed[keys[i]].After(changed[keys[j]])
+			}
+			return keys[i] < keys[j]
+		})
+		var items []list.Item
+		for _, key := range keys {
+			versions := byKey[key]
+			sort.SliceStable(versions, func(i, j int) bool {
+				return versions[i].modified.After(versions[j].modified)
+			})
+			for i, v := range versions {
+				v.deleted = deleted[key]
+				v.latest = v.latest || (v.deleted && i == 0)
+				items = append(items, v)
+			}
+		}
+		return versionsLoadedMsg{items: items}
+	}
+}
+
+// loadVersion downloads and parses one version of a message.
+func (m model) loadVersion(v versionItem) tea.Cmd {
+	return func() tea.Msg {
+		ctx := context.Background()
+		result, err := m.s3Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket:    aws.String(m.bucket),
+			Key:       aws.String(v.key),
+			VersionId: aws.String(v.versionID),
+		})
+		if err != nil {
+			return versionLoadedMsg{item: v, err: err}
+		}
+		data, err := m.readMessageBytes(result.Body)
+		if err != nil {
+			return versionLoadedMsg{item: v, err: err}
+		}
+		email, err := m.parseEmailBytes(data, v.key)
+		return versionLoadedMsg{item: v, email: email, err: err}
+	}
+}
+
+// restoreVersion copies v over its key so it becomes the current version.
+// Earlier versions and delete markers are left in the history.
+func (m model) restoreVersion(v versionItem) tea.Cmd {
+	return func() tea.Msg {
+		ctx := context.Background()
+		_, err := m.s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(m.bucket),
+			Key:        aws.String(v.key),
+			CopySource: aws.String(copySource(m.bucket, v.key) + "?versionId=" + url.QueryEscape(v.versionID)),
+		})
+		if err != nil {
+			return emailRestoredMsg{err: err}
+		}
+		obj := types.Object{Key: aws.String(v.key), LastModified: aws.Time(time.Now()), Size: aws.Int64(v.size)}
+		email, err := m.fetchEmailSummary(ctx, obj)
+		if err != nil {
+			email = fallbackEmailSummary(obj)
+		}
+		return emailRestoredMsg{email: *email}
+	}
+}
+
+// restoreSelectedVersion restores the version being read, or else the one
+// under the cursor.
+func (m *model) restoreSelectedVersion() tea.Cmd {
+	v, ok := m.versionsList.SelectedItem().(versionItem)
+	if m.openVersion != nil {
+		v, ok = *m.openVersion, true
+	}
+	if !ok {
+		return nil
+	}
+	if v.latest && !v.deleted {
+		m.setStatus("Already the current version")
+		return nil
+	}
+	m.setStatus("Restoring " + shortKey(v.key) + "...")
+	return m.restoreVersion(v)
+}
+
+func (m *model) showVersion(msg versionLoadedMsg) {
+	if m.state != versionsState {
+		return
+	}
+	if msg.err != nil {
+		m.setStatus("Could not load version: " + msg.err.Error())
+		return
+	}
+	item := msg.item
+	m.openVersion = &item
+	m.versionEmail = msg.email
+	m.setStatus("")
+	m.setViewerContent(m.getEmailBody(msg.email))
+	m.viewport.GotoTop()
+}
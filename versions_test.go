@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func versionsMock(t *testing.T, base time.Time) *mockS3 {
+	return &mockS3{
+		listVersionsFunc: func(ctx context.Context, params *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+			if params.KeyMarker == nil {
+				return &s3.ListObjectVersionsOutput{
+					Versions: []types.ObjectVersion{
+						{Key: aws.String("inbound/edited"), VersionId: aws.String("e2"), IsLatest: aws.Bool(true), LastModified: aws.Time(base.Add(2 * time.Hour))},
+						{Key: aws.String("inbound/edited"), VersionId: aws.String("e1"), LastModified: aws.Time(base)},
+						{Key: aws.String("inbound/plain"), VersionId: aws.String("p1"), IsLatest: aws.Bool(true), LastModified: aws.Time(base)},
+					},
+					IsTruncated:         aws.Bool(true),
+					NextKeyMarker:       aws.String("inbound/plain"),
+					NextVersionIdMarker: aws.String("p1"),
+				}, nil
+			}
+			if aws.ToString(params.VersionIdMarker) != "p1" {
+				t.Errorf("version marker = %q", aws.ToString(params.VersionIdMarker))
+			}
+			return &s3.ListObjectVersionsOutput{
+				Versions: []types.ObjectVersion{
+					{Key: aws.String("inbound/gone"), VersionId: aws.String("g1"), LastModified: aws.Time(base.Add(time.Hour))},
+				},
+				DeleteMarkers: []types.DeleteMarkerEntry{
+					{Key: aws.String("inbound/gone"), VersionId: aws.String("m1"), IsLatest: aws.Bool(true), LastModified: aws.Time(base.Add(3 * time.Hour))},
+				},
+			}, nil
+		},
+	}
+}
+
+func TestLoadVersions_KeepsKeysWithHistory(t *testing.T) {
+	m := newMockTestModel(versionsMock(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	msg := m.loadVersions()().(versionsLoadedMsg)
+	if msg.err != nil {
+		t.Fatal(msg.err)
+	}
+	var got []string
+	for _, it := range msg.items {
+		v := it.(versionItem)
+		got = append(got, v.versionID)
+	}
+	if strings.Join(got, ",") != "g1,e2,e1" {
+		t.Fatalf("versions = %v", got)
+	}
+	gone := msg.items[0].(versionItem)
+	if !gone.deleted || !strings.Contains(gone.Description(), "deleted, last version") {
+		t.Errorf("gone = %#v, %q", gone, gone.Description())
+	}
+	if d := msg.items[1].(versionItem).Description(); !strings.HasSuffix(d, "current") {
+		t.Errorf("current description = %q", d)
+	}
+}
+
+func TestVersionsView_ReadsAndRestoresVersion(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock := versionsMock(t, base)
+	var copied *s3.CopyObjectInput
+	mock.getObjectFunc = func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+		subject := "Current"
+		if aws.ToString(params.VersionId) == "g1" {
+			subject = "Before deletion"
+		}
+		body := buildMIMEEmail("a@example.com", "b@example.com", subject, "old words", base)
+		return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(body))}, nil
+	}
+	mock.copyObjectFunc = func(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+		copied = params
+		return &s3.CopyObjectOutput{}, nil
+	}
+	m := newMockTestModel(mock)
+	m.initComponents()
+	m.updateComponents()
+
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("V")})
+	m = result.(model)
+	if m.state != versionsState || cmd == nil {
+		t.Fatalf("state = %v", m.state)
+	}
+	result, _ = m.Update(m.loadVersions()())
+	m = result.(model)
+
+	result, cmd = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = result.(model)
+	result, _ = m.Update(cmd())
+	m = result.(model)
+	if m.openVersion == nil || m.openVersion.versionID != "g1" || m.versionEmail.Subject != "Before deletion" {
+		t.Fatalf("open version = %#v", m.openVersion)
+	}
+	if !strings.Contains(m.View(), "Before deletion") {
+		t.Error("version header should show its subject")
+	}
+
+	result, cmd = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")})
+	m = result.(model)
+	result, _ = m.Update(cmd())
+	m = result.(model)
+	if copied == nil || aws.ToString(copied.Key) != "inbound/gone" || aws.ToString(copied.CopySource) != "test-bucket/inbound/gone?versionId=g1" {
+		t.Fatalf("copy = %#v", copied)
+	}
+	if e := m.findEmailByKey("inbound/gone"); e == nil || !m.loading || m.openVersion != nil {
+		t.Fatalf("restored email = %#v, loading = %v", e, m.loading)
+	}
+}
+
+func TestRestoreSelectedVersion_SkipsCurrent(t *testing.T) {
+	m := newMockTestModel(&mockS3{})
+	m.openVersion = &versionItem{key: "inbound/a", versionID: "v2", latest: true}
+
+	if m.restoreSelectedVersion() != nil || m.statusMessage != "Already the current version" {
+		t.Fatalf("status = %q", m.statusMessage)
+	}
+}
@@ -24,7 +24,7 @@ func (m model) View() string {
 		help := helpStyle.Render("up/down: navigate | enter: select | q: quit")
 		content := baseStyle.Width(m.width).Height(m.height - 4).Render(m.bucketsList.View())
 		baseView = lipgloss.JoinVertical(lipgloss.Left, title, content, help)
-	case listState, confirmDeleteState:
+	case listState, confirmDeleteState, confirmBulkState:
 		if m.state == confirmDeleteState && m.previousState == viewState {
 			baseView = m.renderEmailView()
 		} else {
@@ -43,8 +43,48 @@ func (m model) View() string {
 				baseView = lipgloss.JoinVertical(lipgloss.Left, title, content, help)
 			}
 		}
-	case viewState:
+	case viewState, composeState:
 		baseView = m.renderEmailView()
+	case trashState:
+		helpText := "up/down: navigate | r: restore | d: delete forever | esc/q: back"
+		listView := m.trashList.View()
+		if m.loading {
+			listView = m.spinner.View() + " Loading trash..."
+		} else if len(m.trashList.Items()) == 0 {
+			listView = "Trash is empty"
+		}
+		content := baseStyle.Width(m.width).Height(m.height - 4).Render(listView)
+		baseView = lipgloss.JoinVertical(lipgloss.Left, title, content, helpStyle.Render(helpText), m.renderStatusLine())
+	case versionsState:
+		if m.openVersion != nil && m.versionEmail != nil {
+			v := m.openVersion
+			header := headerStyle.Render(fmt.Sprintf(
+				"From:    %s\nSubject: %s\nKey:     %s\nVersion: %s (%s)",
+				m.versionEmail.fromFull(), m.versionEmail.Subject, shortKey(v.key), v.versionID, v.Description(),
+			))
+			content := bodyStyle.Width(m.width).Height(m.height - 4).Render(m.viewport.View())
+			help := helpStyle.Render("up/down: scroll | r: restore this version | esc/q: back to versions")
+			baseView = lipgloss.JoinVertical(lipgloss.Left, title, header, content, help, m.renderStatusLine())
+			break
+		}
+		listView := m.versionsList.View()
+		if m.loading {
+			listView = m.spinner.View() + " Loading versions..."
+		} else if len(m.versionsList.Items()) == 0 {
+			listView = "No deleted or overwritten messages under " + m.prefix
+		}
+		content := baseStyle.Width(m.width).Height(m.height - 4).Render(listView)
+		help := helpStyle.Render("up/down: navigate | enter: read | r: restore as current | esc/q: back")
+		baseView = lipgloss.JoinVertical(lipgloss.Left, title, content, help, m.renderStatusLine())
+	case purgeState:
+		header := headerStyle.Render("Purge: " + m.purge.query)
+		content := bodyStyle.Width(m.width).Height(m.height - 4).Render(m.viewport.View())
+		helpText := "up/down: scroll | y: delete matches | esc/q: back"
+		if m.purge.scanning {
+			helpText = m.spinner.View() + " Scanning... | esc/q: back"
+		}
+		help := helpStyle.Render(helpText)
+		baseView = lipgloss.JoinVertical(lipgloss.Left, title, header, content, help, m.renderStatusLine())
 	}
 
 	if m.state == bucketSelectionState && m.loading {
@@ -66,6 +106,31 @@ func (m model) View() string {
 		baseView = placeOverlay(4, 3, overlay, baseView)
 	}
 
+	if m.moveActive {
+		overlay := filterStyle.Render("Move to: " + m.moveInput.View())
+		baseView = placeOverlay(4, 3, overlay, baseView)
+	}
+
+	if m.labelActive {
+		overlay := filterStyle.Render("Labels: " + m.labelInput.View())
+		baseView = placeOverlay(4, 3, overlay, baseView)
+	}
+
+	if m.assignActive {
+		overlay := filterStyle.Render("Assign to: " + m.assignInput.View())
+		baseView = placeOverlay(4, 3, overlay, baseView)
+	}
+
+	if m.purgeActive {
+		overlay := filterStyle.Render("Purge: " + m.purgeInput.View())
+		baseView = placeOverlay(4, 3, overlay, baseView)
+	}
+
+	if m.searchActive && m.state == viewState {
+		overlay := filterStyle.Render("Search: " + m.searchInput.View())
+		baseView = placeOverlay(4, 3, overlay, baseView)
+	}
+
 	if m.state == confirmDeleteState {
 		modalContent := modalStyle.Render("Delete this email?\n\nPress y to confirm, n or esc to cancel.")
 		modalWidth := lipgloss.Width(modalContent)
@@ -75,12 +140,44 @@ func (m model) View() string {
 		baseView = placeOverlay(modalX, modalY, modalContent, baseView)
 	}
 
+	if m.state == trashState && m.trashConfirm {
+		prompt := "Delete this email permanently?"
+		if t, ok := m.selectedTrashItem(); ok {
+			prompt = fmt.Sprintf("Delete %s permanently, with all its versions?", shortKey(t.key))
+		}
+		modalContent := modalStyle.Render(prompt + "\n\nPress y to confirm, n or esc to cancel.")
+		modalX := (m.width - lipgloss.Width(modalContent)) / 2
+		modalY := (m.height - lipgloss.Height(modalContent)) / 2
+		baseView = placeOverlay(modalX, modalY, modalContent, baseView)
+	}
+
+	if m.state == composeState && m.draft != nil {
+		modalContent := modalStyle.Width(60).Render(m.composeText())
+		modalX := (m.width - lipgloss.Width(modalContent)) / 2
+		modalY := (m.height - lipgloss.Height(modalContent)) / 2
+		baseView = placeOverlay(modalX, modalY, modalContent, baseView)
+	}
+
+	if m.state == confirmBulkState {
+		modalContent := modalStyle.Render(m.bulkConfirmText())
+		modalX := (m.width - lipgloss.Width(modalContent)) / 2
+		modalY := (m.height - lipgloss.Height(modalContent)) / 2
+		baseView = placeOverlay(modalX, modalY, modalContent, baseView)
+	}
+
 	return baseView
 }
 
 func (m model) renderEmailView() string {
 	title := titleStyle.Width(m.width).Render("Smailer: S3 Inbox Reader")
-	help := helpStyle.Render("up/down: scroll | esc/q: back | d: delete | s: save .eml | a: save attachments")
+	helpText := "up/down: scroll | n/p: next/prev email | /: search | z: fold quotes | v: verify DKIM | f: flag | U: unread | @: assign | L: labels | r/R: reply/all | F: forward | esc/q: back | d: delete | s: save .eml | a: save attachments | A: zip attachments"
+	if m.selectedEmail != nil && m.selectedEmail.Calendar != nil {
+		helpText += " | i: save .ics"
+	}
+	if counter := m.searchCounter(); counter != "" {
+		helpText = "up/down: scroll | n/N: next/prev match | esc: clear search | " + counter
+	}
+	help := helpStyle.Render(helpText)
 	content := bodyStyle.Width(m.width).Height(m.height - 4).Render(m.viewport.View())
 	attachmentSummary := "Attachments: none"
 	if m.selectedEmail != nil && len(m.selectedEmail.Attachments) > 0 {
@@ -90,20 +187,66 @@ func (m model) renderEmailView() string {
 		}
 		attachmentSummary = "Attachments: " + strings.Join(names, ", ")
 	}
+	if receipt := renderReceipt(m.selectedEmail.Receipt); receipt != "" {
+		attachmentSummary += "\n" + receipt
+	}
+	if scan := renderScanResults(m.selectedEmail.ScanResults); scan != "" {
+		attachmentSummary += "\n" + scan
+	}
+	if secure := renderSecurity(m.selectedEmail.Security); secure != "" {
+		attachmentSummary += "\n" + secure
+	}
+	if badges := renderVerdictBadges(m.selectedEmail.Verdicts); badges != "" {
+		attachmentSummary += "\n" + badges
+	}
+	if dkim := renderDKIMResults(m.selectedEmail.DKIMResults); dkim != "" {
+		attachmentSummary += "\n" + dkim
+	}
+	if card := renderDeliveryReport(m.selectedEmail.Report); card != "" {
+		attachmentSummary += "\n" + card
+	}
+	if card := renderCalendarCard(m.selectedEmail.Calendar); card != "" {
+		attachmentSummary += "\n" + card
+	}
+	if count := len(m.selectedEmail.Diagnostics); count > 0 {
+		if m.showDiagnostics {
+			attachmentSummary += "\n" + renderDiagnostics(m.selectedEmail.Diagnostics)
+		} else {
+			attachmentSummary += fmt.Sprintf("\nDiagnostics: %d issue(s), w to show", count)
+		}
+	}
+	addressLines := fmt.Sprintf("From:    %s\nTo:      %s", m.selectedEmail.fromFull(), m.selectedEmail.toFull())
+	if len(m.selectedEmail.CcAddrs) > 0 {
+		addressLines += "\nCc:      " + formatAddresses(m.selectedEmail.CcAddrs)
+	}
+	if len(m.selectedEmail.ReplyTo) > 0 {
+		addressLines += "\nReply-To: " + formatAddresses(m.selectedEmail.ReplyTo)
+	}
+	if len(m.selectedEmail.DeliveredTo) > 0 {
+		addressLines += "\nDelivered-To: " + formatAddresses(m.selectedEmail.DeliveredTo)
+	}
+	if state := renderTriage(*m.selectedEmail); state != "" {
+		addressLines += "\n" + state
+	}
+	if labels := m.emailLabels(*m.selectedEmail); len(labels) > 0 {
+		addressLines += "\nLabels:  " + renderLabelChips(labels)
+	}
 	header := headerStyle.Render(fmt.Sprintf(
-		"From:    %s\nTo:      %s\nSubject: %s\nDate:    %s\nKey:     %s\n%s",
-		m.selectedEmail.From,
-		m.selectedEmail.To,
+		"%s\nSubject: %s\nDate:    %s\nKey:     %s\n%s",
+		addressLines,
 		m.selectedEmail.Subject,
 		m.selectedEmail.Date.Format("2006-01-02 15:04"),
 		shortKey(m.selectedEmail.Key),
 		attachmentSummary,
 	))
+	if banner := renderWarnings(m.selectedEmail.Warnings, m.width); banner != "" {
+		header = lipgloss.JoinVertical(lipgloss.Left, banner, header)
+	}
 	return lipgloss.JoinVertical(lipgloss.Left, title, header, content, help, m.renderStatusLine())
 }
 
 func (m model) renderListHelp() string {
-	parts := []string{"up/down: navigate | enter: read | d: delete | s: save .eml | /: filter | r: refresh | esc: buckets | q: quit"}
+	parts := []string{"up/down: navigate | enter: read | space: mark | *: mark all | d: delete | s: save .eml | m: move | a: archive | f: flag | U: read/unread | @: assign | L: labels | A: zip attachments | P: purge | u: undo delete | T: trash | V: versions | /: filter | r: refresh | esc: buckets | q: quit"}
 
 	visibleCount := len(m.visibleEmails)
 	if visibleCount == 0 && len(m.emails) > 0 && !m.filterActive {
@@ -116,6 +259,9 @@ func (m model) renderListHelp() string {
 	if m.hasMore {
 		countStr += " (more available)"
 	}
+	if len(m.marked) > 0 {
+		countStr += fmt.Sprintf(", %d marked", len(m.marked))
+	}
 	parts = append(parts, countStr)
 
 	help := helpStyle.Render(strings.Join(parts, " | "))